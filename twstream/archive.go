@@ -0,0 +1,279 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The SyncInterval used when an ArchiveSink does not specify one.
+const DefaultSyncInterval = 10 * time.Second
+
+// Archives messages in gzip compressed, newline delimited files partitioned
+// by the hour they were received, in UTC, named Dir/YYYY/MM/DD/HH.json.gz.
+//
+// The file for the current hour is written with a .partial suffix and
+// renamed once the hour has passed and a message for a later hour is
+// written, or the sink is closed, so complete files appear atomically.
+// Every SyncInterval the gzip stream is completed and synced to disk, so
+// that a crash loses at most the messages written since.  When the sink
+// first writes, it repairs partial files left by a crash, truncating them
+// after their last complete gzip stream; files of earlier hours are then
+// renamed, and the file of the current hour is resumed.  The concatenated
+// gzip streams of a file read as a single stream with gzip.Reader or zcat.
+//
+// Hours and sync intervals use the time read from Clock, which defaults to
+// SystemClock.
+type ArchiveSink struct {
+	Dir          string
+	SyncInterval time.Duration
+	Clock        Clock
+
+	lock      sync.Mutex
+	file      *os.File
+	z         *gzip.Writer
+	name      string
+	hour      time.Time
+	synced    time.Time
+	dirty     bool
+	recovered bool
+	buffer    []byte
+}
+
+const partialSuffix = ".partial"
+
+func NewArchiveSink(dir string) *ArchiveSink {
+	return &ArchiveSink{Dir: dir}
+}
+
+func (s *ArchiveSink) Write(msg []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := s.now()
+	hour := now.UTC().Truncate(time.Hour)
+	if s.file != nil && !hour.Equal(s.hour) {
+		if err := s.close(); err != nil {
+			return err
+		}
+	}
+	if s.file == nil {
+		if err := s.open(hour, now); err != nil {
+			return err
+		}
+	}
+	s.buffer = append(append(s.buffer[:0], msg...), '\n')
+	if _, err := s.z.Write(s.buffer); err != nil {
+		return err
+	}
+	s.dirty = true
+	interval := s.SyncInterval
+	if interval <= 0 {
+		interval = DefaultSyncInterval
+	}
+	if now.Sub(s.synced) >= interval {
+		return s.sync(now)
+	}
+	return nil
+}
+
+// Completes the current gzip stream and syncs it to disk.
+func (s *ArchiveSink) Sync() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.sync(s.now())
+}
+
+// Returns the name of the file currently being written, or "" if no file is
+// open.
+func (s *ArchiveSink) Name() string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.name
+}
+
+// Completes and renames the current file.  A later Write resumes it if the
+// hour has not passed.
+func (s *ArchiveSink) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.close()
+}
+
+func (s *ArchiveSink) now() time.Time {
+	return clockOr(s.Clock).Now()
+}
+
+// Returns the name of the complete file for the given hour.
+func (s *ArchiveSink) path(hour time.Time) string {
+	return filepath.Join(s.Dir, hour.Format("2006/01/02/15")+".json.gz")
+}
+
+func (s *ArchiveSink) open(hour time.Time, now time.Time) error {
+	name := s.path(hour)
+	partial := name + partialSuffix
+	if !s.recovered {
+		if err := s.recover(partial); err != nil {
+			return err
+		}
+		s.recovered = true
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	if _, err := os.Stat(partial); os.IsNotExist(err) {
+		// Resume the hour's complete file, if the sink was closed during
+		// the hour.
+		if err := os.Rename(name, partial); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	file, err := os.OpenFile(partial, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if err := repair(file); err != nil {
+		file.Close()
+		return err
+	}
+	if s.z == nil {
+		s.z = gzip.NewWriter(file)
+	} else {
+		s.z.Reset(file)
+	}
+	s.file = file
+	s.name = partial
+	s.hour = hour
+	s.synced = now
+	s.dirty = false
+	return nil
+}
+
+func (s *ArchiveSink) sync(now time.Time) error {
+	if s.file == nil || !s.dirty {
+		return nil
+	}
+	if err := s.z.Close(); err != nil {
+		return err
+	}
+	if err := s.file.Sync(); err != nil {
+		return err
+	}
+	s.z.Reset(s.file)
+	s.synced = now
+	s.dirty = false
+	return nil
+}
+
+func (s *ArchiveSink) close() error {
+	if s.file == nil {
+		return nil
+	}
+	err := s.sync(s.now())
+	if cerr := s.file.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(s.name, strings.TrimSuffix(s.name, partialSuffix))
+	}
+	s.file = nil
+	s.name = ""
+	return err
+}
+
+// Finishes the partial files left in Dir by an earlier process, except
+// current, which is resumed.
+func (s *ArchiveSink) recover(current string) error {
+	err := filepath.WalkDir(s.Dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || !strings.HasSuffix(path, partialSuffix) || path == current {
+			return err
+		}
+		return finishPartial(path)
+	})
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Repairs a partial file and renames it to its complete name.  If a
+// complete file already exists, the partial file's streams are appended to
+// it.
+func finishPartial(partial string) error {
+	file, err := os.OpenFile(partial, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err = repair(file); err != nil {
+		return err
+	}
+	name := strings.TrimSuffix(partial, partialSuffix)
+	if _, err := os.Stat(name); os.IsNotExist(err) {
+		return os.Rename(partial, name)
+	}
+	complete, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Seek(0, io.SeekStart); err == nil {
+		_, err = io.Copy(complete, file)
+	}
+	if cerr := complete.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Remove(partial)
+}
+
+// Truncates file after its last complete gzip stream, discarding any
+// stream left incomplete by a crash, and seeks to its end.
+func repair(file *os.File) error {
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	counter := &countingReader{reader: file}
+	var read int64
+	counter.counted = func(n int) {
+		read += int64(n)
+	}
+	reader := bufio.NewReader(counter)
+	var valid int64
+	z := &gzip.Reader{}
+	for {
+		if err := z.Reset(reader); err != nil {
+			break
+		}
+		z.Multistream(false)
+		if _, err := io.Copy(io.Discard, z); err != nil {
+			break
+		}
+		valid = read - int64(reader.Buffered())
+	}
+	if err := file.Truncate(valid); err != nil {
+		return err
+	}
+	_, err := file.Seek(valid, io.SeekStart)
+	return err
+}
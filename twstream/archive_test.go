@@ -0,0 +1,150 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Returns the messages in a gzip compressed archive file.
+func readArchive(t *testing.T, name string) []string {
+	file, err := os.Open(name)
+	if err != nil {
+		t.Fatalf("Could not open archive: %v", err)
+	}
+	defer file.Close()
+	z, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatalf("Could not read archive %v: %v", name, err)
+	}
+	data, err := io.ReadAll(z)
+	if err != nil {
+		t.Fatalf("Could not read archive %v: %v", name, err)
+	}
+	return strings.Fields(string(data))
+}
+
+func newTestArchiveSink(dir string, now *time.Time) *ArchiveSink {
+	sink := NewArchiveSink(dir)
+	sink.Clock = clockFunc(func() time.Time {
+		return *now
+	})
+	return sink
+}
+
+func TestArchiveSink(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2012, 10, 9, 1, 59, 59, 0, time.UTC)
+	sink := newTestArchiveSink(dir, &now)
+	sink.Write([]byte("a"))
+	sink.Write([]byte("b"))
+	first := filepath.Join(dir, "2012/10/09/01.json.gz")
+	if name := sink.Name(); name != first+".partial" {
+		t.Errorf("Unexpected file %v", name)
+	}
+	// Hours are taken in UTC, whatever the location of the clock.
+	now = time.Date(2012, 10, 8, 19, 0, 0, 0, time.FixedZone("PDT", -7*3600))
+	sink.Write([]byte("c"))
+	if messages := readArchive(t, first); strings.Join(messages, ",") != "a,b" {
+		t.Errorf("Unexpected messages in first hour %v", messages)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("Close returned %v", err)
+	}
+	second := filepath.Join(dir, "2012/10/09/02.json.gz")
+	if messages := readArchive(t, second); strings.Join(messages, ",") != "c" {
+		t.Errorf("Unexpected messages in second hour %v", messages)
+	}
+	// Writing again in the same hour resumes the file.
+	sink.Write([]byte("d"))
+	sink.Close()
+	if messages := readArchive(t, second); strings.Join(messages, ",") != "c,d" {
+		t.Errorf("Unexpected messages after resuming %v", messages)
+	}
+	if _, err := os.Stat(second + ".partial"); !os.IsNotExist(err) {
+		t.Errorf("Expected no partial file after Close")
+	}
+}
+
+// Writes two synced messages and one unsynced message, then abandons the
+// sink as a crash would, leaving the unsynced data partly written.
+func crashArchiveSink(dir string, now *time.Time, first string, second string) {
+	sink := newTestArchiveSink(dir, now)
+	sink.SyncInterval = time.Minute
+	sink.Write([]byte(first))
+	*now = now.Add(time.Minute)
+	sink.Write([]byte(second))
+	sink.Write([]byte("lost"))
+	sink.z.Flush()
+	sink.file.Close()
+}
+
+func TestArchiveSinkCrashRecovery(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2012, 10, 9, 1, 0, 0, 0, time.UTC)
+	crashArchiveSink(dir, &now, "a", "b")
+	// The partial file of the current hour is repaired and resumed.
+	now = now.Add(time.Minute)
+	crashArchiveSink(dir, &now, "c", "d")
+	// The partial file of an earlier hour is repaired and renamed.
+	now = now.Add(time.Hour)
+	sink := newTestArchiveSink(dir, &now)
+	sink.Write([]byte("e"))
+	if err := sink.Close(); err != nil {
+		t.Fatalf("Close returned %v", err)
+	}
+	first := filepath.Join(dir, "2012/10/09/01.json.gz")
+	if messages := readArchive(t, first); strings.Join(messages, ",") != "a,b,c,d" {
+		t.Errorf("Unexpected messages in recovered hour %v", messages)
+	}
+	second := filepath.Join(dir, "2012/10/09/02.json.gz")
+	if messages := readArchive(t, second); strings.Join(messages, ",") != "e" {
+		t.Errorf("Unexpected messages in following hour %v", messages)
+	}
+	if _, err := os.Stat(first + ".partial"); !os.IsNotExist(err) {
+		t.Errorf("Expected partial file to be renamed")
+	}
+}
+
+func TestArchiveSinkRepair(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "test.json.gz")
+	var data bytes.Buffer
+	for _, msg := range []string{"a\n", "b\n"} {
+		z := gzip.NewWriter(&data)
+		io.WriteString(z, msg)
+		z.Close()
+	}
+	complete := data.Len()
+	data.Write([]byte{0x1f, 0x8b, 8, 0, 0})
+	os.WriteFile(name, data.Bytes(), 0644)
+	file, _ := os.OpenFile(name, os.O_RDWR, 0)
+	defer file.Close()
+	if err := repair(file); err != nil {
+		t.Fatalf("repair returned %v", err)
+	}
+	if info, _ := file.Stat(); info.Size() != int64(complete) {
+		t.Errorf("Expected %v bytes after repair, got %v", complete, info.Size())
+	}
+	if messages := readArchive(t, name); strings.Join(messages, ",") != "a,b" {
+		t.Errorf("Unexpected messages after repair %v", messages)
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// The largest count parameter accepted by the streaming API.
+const MaxCount = 150000
+
+// Tracks the message rate of the most recent connection, which is used to
+// estimate how many messages were missed while disconnected.
+type backfill struct {
+	connected    time.Time
+	disconnected time.Time
+	messages     uint64
+	rate         float64
+}
+
+func (c *Connection) markConnected() {
+	c.backfill.connected = c.now()
+	c.backfill.messages = atomic.LoadUint64(&c.counters.messages)
+}
+
+func (c *Connection) markDisconnected() {
+	now := c.now()
+	elapsed := now.Sub(c.backfill.connected).Seconds()
+	messages := atomic.LoadUint64(&c.counters.messages) - c.backfill.messages
+	if messages == 0 {
+		c.backfill.rate = 0
+	} else if elapsed > 0 {
+		c.backfill.rate = float64(messages) / elapsed
+	}
+	c.backfill.disconnected = now
+	// The stream has already ended, so errors are ignored.  The cursor
+	// saved last is at most CursorInterval behind.
+	c.saveCursor(now)
+}
+
+// Returns the count parameter for the next connection: Count for the first
+// connection, and afterwards an estimate of the messages missed since the
+// last disconnect if MaxBackfill is set.
+func (c *Connection) count() int {
+	if c.conf.MaxBackfill <= 0 || c.backfill.disconnected.IsZero() {
+		return c.conf.Count
+	}
+	downtime := c.now().Sub(c.backfill.disconnected).Seconds()
+	estimate := math.Ceil(c.backfill.rate * downtime)
+	limit := c.conf.MaxBackfill
+	if limit > MaxCount {
+		limit = MaxCount
+	}
+	if estimate > float64(limit) {
+		return limit
+	}
+	return int(estimate)
+}
@@ -0,0 +1,76 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"bufio"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBackfill(t *testing.T) {
+	stop := errors.New("stop")
+	now := time.Unix(0, 0)
+	conf := &Configuration{
+		Backoff:     &Backoff{NetworkStep: time.Millisecond},
+		Count:       5,
+		MaxBackfill: 250,
+		Handler: HandlerFunc(func(msg []byte) error {
+			if string(msg) == "stop" {
+				return stop
+			}
+			now = now.Add(100 * time.Millisecond)
+			return nil
+		}),
+	}
+	dialer := &SequenceDialer{Responses: []string{
+		"HTTP/1.1 200 OK\r\n\r\n" + strings.Repeat("{}\r\n", 10),
+		"HTTP/1.1 200 OK\r\n\r\n",
+		"HTTP/1.1 200 OK\r\n\r\nstop\r\n",
+	}}
+	conf.Dialer = dialer
+	conn := newStubConnection(conf, "")
+	conf.Clock = clockFunc(func() time.Time {
+		return now
+	})
+	conn.onError = func(err error) {
+		now = now.Add(10 * time.Second)
+	}
+	if err := conn.Run(); err != stop {
+		t.Fatalf("Expected handler error, got %v", err)
+	}
+	// The first connection receives 10 messages per second and is down for
+	// 10 seconds.  The second receives none.
+	expected := []string{"5", "100", "0"}
+	for i, stub := range dialer.Conns {
+		req, err := http.ReadRequest(bufio.NewReader(&stub.Sent))
+		if err != nil {
+			t.Fatal(err)
+		}
+		count := req.URL.Query().Get("count")
+		if expected[i] == "0" && count != "" || expected[i] != "0" && count != expected[i] {
+			t.Errorf("Connection %v: expected count %v, got %q", i, expected[i], count)
+		}
+	}
+	conf.MaxBackfill = 50
+	conn.backfill.rate = 10
+	conn.backfill.disconnected = now.Add(-time.Minute)
+	if count := conn.count(); count != 50 {
+		t.Errorf("Expected count capped at 50, got %v", count)
+	}
+}
@@ -0,0 +1,220 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// Chooses the delay before each reconnection attempt made by Run.  Attempt
+// counts from 1, and is reset once a connection is established.
+type BackoffStrategy interface {
+	Delay(err error, attempt int) time.Duration
+}
+
+// Delays applied between reconnection attempts.  The three schedules follow
+// Twitter's guidelines for streaming clients:
+//
+// Network errors back off linearly, increasing by NetworkStep up to
+// NetworkMax.  HTTP errors back off exponentially, doubling from HTTPInitial
+// up to HTTPMax.  Rate limited (HTTP 420) responses back off exponentially,
+// doubling from RateLimitInitial up to RateLimitMax.  Responses with status
+// 429 are treated the same way as 420.  If the server sent a Retry-After
+// header, the delay is never shorter than the requested one.
+//
+// Jitter is the fraction, between 0 and 1, by which each delay may be
+// randomly shortened, so that many clients disconnected at once do not all
+// reconnect at the same moment.
+type Backoff struct {
+	NetworkStep      time.Duration
+	NetworkMax       time.Duration
+	HTTPInitial      time.Duration
+	HTTPMax          time.Duration
+	RateLimitInitial time.Duration
+	RateLimitMax     time.Duration
+	Jitter           float64
+}
+
+// The Backoff used when a Configuration does not specify one.
+var DefaultBackoff = Backoff{
+	NetworkStep:      250 * time.Millisecond,
+	NetworkMax:       16 * time.Second,
+	HTTPInitial:      5 * time.Second,
+	HTTPMax:          320 * time.Second,
+	RateLimitInitial: 1 * time.Minute,
+	RateLimitMax:     16 * time.Minute,
+}
+
+// Returns the delay before the given reconnection attempt (counting from 1)
+// after err occurred.
+func (b *Backoff) Delay(err error, attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	var status *StatusError
+	if errors.As(err, &status) {
+		var delay time.Duration
+		if errors.Is(status, ErrRateLimited) {
+			delay = exponential(b.RateLimitInitial, 2, b.RateLimitMax, attempt)
+		} else {
+			delay = exponential(b.HTTPInitial, 2, b.HTTPMax, attempt)
+		}
+		return retryAfter(err, jitter(delay, b.Jitter))
+	}
+	delay := b.NetworkStep * time.Duration(attempt)
+	if delay > b.NetworkMax {
+		delay = b.NetworkMax
+	}
+	return jitter(delay, b.Jitter)
+}
+
+// A single exponential schedule applied to every error: the delay starts at
+// Initial and is multiplied by Multiplier for each further attempt, up to
+// Max.  A Multiplier below 1 is treated as 2.  Jitter randomly shortens
+// delays as for Backoff, and a Retry-After header is honored.
+type ExponentialBackoff struct {
+	Initial    time.Duration
+	Multiplier float64
+	Max        time.Duration
+	Jitter     float64
+}
+
+func (b *ExponentialBackoff) Delay(err error, attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	multiplier := b.Multiplier
+	if multiplier < 1 {
+		multiplier = 2
+	}
+	delay := exponential(b.Initial, multiplier, b.Max, attempt)
+	return retryAfter(err, jitter(delay, b.Jitter))
+}
+
+// Returns initial multiplied once for each attempt after the first, capped
+// at max.
+func exponential(initial time.Duration, multiplier float64, max time.Duration, attempt int) time.Duration {
+	delay := initial
+	for i := 1; i < attempt && delay < max; i++ {
+		delay = time.Duration(float64(delay) * multiplier)
+	}
+	if delay > max {
+		delay = max
+	}
+	return delay
+}
+
+// Returns delay shortened by a random amount of up to fraction of it.
+func jitter(delay time.Duration, fraction float64) time.Duration {
+	if fraction <= 0 {
+		return delay
+	}
+	if fraction > 1 {
+		fraction = 1
+	}
+	return delay - time.Duration(rand.Float64()*fraction*float64(delay))
+}
+
+// Returns the delay requested by a Retry-After header on err, if it is
+// longer than delay.
+func retryAfter(err error, delay time.Duration) time.Duration {
+	var status *StatusError
+	if errors.As(err, &status) && status.RetryAfter > delay {
+		return status.RetryAfter
+	}
+	return delay
+}
+
+// Reads from the stream, reconnecting after errors according to the
+// configured Backoff.  Returns nil when the TTL expires, the error returned
+// by a handler which stopped the stream, or an error of class ClassConfig,
+// since reconnecting with the same Configuration cannot succeed.
+func (c *Connection) Run() error {
+	return c.RunContext(context.Background())
+}
+
+// Like Run, but stops reading or waiting to reconnect and returns ctx.Err()
+// when ctx is cancelled.
+func (c *Connection) RunContext(ctx context.Context) error {
+	backoff := c.conf.Backoff
+	if backoff == nil {
+		backoff = &DefaultBackoff
+	}
+	attempt := 0
+	refreshed := false
+	for {
+		if atomic.LoadInt32(&c.shutdown) != running {
+			return nil
+		}
+		err := c.follow(ctx)
+		if err == nil || atomic.LoadInt32(&c.shutdown) != running {
+			return nil
+		}
+		if stop, ok := err.(*stopError); ok {
+			return stop.err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if Classify(err) == ClassConfig {
+			return err
+		}
+		if c.onError != nil {
+			c.onError(err)
+		}
+		retry, cerr := c.refreshCredentials(err)
+		if cerr != nil {
+			return cerr
+		}
+		if c.established {
+			attempt = 0
+		}
+		var delay time.Duration
+		if retry && !refreshed {
+			// Fresh credentials are tried without backing off, but only
+			// once, so rejected credentials cannot cause a tight loop.
+			refreshed = true
+		} else {
+			refreshed = retry
+			attempt++
+			delay = backoff.Delay(err, attempt)
+		}
+		if retry {
+			if ready := c.credentialsReady(); ready > delay {
+				delay = ready
+			}
+		}
+		if refreshed && delay == 0 {
+			continue
+		}
+		c.countReconnect(delay)
+		c.setState(StateReconnecting)
+		c.event(&Reconnecting{Err: err, Attempt: attempt, Delay: delay})
+		wake, timer := c.after(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-c.stopping():
+			timer.Stop()
+			return nil
+		case <-wake:
+		}
+	}
+}
@@ -0,0 +1,164 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	b := &DefaultBackoff
+	network := errors.New("connection reset")
+	unavailable := &StatusError{StatusCode: 503}
+	limited := &StatusError{StatusCode: 420}
+	cases := []struct {
+		err      error
+		attempt  int
+		expected time.Duration
+	}{
+		{network, 1, 250 * time.Millisecond},
+		{network, 4, 1 * time.Second},
+		{network, 1000, 16 * time.Second},
+		{unavailable, 1, 5 * time.Second},
+		{unavailable, 3, 20 * time.Second},
+		{unavailable, 20, 320 * time.Second},
+		{limited, 1, 1 * time.Minute},
+		{limited, 2, 2 * time.Minute},
+		{limited, 20, 16 * time.Minute},
+		{&StatusError{StatusCode: 503, RetryAfter: time.Minute}, 1, time.Minute},
+		{&StatusError{StatusCode: 420, RetryAfter: time.Second}, 1, time.Minute},
+	}
+	for _, c := range cases {
+		if delay := b.Delay(c.err, c.attempt); delay != c.expected {
+			t.Errorf("Delay(%v, %v): expected %v, got %v", c.err, c.attempt, c.expected, delay)
+		}
+	}
+}
+
+func TestExponentialBackoff(t *testing.T) {
+	b := &ExponentialBackoff{
+		Initial:    time.Second,
+		Multiplier: 1.5,
+		Max:        5 * time.Second,
+	}
+	network := errors.New("connection reset")
+	cases := []struct {
+		err      error
+		attempt  int
+		expected time.Duration
+	}{
+		{network, 1, time.Second},
+		{network, 2, 1500 * time.Millisecond},
+		{network, 3, 2250 * time.Millisecond},
+		{network, 100, 5 * time.Second},
+		{&StatusError{StatusCode: 503, RetryAfter: time.Minute}, 1, time.Minute},
+	}
+	for _, c := range cases {
+		if delay := b.Delay(c.err, c.attempt); delay != c.expected {
+			t.Errorf("Delay(%v, %v): expected %v, got %v", c.err, c.attempt, c.expected, delay)
+		}
+	}
+}
+
+func TestBackoffJitter(t *testing.T) {
+	strategies := []BackoffStrategy{
+		&Backoff{NetworkStep: time.Second, NetworkMax: time.Second, Jitter: 0.5},
+		&ExponentialBackoff{Initial: time.Second, Max: time.Second, Jitter: 0.5},
+	}
+	network := errors.New("connection reset")
+	for _, b := range strategies {
+		seen := map[time.Duration]bool{}
+		for i := 0; i < 100; i++ {
+			delay := b.Delay(network, 1)
+			if delay < 500*time.Millisecond || delay > time.Second {
+				t.Fatalf("%T: delay %v outside jitter range", b, delay)
+			}
+			seen[delay] = true
+		}
+		if len(seen) < 2 {
+			t.Errorf("%T: expected jittered delays, got %v", b, seen)
+		}
+	}
+}
+
+// Returns a different canned response for each successive dial.
+type SequenceDialer struct {
+	Responses []string
+	Dials     int
+	Conns     []*StubConnection
+	Addrs     []string
+}
+
+func (d *SequenceDialer) Dial(addr string) (io.ReadWriteCloser, error) {
+	d.Addrs = append(d.Addrs, addr)
+	if d.Dials >= len(d.Responses) {
+		return nil, errors.New("No more responses")
+	}
+	response := d.Responses[d.Dials]
+	d.Dials++
+	conn := &StubConnection{Reader: strings.NewReader(response)}
+	d.Conns = append(d.Conns, conn)
+	return conn, nil
+}
+
+func TestRunReconnects(t *testing.T) {
+	stop := errors.New("stop")
+	conf := &Configuration{
+		Backoff: &Backoff{
+			NetworkStep:      time.Millisecond,
+			NetworkMax:       time.Millisecond,
+			HTTPInitial:      time.Millisecond,
+			HTTPMax:          time.Millisecond,
+			RateLimitInitial: time.Millisecond,
+			RateLimitMax:     time.Millisecond,
+		},
+		Handler: HandlerFunc(func(msg []byte) error {
+			return stop
+		}),
+	}
+	dialer := &SequenceDialer{Responses: []string{
+		"HTTP/1.1 503 Service Unavailable\r\n\r\n",
+		"HTTP/1.1 420 Enhance Your Calm\r\n\r\n",
+		"HTTP/1.1 200 OK\r\n\r\n",
+		"HTTP/1.1 200 OK\r\n\r\n{\"a\": 1}\r\n",
+	}}
+	conf.Dialer = dialer
+	conn := newStubConnection(conf, "")
+	if err := conn.Run(); err != stop {
+		t.Fatalf("Expected handler error, got %v", err)
+	}
+	if dialer.Dials != 4 {
+		t.Errorf("Expected 4 dials, got %v", dialer.Dials)
+	}
+}
+
+func TestRunContextCancelsBackoff(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	conf := &Configuration{}
+	conn := newStubConnection(conf, "HTTP/1.1 503 Service Unavailable\r\n\r\n")
+	start := time.Now()
+	if err := conn.RunContext(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Expected context.DeadlineExceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Backoff was not interrupted, returned after %v", elapsed)
+	}
+}
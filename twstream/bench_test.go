@@ -0,0 +1,150 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+const BENCHMARK_MESSAGES = 1000
+
+func benchmarkPayload(delimited bool) []byte {
+	var payload bytes.Buffer
+	for i := 0; i < BENCHMARK_MESSAGES; i++ {
+		if delimited {
+			fmt.Fprintf(&payload, "%d\r\n", len(TWEET_JSON)+2)
+		}
+		payload.WriteString(TWEET_JSON + "\r\n")
+	}
+	return payload.Bytes()
+}
+
+// Returns messages the size of typical full-fidelity firehose Tweets, which
+// are larger than a default bufio buffer.  The filler is drawn from a small
+// vocabulary so that it compresses about as well as real Tweets.
+func largePayload() []byte {
+	words := strings.Fields("the of and to in is you that it he was for on are as with his they at be this have from or one had by word but not what all were we when your can said there use an each which she do how their if will up other about out many then them these so some her would make like him into time has look two more write go see number no way could people my than first water been call who oil its now find long down day did get come made may part")
+	random := rand.New(rand.NewSource(1))
+	var payload bytes.Buffer
+	for i := 0; i < BENCHMARK_MESSAGES; i++ {
+		payload.WriteString(TWEET_JSON[:len(TWEET_JSON)-1] + `,"filler":"`)
+		for n := 0; n < 6000; {
+			word := words[random.Intn(len(words))]
+			payload.WriteString(word + " ")
+			n += len(word) + 1
+		}
+		payload.WriteString("\"}\r\n")
+	}
+	return payload.Bytes()
+}
+
+func benchmarkReadMessages(b *testing.B, delimited bool) {
+	payload := benchmarkPayload(delimited)
+	conn := &Connection{conf: &Configuration{
+		Handler: HandlerFunc(func(msg []byte) error {
+			return nil
+		}),
+	}}
+	b.SetBytes(int64(len(payload)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err := conn.readBody(bytes.NewReader(payload))
+		if err != io.EOF {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkReadMessages(b *testing.B) {
+	benchmarkReadMessages(b, false)
+}
+
+func BenchmarkReadDelimitedMessages(b *testing.B) {
+	benchmarkReadMessages(b, true)
+}
+
+// Reads a response with the given headers and body through Read, reporting
+// the message rate reached on a single core.
+func benchmarkRead(b *testing.B, conf *Configuration, header string, body []byte) {
+	response := "HTTP/1.1 200 OK\r\n" + header + "\r\n" + string(body)
+	conf.Handler = HandlerFunc(func(msg []byte) error {
+		return nil
+	})
+	conn := newStubConnection(conf, response)
+	b.SetBytes(int64(len(response)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		conf.Dialer = &StubDialer{Response: response}
+		if err := conn.Read(); err != io.EOF {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(b.N*BENCHMARK_MESSAGES)/b.Elapsed().Seconds(), "msgs/s")
+}
+
+func gzipPayload(payload []byte) []byte {
+	var compressed bytes.Buffer
+	z := gzip.NewWriter(&compressed)
+	z.Write(payload)
+	z.Close()
+	return compressed.Bytes()
+}
+
+func BenchmarkRead(b *testing.B) {
+	benchmarkRead(b, &Configuration{}, "", benchmarkPayload(false))
+}
+
+func BenchmarkReadChunked(b *testing.B) {
+	body := chunk(string(benchmarkPayload(false)), 8192)
+	benchmarkRead(b, &Configuration{Chunked: true}, "Transfer-Encoding: chunked\r\n", []byte(body))
+}
+
+func BenchmarkReadGZip(b *testing.B) {
+	body := gzipPayload(benchmarkPayload(false))
+	benchmarkRead(b, &Configuration{GZip: true}, "Content-Encoding: gzip\r\n", body)
+}
+
+func BenchmarkReadChunkedGZip(b *testing.B) {
+	body := chunk(string(gzipPayload(benchmarkPayload(false))), 8192)
+	conf := &Configuration{Chunked: true, GZip: true}
+	header := "Transfer-Encoding: chunked\r\nContent-Encoding: gzip\r\n"
+	benchmarkRead(b, conf, header, []byte(body))
+}
+
+func BenchmarkReadLarge(b *testing.B) {
+	benchmarkRead(b, &Configuration{}, "", largePayload())
+}
+
+func BenchmarkReadLargeChunkedGZip(b *testing.B) {
+	body := chunk(string(gzipPayload(largePayload())), 8192)
+	conf := &Configuration{Chunked: true, GZip: true}
+	header := "Transfer-Encoding: chunked\r\nContent-Encoding: gzip\r\n"
+	benchmarkRead(b, conf, header, []byte(body))
+}
+
+func BenchmarkReadLargeChunkedGZipPipelined(b *testing.B) {
+	body := chunk(string(gzipPayload(largePayload())), 8192)
+	conf := &Configuration{Chunked: true, GZip: true, PipelineDepth: 8}
+	header := "Transfer-Encoding: chunked\r\nContent-Encoding: gzip\r\n"
+	benchmarkRead(b, conf, header, []byte(body))
+}
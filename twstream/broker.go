@@ -0,0 +1,142 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Fans out the messages of a single stream to any number of subscribers, so
+// that several pipelines can share one authenticated connection.  A Broker
+// is a Sink; set it as the connection's Sink and Subscribe each consumer.
+type Broker struct {
+	lock          sync.RWMutex
+	subscriptions map[*Subscription]bool
+	closed        bool
+}
+
+// Receives the messages written to a Broker which pass its filter.  Messages
+// received on C are shared between subscribers and must not be modified.
+type Subscription struct {
+	C       <-chan []byte
+	c       chan []byte
+	filter  func(msg []byte) bool
+	policy  OverflowPolicy
+	dropped uint64
+	broker  *Broker
+	done    chan bool
+	once    sync.Once
+}
+
+func NewBroker() *Broker {
+	return &Broker{subscriptions: map[*Subscription]bool{}}
+}
+
+// Returns a new blocking subscription whose channel has the given buffer
+// size.  If filter is non-nil, only messages for which it returns true are
+// sent.  Subscribing to a closed Broker returns a subscription whose channel
+// is already closed.
+func (b *Broker) Subscribe(size int, filter func(msg []byte) bool) *Subscription {
+	return b.SubscribePolicy(size, Block, filter)
+}
+
+// Like Subscribe, but policy determines whether the Broker waits for or
+// drops messages when the subscription's channel is full.  Non-blocking
+// subscriptions keep slow consumers from stalling the other subscribers.
+func (b *Broker) SubscribePolicy(size int, policy OverflowPolicy, filter func(msg []byte) bool) *Subscription {
+	c := make(chan []byte, size)
+	s := &Subscription{
+		C:      c,
+		c:      c,
+		filter: filter,
+		policy: policy,
+		broker: b,
+		done:   make(chan bool),
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.closed {
+		close(c)
+	} else {
+		b.subscriptions[s] = true
+	}
+	return s
+}
+
+// Sends a single shared copy of msg to every subscription whose filter
+// accepts it, blocking until each has received it.
+func (b *Broker) Write(msg []byte) error {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	var shared []byte
+	for s := range b.subscriptions {
+		if s.filter != nil && !s.filter(msg) {
+			continue
+		}
+		if shared == nil {
+			shared = append([]byte(nil), msg...)
+		}
+		if dropped := send(s.c, shared, s.policy, s.done); dropped > 0 {
+			atomic.AddUint64(&s.dropped, dropped)
+		}
+	}
+	return nil
+}
+
+// Closes all subscriptions.  Further writes are discarded.
+func (b *Broker) Close() error {
+	// Writes blocked on a full subscription hold the read lock, so they are
+	// released before the write lock is taken.
+	b.lock.RLock()
+	for s := range b.subscriptions {
+		s.stop()
+	}
+	b.lock.RUnlock()
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.closed = true
+	for s := range b.subscriptions {
+		s.stop()
+		delete(b.subscriptions, s)
+		close(s.c)
+	}
+	return nil
+}
+
+// Abandons any send to the subscription which is blocked or yet to start.
+func (s *Subscription) stop() {
+	s.once.Do(func() {
+		close(s.done)
+	})
+}
+
+// Returns the number of messages dropped because C was full.
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Removes the subscription from its Broker and closes C.  Safe to call while
+// the Broker is blocked sending to this subscription.
+func (s *Subscription) Unsubscribe() {
+	s.stop()
+	b := s.broker
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.subscriptions[s] {
+		delete(b.subscriptions, s)
+		close(s.c)
+	}
+}
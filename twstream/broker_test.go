@@ -0,0 +1,119 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestBroker(t *testing.T) {
+	broker := NewBroker()
+	all := broker.Subscribe(10, nil)
+	deletes := broker.Subscribe(10, func(msg []byte) bool {
+		return bytes.HasPrefix(msg, []byte("{\"delete\""))
+	})
+	conf := &Configuration{Sink: broker}
+	response := "HTTP/1.1 200 OK\r\n\r\n" +
+		TWEET_JSON + "\r\n" +
+		"{\"delete\":{}}\r\n"
+	conn := newStubConnection(conf, response)
+	if err := conn.Read(); err != io.EOF {
+		t.Fatalf("Expected EOF, got %v", err)
+	}
+	broker.Close()
+
+	var received []string
+	for msg := range all.C {
+		received = append(received, string(msg))
+	}
+	if len(received) != 2 {
+		t.Errorf("Expected 2 messages, got %q", received)
+	}
+	received = nil
+	for msg := range deletes.C {
+		received = append(received, string(msg))
+	}
+	if len(received) != 1 || received[0] != "{\"delete\":{}}" {
+		t.Errorf("Unexpected filtered messages %q", received)
+	}
+}
+
+func TestBrokerUnsubscribeWhileBlocked(t *testing.T) {
+	broker := NewBroker()
+	stalled := broker.Subscribe(0, nil)
+	done := make(chan bool)
+	go func() {
+		broker.Write([]byte("{\"a\": 1}"))
+		done <- true
+	}()
+	time.Sleep(10 * time.Millisecond)
+	stalled.Unsubscribe()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Write remained blocked after Unsubscribe")
+	}
+	if _, ok := <-stalled.C; ok {
+		t.Errorf("Expected channel to be closed")
+	}
+	stalled.Unsubscribe()
+	if err := broker.Write([]byte("{\"b\": 2}")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBrokerCloseWhileBlocked(t *testing.T) {
+	broker := NewBroker()
+	stalled := broker.Subscribe(0, nil)
+	written := make(chan bool)
+	go func() {
+		broker.Write([]byte("{\"a\": 1}"))
+		written <- true
+	}()
+	time.Sleep(10 * time.Millisecond)
+	closed := make(chan bool)
+	go func() {
+		broker.Close()
+		closed <- true
+	}()
+	for _, c := range []chan bool{written, closed} {
+		select {
+		case <-c:
+		case <-time.After(time.Second):
+			t.Fatal("Close deadlocked with a blocked Write")
+		}
+	}
+	if _, ok := <-stalled.C; ok {
+		t.Errorf("Expected channel to be closed")
+	}
+}
+
+func TestBrokerSlowSubscriber(t *testing.T) {
+	broker := NewBroker()
+	slow := broker.SubscribePolicy(1, DropNewest, nil)
+	fast := broker.Subscribe(10, nil)
+	for i := 0; i < 5; i++ {
+		broker.Write([]byte("{}"))
+	}
+	if len(fast.C) != 5 {
+		t.Errorf("Expected 5 messages for fast subscriber, got %v", len(fast.C))
+	}
+	if slow.Dropped() != 4 {
+		t.Errorf("Expected 4 dropped messages, got %v", slow.Dropped())
+	}
+}
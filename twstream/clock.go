@@ -0,0 +1,181 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// The source of time used by a Connection for its TTL, idle and watchdog
+// timeouts, backoff delays, drain timeout, statistics and message
+// timestamps, and by the other types in this package which keep time, each
+// of which has a Clock field.  Tests may set these to a FakeClock to
+// control time deterministically.
+type Clock interface {
+	Now() time.Time
+	// Calls f in its own goroutine once d has elapsed, like time.AfterFunc.
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// A timer returned by Clock.AfterFunc.  *time.Timer implements Timer.
+type Timer interface {
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// The Clock used when a Configuration does not specify one, which reads
+// the system time.
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+// A Clock which only moves when told to, for testing.  Timer functions are
+// called synchronously by Advance, in the order they are due, rather than
+// from their own goroutines.
+type FakeClock struct {
+	lock   sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	clock *FakeClock
+	when  time.Time
+	f     func()
+}
+
+// Returns a FakeClock reading now.
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+func (c *FakeClock) AfterFunc(d time.Duration, f func()) Timer {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	t := &fakeTimer{clock: c, f: f}
+	c.schedule(t, d)
+	return t
+}
+
+// Moves the clock forward by d, calling the functions of timers which
+// become due.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.lock.Lock()
+	end := c.now.Add(d)
+	for len(c.timers) > 0 && !c.timers[0].when.After(end) {
+		t := c.timers[0]
+		c.timers = c.timers[1:]
+		if t.when.After(c.now) {
+			c.now = t.when
+		}
+		c.lock.Unlock()
+		t.f()
+		c.lock.Lock()
+	}
+	c.now = end
+	c.lock.Unlock()
+}
+
+// Returns the number of timers waiting to fire, so that tests can wait
+// until the code under test has started a timer before advancing.
+func (c *FakeClock) Timers() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return len(c.timers)
+}
+
+// Must be called with the lock held.
+func (c *FakeClock) schedule(t *fakeTimer, d time.Duration) {
+	t.when = c.now.Add(d)
+	c.timers = append(c.timers, t)
+	sort.SliceStable(c.timers, func(i, j int) bool {
+		return c.timers[i].when.Before(c.timers[j].when)
+	})
+}
+
+// Must be called with the lock held.  Reports whether t was waiting.
+func (c *FakeClock) remove(t *fakeTimer) bool {
+	for i, other := range c.timers {
+		if other == t {
+			c.timers = append(c.timers[:i], c.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.lock.Lock()
+	defer t.clock.lock.Unlock()
+	return t.clock.remove(t)
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.clock.lock.Lock()
+	defer t.clock.lock.Unlock()
+	active := t.clock.remove(t)
+	t.clock.schedule(t, d)
+	return active
+}
+
+// Returns clock, or SystemClock if it is nil.
+func clockOr(clock Clock) Clock {
+	if clock != nil {
+		return clock
+	}
+	return SystemClock
+}
+
+// Returns a channel which is closed once d has elapsed on clock, and the
+// Timer which will close it.
+func after(clock Clock, d time.Duration) (<-chan struct{}, Timer) {
+	wake := make(chan struct{})
+	timer := clockOr(clock).AfterFunc(d, func() {
+		close(wake)
+	})
+	return wake, timer
+}
+
+// Returns the configured Clock.
+func (c *Connection) timeSource() Clock {
+	return clockOr(c.conf.Clock)
+}
+
+// Returns the current time on the configured Clock.
+func (c *Connection) now() time.Time {
+	return c.timeSource().Now()
+}
+
+// Returns a channel which is closed once d has elapsed on the configured
+// Clock, and the Timer which will close it.
+func (c *Connection) after(d time.Duration) (<-chan struct{}, Timer) {
+	return after(c.conf.Clock, d)
+}
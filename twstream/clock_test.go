@@ -0,0 +1,155 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// Adapts a function returning the time to the Clock interface, using real
+// timers.
+type clockFunc func() time.Time
+
+func (f clockFunc) Now() time.Time {
+	return f()
+}
+
+func (f clockFunc) AfterFunc(d time.Duration, fn func()) Timer {
+	return time.AfterFunc(d, fn)
+}
+
+// Waits until count timers are waiting on clock.
+func waitForTimers(t *testing.T, clock *FakeClock, count int) {
+	deadline := time.Now().Add(time.Second)
+	for clock.Timers() != count {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %v timers, got %v", count, clock.Timers())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestFakeClock(t *testing.T) {
+	start := time.Unix(1349740800, 0)
+	clock := NewFakeClock(start)
+	var fired []string
+	clock.AfterFunc(2*time.Second, func() {
+		fired = append(fired, "b")
+	})
+	clock.AfterFunc(time.Second, func() {
+		fired = append(fired, "a:"+clock.Now().Sub(start).String())
+	})
+	stopped := clock.AfterFunc(time.Second, func() {
+		fired = append(fired, "stopped")
+	})
+	reset := clock.AfterFunc(time.Second, func() {
+		fired = append(fired, "reset")
+	})
+	if !stopped.Stop() {
+		t.Errorf("Expected Stop to report a waiting timer")
+	}
+	reset.Reset(3 * time.Second)
+	clock.Advance(1500 * time.Millisecond)
+	if len(fired) != 1 || fired[0] != "a:1s" {
+		t.Errorf("Unexpected timers fired: %v", fired)
+	}
+	clock.Advance(2 * time.Second)
+	if len(fired) != 3 || fired[1] != "b" || fired[2] != "reset" {
+		t.Errorf("Unexpected timers fired: %v", fired)
+	}
+	if now := clock.Now(); !now.Equal(start.Add(3500 * time.Millisecond)) {
+		t.Errorf("Unexpected time %v", now)
+	}
+	if stopped.Stop() || clock.Timers() != 0 {
+		t.Errorf("Expected no waiting timers")
+	}
+}
+
+func TestFakeClockBackoff(t *testing.T) {
+	stop := errors.New("stop")
+	clock := NewFakeClock(time.Unix(1349740800, 0))
+	delays := make(chan time.Duration, 10)
+	conf := &Configuration{
+		Clock:           clock,
+		LifecycleEvents: true,
+		EventHandler: EventHandlerFunc(func(event Event) {
+			if reconnecting, ok := event.(*Reconnecting); ok {
+				delays <- reconnecting.Delay
+			}
+		}),
+		Handler: HandlerFunc(func(msg []byte) error {
+			return stop
+		}),
+	}
+	conf.Dialer = &SequenceDialer{Responses: []string{
+		"HTTP/1.1 503 Service Unavailable\r\n\r\n",
+		"HTTP/1.1 503 Service Unavailable\r\n\r\n",
+		"HTTP/1.1 200 OK\r\n\r\n{\"a\": 1}\r\n",
+	}}
+	conn := newStubConnection(conf, "")
+	result := make(chan error, 1)
+	go func() {
+		result <- conn.Run()
+	}()
+	for _, expected := range []time.Duration{5 * time.Second, 10 * time.Second} {
+		if delay := <-delays; delay != expected {
+			t.Fatalf("Expected delay %v, got %v", expected, delay)
+		}
+		waitForTimers(t, clock, 1)
+		clock.Advance(expected - time.Millisecond)
+		if clock.Timers() != 1 {
+			t.Fatalf("Reconnected before the backoff elapsed")
+		}
+		clock.Advance(time.Millisecond)
+	}
+	if err := <-result; err != stop {
+		t.Errorf("Expected handler error, got %v", err)
+	}
+}
+
+func TestFakeClockTTL(t *testing.T) {
+	clock := NewFakeClock(time.Unix(1349740800, 0))
+	conf := &Configuration{
+		Clock:   clock,
+		TTL:     time.Hour,
+		Handler: &CollectingHandler{},
+	}
+	conf.Dialer = &PipeDialer{Server: func(server net.Conn) {
+		if err := respond(server, "HTTP/1.1 200 OK\r\n\r\n"); err != nil {
+			return
+		}
+		io.Copy(io.Discard, server)
+	}}
+	conn := newStubConnection(conf, "")
+	result := make(chan error, 1)
+	go func() {
+		result <- conn.Read()
+	}()
+	waitForTimers(t, clock, 1)
+	clock.Advance(time.Hour - time.Second)
+	select {
+	case err := <-result:
+		t.Fatalf("Read returned %v before the TTL expired", err)
+	default:
+	}
+	clock.Advance(time.Second)
+	if err := <-result; err != nil {
+		t.Errorf("Expected nil, got %v", err)
+	}
+}
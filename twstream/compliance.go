@@ -0,0 +1,171 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"fmt"
+)
+
+// Returns the URL of one partition of the enterprise compliance firehose
+// with the given account name and stream label.  The compliance firehose
+// is split into partitions numbered from 1, each of which must be consumed
+// by its own connection.
+func ComplianceURL(account string, label string, partition int) string {
+	return fmt.Sprintf("https://gnip-stream.twitter.com/stream/compliance/accounts/%v/publishers/twitter/%v.json?partition=%v", account, label, partition)
+}
+
+// Returns a Connection to a partition of the enterprise compliance
+// firehose, authorized with HTTP basic auth.  The compliance firehose
+// delivers Tweet deletions, user deletions, protections and suspensions,
+// withholdings and geo scrubs for all public Tweets, which are passed to the
+// ComplianceHandler and EventHandler if set.  Conf is copied as by
+// NewSampleStream, and Chunked and GZip are always set.
+func NewComplianceStream(account string, label string, partition int, username string, password string, conf *Configuration) *Connection {
+	conn := newEndpointStream(ComplianceURL(account, label, partition), "GET", nil, conf)
+	conn.conf.Username = username
+	conn.conf.Password = password
+	return conn
+}
+
+// Kinds of user compliance message.
+const (
+	UserDelete    = "user_delete"
+	UserUndelete  = "user_undelete"
+	UserProtect   = "user_protect"
+	UserUnprotect = "user_unprotect"
+	UserSuspend   = "user_suspend"
+	UserUnsuspend = "user_unsuspend"
+)
+
+// Sent by the compliance firehose when the state of a user's account
+// changes, such as {"user_delete": {"id": 12, ...}}.  Kind is one of the
+// User constants.
+type UserComplianceNotice struct {
+	Kind        string `json:"-"`
+	ID          int64  `json:"id"`
+	IDStr       string `json:"id_str"`
+	TimestampMs string `json:"timestamp_ms"`
+}
+
+// An action which applications storing Tweets must take to comply with
+// Twitter's terms.
+type ComplianceAction int
+
+const (
+	// Delete the Tweet TweetID.
+	ActionDeleteTweet ComplianceAction = iota + 1
+	// Delete, or stop displaying, all Tweets and data of the user UserID,
+	// whose account was deleted or suspended.
+	ActionDeleteUser
+	// The user UserID, whose account was deleted or suspended, has been
+	// restored, so their content may be displayed again.
+	ActionRestoreUser
+	// Stop displaying the Tweets of the user UserID publicly.
+	ActionProtectUser
+	// The Tweets of the user UserID may be displayed publicly again.
+	ActionUnprotectUser
+	// Remove location data from the Tweets of the user UserID, up to and
+	// including UpToTweetID.
+	ActionScrubGeo
+	// Stop displaying the Tweet TweetID in Countries.
+	ActionWithholdTweet
+	// Stop displaying the user UserID in Countries.
+	ActionWithholdUser
+)
+
+var complianceActionNames = []string{"", "delete_tweet", "delete_user",
+	"restore_user", "protect_user", "unprotect_user", "scrub_geo",
+	"withhold_tweet", "withhold_user"}
+
+func (a ComplianceAction) String() string {
+	if a > 0 && int(a) < len(complianceActionNames) {
+		return complianceActionNames[a]
+	}
+	return fmt.Sprintf("ComplianceAction(%d)", int(a))
+}
+
+// A compliance action required by an event read from a stream.  Only the
+// fields relevant to the Action are set, and Event is the event which
+// required it.
+type ComplianceEvent struct {
+	Action      ComplianceAction
+	TweetID     int64
+	UserID      int64
+	UpToTweetID int64
+	Countries   []string
+	Event       Event
+}
+
+// Carries out compliance actions, such as deleting stored Tweets.
+// Returning a non-nil error stops the stream, so that actions are not
+// silently lost.
+type ComplianceHandler interface {
+	HandleCompliance(event *ComplianceEvent) error
+}
+
+// Adapts an ordinary function to the ComplianceHandler interface.
+type ComplianceHandlerFunc func(event *ComplianceEvent) error
+
+func (f ComplianceHandlerFunc) HandleCompliance(event *ComplianceEvent) error {
+	return f(event)
+}
+
+// Returns the compliance action required by event, or nil if it requires
+// none.  Useful for applications which receive events through their own
+// EventHandler.
+func NewComplianceEvent(event Event) *ComplianceEvent {
+	switch e := event.(type) {
+	case *DeleteNotice:
+		return &ComplianceEvent{Action: ActionDeleteTweet, TweetID: e.ID, UserID: e.UserID, Event: event}
+	case *ScrubGeoNotice:
+		return &ComplianceEvent{Action: ActionScrubGeo, UserID: e.UserID, UpToTweetID: e.UpToStatusID, Event: event}
+	case *StatusWithheldNotice:
+		return &ComplianceEvent{Action: ActionWithholdTweet, TweetID: e.ID, UserID: e.UserID, Countries: e.WithheldInCountries, Event: event}
+	case *UserWithheldNotice:
+		return &ComplianceEvent{Action: ActionWithholdUser, UserID: e.ID, Countries: e.WithheldInCountries, Event: event}
+	case *UserComplianceNotice:
+		var action ComplianceAction
+		switch e.Kind {
+		case UserDelete, UserSuspend:
+			action = ActionDeleteUser
+		case UserUndelete, UserUnsuspend:
+			action = ActionRestoreUser
+		case UserProtect:
+			action = ActionProtectUser
+		case UserUnprotect:
+			action = ActionUnprotectUser
+		default:
+			return nil
+		}
+		return &ComplianceEvent{Action: action, UserID: e.ID, Event: event}
+	}
+	return nil
+}
+
+// Passes the compliance action required by msg, if any, to the
+// ComplianceHandler.
+func (c *Connection) comply(msg []byte) error {
+	event, err := decodeEvent(msg)
+	if err != nil {
+		c.countDecodeError()
+		return err
+	}
+	if compliance := NewComplianceEvent(event); compliance != nil {
+		if err := c.conf.ComplianceHandler.HandleCompliance(compliance); err != nil {
+			return &stopError{err}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestComplianceURL(t *testing.T) {
+	expected := "https://gnip-stream.twitter.com/stream/compliance/accounts/acme/publishers/twitter/prod.json?partition=2"
+	if url := ComplianceURL("acme", "prod", 2); url != expected {
+		t.Errorf("Expected %v, got %v", expected, url)
+	}
+	conn := NewComplianceStream("acme", "prod", 2, "user", "pass", nil)
+	if conn.conf.URL.String() != expected || conn.conf.Username != "user" || !conn.conf.GZip {
+		t.Errorf("Unexpected configuration %+v", conn.conf)
+	}
+}
+
+func TestDecodeUserCompliance(t *testing.T) {
+	for _, kind := range []string{UserDelete, UserUndelete, UserProtect, UserUnprotect, UserSuspend, UserUnsuspend} {
+		msg := fmt.Sprintf(`{"%v":{"id":12,"id_str":"12","timestamp_ms":"1349740800000"}}`, kind)
+		event, err := decodeEvent([]byte(msg))
+		if err != nil {
+			t.Fatalf("%v: %v", kind, err)
+		}
+		expected := &UserComplianceNotice{Kind: kind, ID: 12, IDStr: "12", TimestampMs: "1349740800000"}
+		if !reflect.DeepEqual(event, expected) {
+			t.Errorf("%v: expected %+v, got %+v", kind, expected, event)
+		}
+	}
+}
+
+func TestComplianceHandler(t *testing.T) {
+	var events []*ComplianceEvent
+	handler := &CollectingHandler{}
+	conf := &Configuration{
+		Handler: handler,
+		// Compliance messages must be handled even if filtered out.
+		Predicate: func(msg []byte) bool {
+			return false
+		},
+		ComplianceHandler: ComplianceHandlerFunc(func(event *ComplianceEvent) error {
+			events = append(events, event)
+			return nil
+		}),
+	}
+	response := "HTTP/1.1 200 OK\r\n\r\n" +
+		`{"delete":{"status":{"id":1,"user_id":12}}}` + "\r\n" +
+		`{"user_suspend":{"id":12}}` + "\r\n" +
+		`{"user_unprotect":{"id":13}}` + "\r\n" +
+		`{"scrub_geo":{"user_id":14,"up_to_status_id":5}}` + "\r\n" +
+		`{"status_withheld":{"id":2,"user_id":15,"withheld_in_countries":["DE"]}}` + "\r\n" +
+		`{"user_withheld":{"id":16,"withheld_in_countries":["FR"]}}` + "\r\n" +
+		WARNING_JSON + "\r\n" +
+		TWEET_JSON + "\r\n"
+	conn := newStubConnection(conf, response)
+	if err := conn.Read(); err != io.EOF {
+		t.Fatalf("Expected EOF, got %v", err)
+	}
+	expected := []ComplianceEvent{
+		{Action: ActionDeleteTweet, TweetID: 1, UserID: 12},
+		{Action: ActionDeleteUser, UserID: 12},
+		{Action: ActionUnprotectUser, UserID: 13},
+		{Action: ActionScrubGeo, UserID: 14, UpToTweetID: 5},
+		{Action: ActionWithholdTweet, TweetID: 2, UserID: 15, Countries: []string{"DE"}},
+		{Action: ActionWithholdUser, UserID: 16, Countries: []string{"FR"}},
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("Expected %v events, got %v", len(expected), len(events))
+	}
+	for i, event := range events {
+		if event.Event == nil {
+			t.Errorf("Event %v: missing source event", i)
+		}
+		event.Event = nil
+		if !reflect.DeepEqual(*event, expected[i]) {
+			t.Errorf("Event %v: expected %+v, got %+v", i, expected[i], *event)
+		}
+	}
+	if len(handler.Messages) != 0 {
+		t.Errorf("Expected messages to be filtered, got %v", handler.Messages)
+	}
+}
+
+func TestComplianceHandlerError(t *testing.T) {
+	failed := errors.New("failed")
+	handler := &CollectingHandler{}
+	conf := &Configuration{
+		Handler: handler,
+		ComplianceHandler: ComplianceHandlerFunc(func(event *ComplianceEvent) error {
+			return failed
+		}),
+	}
+	response := "HTTP/1.1 200 OK\r\n\r\n" +
+		TWEET_JSON + "\r\n" +
+		`{"user_delete":{"id":12}}` + "\r\n" +
+		TWEET_JSON + "\r\n"
+	conn := newStubConnection(conf, response)
+	if err := conn.Run(); err != failed {
+		t.Fatalf("Expected handler error, got %v", err)
+	}
+	if len(handler.Messages) != 1 {
+		t.Errorf("Expected 1 message before the error, got %v", len(handler.Messages))
+	}
+}
+
+func TestComplianceStreamWithoutUsername(t *testing.T) {
+	conf := &Configuration{ComplianceHandler: ComplianceHandlerFunc(func(event *ComplianceEvent) error {
+		return nil
+	})}
+	conn := NewComplianceStream("acme", "prod", 1, "", "", conf)
+	conn.conf.Dialer = &StubDialer{Response: "HTTP/1.1 200 OK\r\n\r\n"}
+	if err := conn.Run(); !errors.Is(err, ErrNoCredentials) {
+		t.Errorf("Expected ErrNoCredentials, got %v", err)
+	}
+}
+
+func TestComplianceActionString(t *testing.T) {
+	if s := ActionWithholdUser.String(); s != "withhold_user" {
+		t.Errorf("Unexpected name %v", s)
+	}
+	if s := ComplianceAction(42).String(); s != "ComplianceAction(42)" {
+		t.Errorf("Unexpected name %v", s)
+	}
+}
@@ -0,0 +1,196 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"errors"
+	"github.com/kurrik/golibs/twurlrc"
+	"sync"
+	"time"
+)
+
+// Supplies new credentials when the stream rejects the current ones with a
+// 401 response or rate limits them, for example by re-reading a twurlrc
+// file or rotating to another account.  Err is the *StatusError which
+// rejected the credentials.  Returning an error stops Run with that error,
+// and returning nil credentials stops it with ErrNoCredentials.
+type CredentialProvider interface {
+	Credentials(err error) (*twurlrc.Credentials, error)
+}
+
+// Adapts an ordinary function to the CredentialProvider interface.
+type CredentialProviderFunc func(err error) (*twurlrc.Credentials, error)
+
+func (f CredentialProviderFunc) Credentials(err error) (*twurlrc.Credentials, error) {
+	return f(err)
+}
+
+// Replaces the connection's credentials from the CredentialProvider after
+// err, reporting whether they were replaced.
+func (c *Connection) refreshCredentials(err error) (bool, error) {
+	if c.conf.CredentialProvider == nil {
+		return false, nil
+	}
+	if !errors.Is(err, ErrUnauthorized) && !errors.Is(err, ErrRateLimited) {
+		return false, nil
+	}
+	cred, err := c.conf.CredentialProvider.Credentials(err)
+	if err != nil {
+		return false, err
+	}
+	if cred == nil {
+		return false, classify(ClassConfig, ErrNoCredentials)
+	}
+	c.cred = cred
+	return true, nil
+}
+
+// Implemented by CredentialProviders, such as CredentialPool, whose
+// credentials may not be usable until a cooldown has passed.  Run waits
+// for the delay returned by Ready before using the credentials last
+// returned by Credentials.
+type CredentialWaiter interface {
+	CredentialProvider
+	Ready() time.Duration
+}
+
+// Returns how long to wait before using refreshed credentials.
+func (c *Connection) credentialsReady() time.Duration {
+	if w, ok := c.conf.CredentialProvider.(CredentialWaiter); ok {
+		return w.Ready()
+	}
+	return 0
+}
+
+// A CredentialProvider which rotates between several accounts.  When an
+// account is rejected it is rested for a cooldown given by Backoff, which
+// grows while the account keeps being rejected, and the pool moves on to
+// the account which is next ready for use.  An account which was in use for
+// longer than its last cooldown before being rejected again starts backing
+// off from the first attempt.
+//
+// A pool tracks the account currently in use, so it should provide
+// credentials for a single Connection, which is created with Current:
+//
+//	pool := twstream.NewCredentialPool(first, second, third)
+//	conf.CredentialProvider = pool
+//	conn := twstream.NewConnection(conf, pool.Current())
+type CredentialPool struct {
+	Accounts []*twurlrc.Credentials
+	// Cooldowns for rejected accounts.  Defaults to DefaultBackoff.
+	Backoff BackoffStrategy
+	// Times cooldowns.  Defaults to SystemClock.
+	Clock   Clock
+	lock    sync.Mutex
+	current int
+	state   []poolAccount
+}
+
+// The rejection history of an account in a CredentialPool.
+type poolAccount struct {
+	inUse    time.Time
+	ready    time.Time
+	cooldown time.Duration
+	attempts int
+}
+
+// Returns a pool rotating between the given credentials, starting with the
+// first.
+func NewCredentialPool(credentials ...*twurlrc.Credentials) *CredentialPool {
+	return &CredentialPool{Accounts: credentials}
+}
+
+func (p *CredentialPool) now() time.Time {
+	return clockOr(p.Clock).Now()
+}
+
+// Allocates account state for each set of credentials.
+func (p *CredentialPool) init() {
+	if len(p.state) != len(p.Accounts) {
+		p.state = make([]poolAccount, len(p.Accounts))
+		now := p.now()
+		for i := range p.state {
+			p.state[i].inUse = now
+		}
+		p.current = 0
+	}
+}
+
+// Returns the credentials currently in use.
+func (p *CredentialPool) Current() *twurlrc.Credentials {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.init()
+	if len(p.Accounts) == 0 {
+		return nil
+	}
+	return p.Accounts[p.current]
+}
+
+// Rests the current account after it was rejected with err, and returns the
+// account which will be ready soonest, preferring the next in turn.
+func (p *CredentialPool) Credentials(err error) (*twurlrc.Credentials, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.init()
+	if len(p.Accounts) == 0 {
+		return nil, errors.New("CredentialPool has no credentials")
+	}
+	backoff := p.Backoff
+	if backoff == nil {
+		backoff = &DefaultBackoff
+	}
+	now := p.now()
+	rejected := &p.state[p.current]
+	if now.Sub(rejected.inUse) > rejected.cooldown {
+		rejected.attempts = 0
+	}
+	rejected.attempts++
+	rejected.cooldown = backoff.Delay(err, rejected.attempts)
+	rejected.ready = now.Add(rejected.cooldown)
+	next := -1
+	for i := 1; i <= len(p.state); i++ {
+		candidate := (p.current + i) % len(p.state)
+		if !p.state[candidate].ready.After(now) {
+			next = candidate
+			break
+		}
+	}
+	if next < 0 {
+		next = 0
+		for i := range p.state {
+			if p.state[i].ready.Before(p.state[next].ready) {
+				next = i
+			}
+		}
+	}
+	p.current = next
+	p.state[next].inUse = now
+	return p.Accounts[next], nil
+}
+
+// Returns how long until the current account's cooldown ends.
+func (p *CredentialPool) Ready() time.Duration {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.init()
+	if len(p.state) == 0 {
+		return 0
+	}
+	if wait := p.state[p.current].ready.Sub(p.now()); wait > 0 {
+		return wait
+	}
+	return 0
+}
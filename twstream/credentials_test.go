@@ -0,0 +1,163 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"errors"
+	"github.com/kurrik/golibs/twurlrc"
+	"testing"
+	"time"
+)
+
+func TestCredentialProvider(t *testing.T) {
+	stop := errors.New("stop")
+	fresh := &twurlrc.Credentials{Token: "fresh"}
+	var calls []error
+	conf := &Configuration{
+		// Retrying with fresh credentials must not wait for this backoff.
+		Backoff: &Backoff{HTTPInitial: time.Hour, HTTPMax: time.Hour},
+		CredentialProvider: CredentialProviderFunc(func(err error) (*twurlrc.Credentials, error) {
+			calls = append(calls, err)
+			return fresh, nil
+		}),
+		Handler: HandlerFunc(func(msg []byte) error {
+			return stop
+		}),
+	}
+	conf.Dialer = &SequenceDialer{Responses: []string{
+		"HTTP/1.1 401 Unauthorized\r\n\r\n",
+		"HTTP/1.1 200 OK\r\n\r\n{\"a\": 1}\r\n",
+	}}
+	conn := newStubConnection(conf, "")
+	if err := conn.Run(); err != stop {
+		t.Fatalf("Expected handler error, got %v", err)
+	}
+	if len(calls) != 1 || !errors.Is(calls[0], ErrUnauthorized) {
+		t.Errorf("Expected one call with a 401 error, got %v", calls)
+	}
+	if conn.cred != fresh {
+		t.Errorf("Expected fresh credentials, got %+v", conn.cred)
+	}
+}
+
+func TestCredentialProviderError(t *testing.T) {
+	failed := errors.New("no credentials")
+	conf := &Configuration{
+		CredentialProvider: CredentialProviderFunc(func(err error) (*twurlrc.Credentials, error) {
+			return nil, failed
+		}),
+	}
+	conf.Dialer = &SequenceDialer{Responses: []string{
+		"HTTP/1.1 401 Unauthorized\r\n\r\n",
+	}}
+	conn := newStubConnection(conf, "")
+	if err := conn.Run(); err != failed {
+		t.Errorf("Expected provider error, got %v", err)
+	}
+}
+
+func TestCredentialProviderNilCredentials(t *testing.T) {
+	conf := &Configuration{
+		CredentialProvider: CredentialProviderFunc(func(err error) (*twurlrc.Credentials, error) {
+			return nil, nil
+		}),
+	}
+	conf.Dialer = &SequenceDialer{Responses: []string{
+		"HTTP/1.1 401 Unauthorized\r\n\r\n",
+		"HTTP/1.1 200 OK\r\n\r\n",
+	}}
+	conn := newStubConnection(conf, "")
+	err := conn.Run()
+	if !errors.Is(err, ErrNoCredentials) || Classify(err) != ClassConfig {
+		t.Errorf("Expected ErrNoCredentials, got %v", err)
+	}
+	if conn.cred == nil {
+		t.Errorf("Expected the previous credentials to be kept")
+	}
+}
+
+func TestCredentialPool(t *testing.T) {
+	a := &twurlrc.Credentials{Token: "a"}
+	b := &twurlrc.Credentials{Token: "b"}
+	clock := NewFakeClock(time.Unix(1378316740, 0))
+	pool := NewCredentialPool(a, b)
+	pool.Backoff = &ExponentialBackoff{Initial: time.Minute, Max: time.Hour}
+	pool.Clock = clock
+	limited := &StatusError{StatusCode: 420}
+	if pool.Current() != a {
+		t.Fatalf("Expected first credentials")
+	}
+	steps := []struct {
+		advance  time.Duration
+		expected *twurlrc.Credentials
+		ready    time.Duration
+	}{
+		// a rests for 1m, so b is used at once.
+		{0, b, 0},
+		// b rests for 1m, so a is used once its cooldown ends.
+		{10 * time.Second, a, 50 * time.Second},
+		// a is rejected straight away, so its cooldown doubles.
+		{50 * time.Second, b, 10 * time.Second},
+		// So does b's, and a, which rested from 10s earlier, is next.
+		{10 * time.Second, a, 110 * time.Second},
+	}
+	for i, step := range steps {
+		clock.Advance(step.advance)
+		cred, err := pool.Credentials(limited)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cred != step.expected {
+			t.Errorf("Step %v: expected %v, got %v", i, step.expected.Token, cred.Token)
+		}
+		if ready := pool.Ready(); ready != step.ready {
+			t.Errorf("Step %v: expected ready in %v, got %v", i, step.ready, ready)
+		}
+	}
+}
+
+func TestCredentialPoolRotation(t *testing.T) {
+	a := &twurlrc.Credentials{Token: "a"}
+	b := &twurlrc.Credentials{Token: "b"}
+	pool := NewCredentialPool(a, b)
+	pool.Backoff = &ExponentialBackoff{Initial: 20 * time.Millisecond, Max: 20 * time.Millisecond}
+	stop := errors.New("stop")
+	conf := &Configuration{
+		Backoff:            &Backoff{RateLimitInitial: time.Hour, RateLimitMax: time.Hour},
+		CredentialProvider: pool,
+		Handler: HandlerFunc(func(msg []byte) error {
+			return stop
+		}),
+	}
+	conf.Dialer = &SequenceDialer{Responses: []string{
+		"HTTP/1.1 420 Enhance Your Calm\r\n\r\n",
+		"HTTP/1.1 401 Unauthorized\r\n\r\n",
+		"HTTP/1.1 200 OK\r\n\r\n{\"a\": 1}\r\n",
+	}}
+	conn := newStubConnection(conf, "")
+	conn.cred = pool.Current()
+	start := time.Now()
+	if err := conn.Run(); err != stop {
+		t.Fatalf("Expected handler error, got %v", err)
+	}
+	// The second rejection waits for a's cooldown rather than the hour long
+	// rate limit backoff.
+	if elapsed := time.Since(start); elapsed < 10*time.Millisecond || elapsed > time.Second {
+		t.Errorf("Expected to wait for the account cooldown, took %v", elapsed)
+	}
+	if conn.cred != a {
+		t.Errorf("Expected to rotate back to the first account, got %v", conn.cred.Token)
+	}
+}
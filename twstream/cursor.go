@@ -0,0 +1,152 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"encoding/json"
+	"math"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// The most minutes of backfill the backfillMinutes parameter accepts.
+const MaxBackfillMinutes = 5
+
+// The position reached in a stream, persisted by a CursorStore so that a
+// restarted process can resume where the last one stopped.  Time is when
+// the last message was delivered, and Rate the message rate at that point,
+// in messages per second, from which missed messages are estimated.
+type Cursor struct {
+	Time time.Time `json:"time"`
+	Rate float64   `json:"rate"`
+}
+
+// Persists the Cursor of a stream.  LoadCursor returns nil and no error if
+// no cursor has been saved.
+type CursorStore interface {
+	LoadCursor() (*Cursor, error)
+	SaveCursor(cursor *Cursor) error
+}
+
+// Saves the Cursor as JSON in the file at Path.  The file is replaced
+// atomically, so a crash while saving leaves the previous cursor intact.
+type FileCursorStore struct {
+	Path string
+}
+
+func (s *FileCursorStore) LoadCursor() (*Cursor, error) {
+	data, err := os.ReadFile(s.Path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	cursor := &Cursor{}
+	if err = json.Unmarshal(data, cursor); err != nil {
+		return nil, err
+	}
+	return cursor, nil
+}
+
+func (s *FileCursorStore) SaveCursor(cursor *Cursor) error {
+	data, err := json.Marshal(cursor)
+	if err != nil {
+		return err
+	}
+	temp := s.Path + ".tmp"
+	if err = os.WriteFile(temp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(temp, s.Path)
+}
+
+// The cursor of a Connection, and when it was last saved.
+type cursorState struct {
+	loaded   bool
+	position Cursor
+	saved    time.Time
+}
+
+// Loads the saved cursor the first time the Connection connects.  The
+// cursor is treated as the last disconnection, so that backfill covers the
+// time the previous process was stopped.
+func (c *Connection) loadCursor() error {
+	if c.conf.CursorStore == nil || c.cursor.loaded {
+		return nil
+	}
+	cursor, err := c.conf.CursorStore.LoadCursor()
+	if err != nil {
+		return err
+	}
+	c.cursor.loaded = true
+	if cursor != nil && c.backfill.disconnected.IsZero() {
+		c.cursor.position = *cursor
+		c.backfill.disconnected = cursor.Time
+		c.backfill.rate = cursor.Rate
+	}
+	return nil
+}
+
+// Moves the cursor to a message delivered at now, saving it if
+// CursorInterval has passed since it was last saved.
+func (c *Connection) advanceCursor(now time.Time) error {
+	if c.conf.CursorStore == nil {
+		return nil
+	}
+	c.cursor.position.Time = now
+	interval := c.conf.CursorInterval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	if now.Sub(c.cursor.saved) < interval {
+		return nil
+	}
+	return c.saveCursor(now)
+}
+
+// Saves the cursor with the message rate of the current connection.
+func (c *Connection) saveCursor(now time.Time) error {
+	if c.conf.CursorStore == nil || c.cursor.position.Time.IsZero() {
+		return nil
+	}
+	elapsed := now.Sub(c.backfill.connected).Seconds()
+	messages := atomic.LoadUint64(&c.counters.messages) - c.backfill.messages
+	if messages > 0 && elapsed > 0 {
+		c.cursor.position.Rate = float64(messages) / elapsed
+	}
+	c.cursor.saved = now
+	position := c.cursor.position
+	return c.conf.CursorStore.SaveCursor(&position)
+}
+
+// Returns the backfillMinutes parameter for the next connection: the
+// minutes since the last disconnection, rounded up and capped at
+// BackfillMinutes, or zero if the stream has not been connected before.
+func (c *Connection) backfillMinutes() int {
+	if c.conf.BackfillMinutes <= 0 || c.backfill.disconnected.IsZero() {
+		return 0
+	}
+	limit := c.conf.BackfillMinutes
+	if limit > MaxBackfillMinutes {
+		limit = MaxBackfillMinutes
+	}
+	minutes := math.Ceil(c.now().Sub(c.backfill.disconnected).Minutes())
+	if minutes > float64(limit) {
+		return limit
+	}
+	return int(minutes)
+}
@@ -0,0 +1,112 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Keeps a Cursor in memory.
+type MemoryCursorStore struct {
+	Cursor *Cursor
+	Saves  int
+}
+
+func (s *MemoryCursorStore) LoadCursor() (*Cursor, error) {
+	return s.Cursor, nil
+}
+
+func (s *MemoryCursorStore) SaveCursor(cursor *Cursor) error {
+	copied := *cursor
+	s.Cursor = &copied
+	s.Saves++
+	return nil
+}
+
+func TestFileCursorStore(t *testing.T) {
+	store := &FileCursorStore{Path: filepath.Join(t.TempDir(), "cursor.json")}
+	cursor, err := store.LoadCursor()
+	if cursor != nil || err != nil {
+		t.Fatalf("Expected no cursor, got %v, %v", cursor, err)
+	}
+	saved := &Cursor{Time: time.Date(2012, 10, 1, 12, 0, 0, 0, time.UTC), Rate: 2.5}
+	if err = store.SaveCursor(saved); err != nil {
+		t.Fatal(err)
+	}
+	if cursor, err = store.LoadCursor(); err != nil {
+		t.Fatal(err)
+	}
+	if !cursor.Time.Equal(saved.Time) || cursor.Rate != saved.Rate {
+		t.Errorf("Expected %+v, got %+v", saved, cursor)
+	}
+}
+
+func TestCursorResume(t *testing.T) {
+	now := time.Date(2012, 10, 1, 12, 0, 0, 0, time.UTC)
+	store := &MemoryCursorStore{Cursor: &Cursor{Time: now.Add(-150 * time.Second), Rate: 10}}
+	conf := &Configuration{
+		MaxBackfill:     10000,
+		BackfillMinutes: 5,
+		CursorStore:     store,
+		Handler:         &CollectingHandler{},
+	}
+	conn := newStubConnection(conf, "HTTP/1.1 200 OK\r\n\r\n")
+	conf.Clock = clockFunc(func() time.Time {
+		return now
+	})
+	if err := conn.Read(); err != io.EOF {
+		t.Fatalf("Expected EOF, got %v", err)
+	}
+	query := sentRequest(t, conn).URL.Query()
+	if count := query.Get("count"); count != "1500" {
+		t.Errorf("Expected count 1500, got %q", count)
+	}
+	if minutes := query.Get("backfillMinutes"); minutes != "3" {
+		t.Errorf("Expected backfillMinutes 3, got %q", minutes)
+	}
+}
+
+func TestCursorSave(t *testing.T) {
+	now := time.Date(2012, 10, 1, 12, 0, 0, 0, time.UTC)
+	store := &MemoryCursorStore{}
+	conf := &Configuration{
+		CursorStore:    store,
+		CursorInterval: time.Minute,
+		Handler:        &CollectingHandler{},
+	}
+	response := "HTTP/1.1 200 OK\r\n\r\n" +
+		"{\"a\": 1}\r\n{\"b\": 2}\r\n{\"c\": 3}\r\n"
+	conn := newStubConnection(conf, response)
+	conf.Clock = clockFunc(func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	})
+	if err := conn.Read(); err != io.EOF {
+		t.Fatalf("Expected EOF, got %v", err)
+	}
+	// Saved for the first message, then once the connection ended.
+	if store.Saves != 2 {
+		t.Errorf("Expected 2 saves, got %v", store.Saves)
+	}
+	if store.Cursor == nil || store.Cursor.Rate <= 0 {
+		t.Fatalf("Unexpected cursor %+v", store.Cursor)
+	}
+	if last := conn.Stats().LastMessage; !store.Cursor.Time.Equal(last) {
+		t.Errorf("Expected cursor at %v, got %v", last, store.Cursor.Time)
+	}
+}
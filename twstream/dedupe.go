@@ -0,0 +1,126 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"container/list"
+	"encoding/json"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Drops Tweets whose ID has already been seen, such as those delivered again
+// by a backfill after reconnecting.  The most recent Size IDs are
+// remembered, and if Window is non-zero IDs are also forgotten once they
+// were first seen longer ago than Window.  Set Configuration.Dedupe to
+// deduplicate a stream.
+type Deduplicator struct {
+	Size   int
+	Window time.Duration
+	// Times the Window.  Defaults to SystemClock.
+	Clock Clock
+
+	lock       sync.Mutex
+	order      *list.List
+	seen       map[string]*list.Element
+	duplicates uint64
+}
+
+type dedupeEntry struct {
+	id   string
+	seen time.Time
+}
+
+// Returns a Deduplicator which remembers up to size IDs for up to window,
+// or indefinitely if window is zero.
+func NewDeduplicator(size int, window time.Duration) *Deduplicator {
+	return &Deduplicator{
+		Size:   size,
+		Window: window,
+		order:  list.New(),
+		seen:   map[string]*list.Element{},
+	}
+}
+
+// Reports whether id has been seen within the window, recording it if not.
+func (d *Deduplicator) Seen(id string) bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	now := clockOr(d.Clock).Now()
+	if d.seen == nil {
+		d.order = list.New()
+		d.seen = map[string]*list.Element{}
+	}
+	d.expire(now)
+	if _, ok := d.seen[id]; ok {
+		atomic.AddUint64(&d.duplicates, 1)
+		return true
+	}
+	d.seen[id] = d.order.PushFront(&dedupeEntry{id: id, seen: now})
+	for d.Size > 0 && d.order.Len() > d.Size {
+		d.remove(d.order.Back())
+	}
+	return false
+}
+
+// Returns the number of duplicates seen.
+func (d *Deduplicator) Duplicates() uint64 {
+	return atomic.LoadUint64(&d.duplicates)
+}
+
+// Reports whether msg is a Tweet whose ID has already been seen.  Both v1
+// payloads and v2 payloads are recognized; other messages are never
+// duplicates.
+func (d *Deduplicator) duplicate(msg []byte) bool {
+	if isControl(messageType(msg)) {
+		return false
+	}
+	tweet := &struct {
+		IDStr string `json:"id_str"`
+		Data  *struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}{}
+	if err := json.Unmarshal(msg, tweet); err != nil {
+		return false
+	}
+	id := tweet.IDStr
+	if id == "" && tweet.Data != nil {
+		id = tweet.Data.ID
+	}
+	if id == "" {
+		return false
+	}
+	return d.Seen(id)
+}
+
+// Forgets IDs first seen longer than Window before now.
+func (d *Deduplicator) expire(now time.Time) {
+	if d.Window <= 0 {
+		return
+	}
+	for back := d.order.Back(); back != nil; back = d.order.Back() {
+		if now.Sub(back.Value.(*dedupeEntry).seen) < d.Window {
+			return
+		}
+		d.remove(back)
+	}
+}
+
+func (d *Deduplicator) remove(element *list.Element) {
+	d.order.Remove(element)
+	delete(d.seen, element.Value.(*dedupeEntry).id)
+}
@@ -0,0 +1,80 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+func TestDeduplicatorSize(t *testing.T) {
+	dedupe := NewDeduplicator(2, 0)
+	cases := []struct {
+		id   string
+		seen bool
+	}{
+		{"1", false},
+		{"2", false},
+		{"1", true},
+		{"3", false},
+		{"1", false},
+		{"3", true},
+	}
+	for i, c := range cases {
+		if seen := dedupe.Seen(c.id); seen != c.seen {
+			t.Errorf("Case %v: Seen(%v) expected %v, got %v", i, c.id, c.seen, seen)
+		}
+	}
+	if dedupe.Duplicates() != 2 {
+		t.Errorf("Expected 2 duplicates, got %v", dedupe.Duplicates())
+	}
+}
+
+func TestDeduplicatorWindow(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	dedupe := NewDeduplicator(0, time.Minute)
+	dedupe.Clock = clock
+	dedupe.Seen("1")
+	clock.Advance(30 * time.Second)
+	if !dedupe.Seen("1") {
+		t.Errorf("Expected ID to be seen within the window")
+	}
+	clock.Advance(30 * time.Second)
+	if dedupe.Seen("1") {
+		t.Errorf("Expected ID to be forgotten after the window")
+	}
+}
+
+func TestDedupe(t *testing.T) {
+	handler := &CollectingHandler{}
+	conf := &Configuration{
+		Handler: handler,
+		Dedupe:  NewDeduplicator(100, 0),
+	}
+	conn := newStubConnection(conf, "HTTP/1.1 200 OK\r\n\r\n"+
+		TWEET_JSON+"\r\n"+
+		TWEET_JSON+"\r\n"+
+		`{"data":{"id":"2"}}`+"\r\n"+
+		`{"data":{"id":"2"}}`+"\r\n"+
+		`{"delete":{"status":{"id_str":"1"}}}`+"\r\n"+
+		`{"delete":{"status":{"id_str":"1"}}}`+"\r\n")
+	if err := conn.Read(); err != io.EOF {
+		t.Fatalf("Expected EOF, got %v", err)
+	}
+	if len(handler.Messages) != 4 {
+		t.Errorf("Expected 4 messages, got %q", handler.Messages)
+	}
+}
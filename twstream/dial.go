@@ -0,0 +1,124 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"context"
+	"net"
+	"time"
+)
+
+// The FallbackDelay used when a NetDialer does not specify one, as
+// recommended by RFC 8305.
+const defaultFallbackDelay = 300 * time.Millisecond
+
+type dialFunc func(ctx context.Context, network string, addr string) (net.Conn, error)
+
+type dialResult struct {
+	conn net.Conn
+	err  error
+}
+
+// Orders ips alternately by address family, starting with the family of the
+// first, and otherwise keeping the resolver's order.
+func interleave(ips []string) []string {
+	var first, second []string
+	firstIPv4 := isIPv4(ips[0])
+	for _, ip := range ips {
+		if isIPv4(ip) == firstIPv4 {
+			first = append(first, ip)
+		} else {
+			second = append(second, ip)
+		}
+	}
+	ordered := make([]string, 0, len(ips))
+	for i := 0; i < len(first) || i < len(second); i++ {
+		if i < len(first) {
+			ordered = append(ordered, first[i])
+		}
+		if i < len(second) {
+			ordered = append(ordered, second[i])
+		}
+	}
+	return ordered
+}
+
+func isIPv4(ip string) bool {
+	parsed := net.ParseIP(ip)
+	return parsed != nil && parsed.To4() != nil
+}
+
+// Dials each of ips on port in turn, starting the next attempt when the
+// previous one fails or after delay, and returns the first connection to
+// succeed.  Attempts still pending are cancelled, and connections which
+// succeed too late are closed.
+func race(ctx context.Context, dial dialFunc, ips []string, port string, delay time.Duration) (net.Conn, error) {
+	if delay == 0 {
+		delay = defaultFallbackDelay
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan dialResult, len(ips))
+	next, pending := 0, 0
+	start := func() {
+		addr := net.JoinHostPort(ips[next], port)
+		next++
+		pending++
+		go func() {
+			conn, err := dial(ctx, "tcp", addr)
+			results <- dialResult{conn, err}
+		}()
+	}
+	start()
+	var err error
+	for pending > 0 {
+		var fallback <-chan time.Time
+		var timer *time.Timer
+		if next < len(ips) && delay > 0 {
+			timer = time.NewTimer(delay)
+			fallback = timer.C
+		}
+		var result dialResult
+		select {
+		case result = <-results:
+		case <-fallback:
+			start()
+			continue
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		pending--
+		if result.err == nil {
+			go closeLate(results, pending)
+			return result.conn, nil
+		}
+		err = result.err
+		if ctx.Err() == nil && next < len(ips) {
+			start()
+		}
+	}
+	return nil, err
+}
+
+// Closes the connections made by attempts which were still pending when
+// another succeeded.
+func closeLate(results chan dialResult, pending int) {
+	for i := 0; i < pending; i++ {
+		if result := <-results; result.conn != nil {
+			result.conn.Close()
+		}
+	}
+}
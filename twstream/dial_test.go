@@ -0,0 +1,128 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestInterleave(t *testing.T) {
+	cases := []struct {
+		ips      []string
+		expected []string
+	}{
+		{[]string{"2001:db8::1", "2001:db8::2", "10.0.0.1", "10.0.0.2"}, []string{"2001:db8::1", "10.0.0.1", "2001:db8::2", "10.0.0.2"}},
+		{[]string{"10.0.0.1", "10.0.0.2", "2001:db8::1"}, []string{"10.0.0.1", "2001:db8::1", "10.0.0.2"}},
+		{[]string{"10.0.0.1", "10.0.0.2"}, []string{"10.0.0.1", "10.0.0.2"}},
+	}
+	for _, c := range cases {
+		if ordered := interleave(c.ips); fmt.Sprint(ordered) != fmt.Sprint(c.expected) {
+			t.Errorf("Expected %v, got %v", c.expected, ordered)
+		}
+	}
+}
+
+// Records the addresses dialed.  Addresses in hang block until the dial is
+// cancelled, addresses in fail fail immediately, and others succeed.
+type scriptedDial struct {
+	lock      sync.Mutex
+	dialed    []string
+	hang      map[string]bool
+	fail      map[string]bool
+	cancelled chan string
+}
+
+func (d *scriptedDial) dial(ctx context.Context, network string, addr string) (net.Conn, error) {
+	d.lock.Lock()
+	d.dialed = append(d.dialed, addr)
+	d.lock.Unlock()
+	host, _, _ := net.SplitHostPort(addr)
+	if d.hang[host] {
+		<-ctx.Done()
+		d.cancelled <- addr
+		return nil, ctx.Err()
+	}
+	if d.fail[host] {
+		return nil, errors.New("Connection refused")
+	}
+	client, server := net.Pipe()
+	server.Close()
+	return client, nil
+}
+
+func TestNetDialerFallback(t *testing.T) {
+	script := &scriptedDial{
+		hang:      map[string]bool{"2001:db8::1": true},
+		cancelled: make(chan string, 1),
+	}
+	dialer := &NetDialer{
+		Resolver:      StaticResolver{"stream.twitter.com": {"2001:db8::1", "2001:db8::2", "10.0.0.1"}},
+		FallbackDelay: 10 * time.Millisecond,
+		dial:          script.dial,
+	}
+	start := time.Now()
+	conn, err := dialer.dialTCP(context.Background(), "stream.twitter.com:443")
+	if err != nil {
+		t.Fatalf("Expected connection, got %v", err)
+	}
+	conn.Close()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Fallback took %v", elapsed)
+	}
+	select {
+	case addr := <-script.cancelled:
+		if addr != "[2001:db8::1]:443" {
+			t.Errorf("Unexpected cancelled dial %v", addr)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("Hanging dial was not cancelled")
+	}
+	script.lock.Lock()
+	defer script.lock.Unlock()
+	expected := []string{"[2001:db8::1]:443", "10.0.0.1:443"}
+	if fmt.Sprint(script.dialed) != fmt.Sprint(expected) {
+		t.Errorf("Expected dials %v, got %v", expected, script.dialed)
+	}
+}
+
+func TestNetDialerFallbackOnFailure(t *testing.T) {
+	script := &scriptedDial{fail: map[string]bool{"2001:db8::1": true, "10.0.0.1": true}}
+	dialer := &NetDialer{
+		Resolver:      StaticResolver{"stream.twitter.com": {"2001:db8::1", "2001:db8::2", "10.0.0.1", "10.0.0.2"}},
+		FallbackDelay: time.Hour,
+		dial:          script.dial,
+	}
+	conn, err := dialer.dialTCP(context.Background(), "stream.twitter.com:443")
+	if err != nil {
+		t.Fatalf("Expected connection, got %v", err)
+	}
+	conn.Close()
+	// Failures start the next attempt without waiting for the delay.
+	expected := []string{"[2001:db8::1]:443", "10.0.0.1:443", "[2001:db8::2]:443"}
+	if fmt.Sprint(script.dialed) != fmt.Sprint(expected) {
+		t.Errorf("Expected dials %v, got %v", expected, script.dialed)
+	}
+	script.fail["2001:db8::2"] = true
+	script.fail["10.0.0.2"] = true
+	if _, err := dialer.dialTCP(context.Background(), "stream.twitter.com:443"); err == nil {
+		t.Errorf("Expected error when every address fails")
+	}
+}
@@ -0,0 +1,191 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// Writes messages to an io.Writer in some output encoding.  Close flushes
+// any buffered output and ends the encoding, but does not close the
+// underlying writer.
+type Encoder interface {
+	Encode(msg []byte) error
+	Close() error
+}
+
+// Returns an Encoder writing to w.  WriterSink and RotatingFileSink create
+// an Encoder for each writer or file using their Encoding.
+type Encoding func(w io.Writer) Encoder
+
+// Returns an Encoder writing each message followed by a newline, the default
+// output of sinks.
+func NewNDJSONEncoder(w io.Writer) Encoder {
+	return &ndjsonEncoder{writer: w}
+}
+
+type ndjsonEncoder struct {
+	writer io.Writer
+	buffer []byte
+}
+
+func (e *ndjsonEncoder) Encode(msg []byte) error {
+	e.buffer = append(append(e.buffer[:0], msg...), '\n')
+	_, err := e.writer.Write(e.buffer)
+	return err
+}
+
+func (e *ndjsonEncoder) Close() error {
+	return nil
+}
+
+// Returns an Encoder writing newline delimited messages as a gzip stream.
+// Output is only complete once the Encoder is closed.
+func NewGzipEncoder(w io.Writer) Encoder {
+	z := gzip.NewWriter(w)
+	return &gzipEncoder{ndjsonEncoder: ndjsonEncoder{writer: z}, z: z}
+}
+
+type gzipEncoder struct {
+	ndjsonEncoder
+	z *gzip.Writer
+}
+
+func (e *gzipEncoder) Close() error {
+	return e.z.Close()
+}
+
+// Returns an Encoder which decodes each message as JSON and writes it as a
+// MessagePack value.  Objects become maps with their keys sorted, and
+// integers which fit in 64 bits, such as Tweet IDs, are written exactly.
+// Messages which are not valid JSON cause Encode to return an error.
+func NewMessagePackEncoder(w io.Writer) Encoder {
+	return &messagePackEncoder{writer: w}
+}
+
+type messagePackEncoder struct {
+	writer io.Writer
+	buffer []byte
+}
+
+func (e *messagePackEncoder) Encode(msg []byte) error {
+	decoder := json.NewDecoder(bytes.NewReader(msg))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return err
+	}
+	e.buffer = appendMessagePack(e.buffer[:0], value)
+	_, err := e.writer.Write(e.buffer)
+	return err
+}
+
+func (e *messagePackEncoder) Close() error {
+	return nil
+}
+
+// Appends the MessagePack encoding of a value decoded from JSON with
+// UseNumber set.
+func appendMessagePack(b []byte, value interface{}) []byte {
+	switch v := value.(type) {
+	case nil:
+		return append(b, 0xc0)
+	case bool:
+		if v {
+			return append(b, 0xc3)
+		}
+		return append(b, 0xc2)
+	case json.Number:
+		if i, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+			return appendMessagePackInt(b, i)
+		}
+		if u, err := strconv.ParseUint(string(v), 10, 64); err == nil {
+			return binary.BigEndian.AppendUint64(append(b, 0xcf), u)
+		}
+		f, _ := v.Float64()
+		return binary.BigEndian.AppendUint64(append(b, 0xcb), math.Float64bits(f))
+	case string:
+		return appendMessagePackString(b, v)
+	case []interface{}:
+		b = appendMessagePackHeader(b, len(v), 0x90, 0xdc)
+		for _, item := range v {
+			b = appendMessagePack(b, item)
+		}
+		return b
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		b = appendMessagePackHeader(b, len(v), 0x80, 0xde)
+		for _, key := range keys {
+			b = appendMessagePackString(b, key)
+			b = appendMessagePack(b, v[key])
+		}
+		return b
+	}
+	panic("Unexpected JSON value")
+}
+
+func appendMessagePackInt(b []byte, i int64) []byte {
+	switch {
+	case i >= 0 && i <= 0x7f:
+		return append(b, byte(i))
+	case i >= -32 && i < 0:
+		return append(b, byte(i))
+	case i >= math.MinInt8 && i <= math.MaxInt8:
+		return append(b, 0xd0, byte(i))
+	case i >= math.MinInt16 && i <= math.MaxInt16:
+		return binary.BigEndian.AppendUint16(append(b, 0xd1), uint16(i))
+	case i >= math.MinInt32 && i <= math.MaxInt32:
+		return binary.BigEndian.AppendUint32(append(b, 0xd2), uint32(i))
+	}
+	return binary.BigEndian.AppendUint64(append(b, 0xd3), uint64(i))
+}
+
+func appendMessagePackString(b []byte, s string) []byte {
+	switch n := len(s); {
+	case n < 32:
+		b = append(b, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		b = append(b, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		b = binary.BigEndian.AppendUint16(append(b, 0xda), uint16(n))
+	default:
+		b = binary.BigEndian.AppendUint32(append(b, 0xdb), uint32(n))
+	}
+	return append(b, s...)
+}
+
+// Appends the header of an array or map of n items.  Fix is the type byte
+// for fewer than 16 items, and wide the 16 bit form, which is followed by
+// the 32 bit form.
+func appendMessagePackHeader(b []byte, n int, fix byte, wide byte) []byte {
+	switch {
+	case n < 16:
+		return append(b, fix|byte(n))
+	case n <= math.MaxUint16:
+		return binary.BigEndian.AppendUint16(append(b, wide), uint16(n))
+	}
+	return binary.BigEndian.AppendUint32(append(b, wide+1), uint32(n))
+}
@@ -0,0 +1,118 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNDJSONEncoder(t *testing.T) {
+	var out bytes.Buffer
+	sink := &WriterSink{Writer: &out, Encoding: NewNDJSONEncoder}
+	sink.Write([]byte("{\"a\": 1}"))
+	sink.Write([]byte("{\"b\": 2}"))
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "{\"a\": 1}\n{\"b\": 2}\n" {
+		t.Errorf("Unexpected output %q", out.String())
+	}
+}
+
+func TestGzipEncoder(t *testing.T) {
+	var out bytes.Buffer
+	sink := &WriterSink{Writer: &out, Encoding: NewGzipEncoder}
+	sink.Write([]byte("{\"a\": 1}"))
+	sink.Write([]byte("{\"b\": 2}"))
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	z, err := gzip.NewReader(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := io.ReadAll(z)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decoded) != "{\"a\": 1}\n{\"b\": 2}\n" {
+		t.Errorf("Unexpected output %q", decoded)
+	}
+}
+
+func TestMessagePackEncoder(t *testing.T) {
+	var out bytes.Buffer
+	encoder := NewMessagePackEncoder(&out)
+	msg := `{"b": [1, -1, 300], "a": "x", "id": 1234567890123456789, "f": 1.5, "n": null, "t": true}`
+	if err := encoder.Encode([]byte(msg)); err != nil {
+		t.Fatal(err)
+	}
+	expected := []byte{
+		0x86,
+		0xa1, 'a', 0xa1, 'x',
+		0xa1, 'b', 0x93, 0x01, 0xff, 0xd1, 0x01, 0x2c,
+		0xa1, 'f', 0xcb, 0x3f, 0xf8, 0, 0, 0, 0, 0, 0,
+		0xa2, 'i', 'd', 0xd3, 0x11, 0x22, 0x10, 0xf4, 0x7d, 0xe9, 0x81, 0x15,
+		0xa1, 'n', 0xc0,
+		0xa1, 't', 0xc3,
+	}
+	if !bytes.Equal(out.Bytes(), expected) {
+		t.Errorf("Expected % x, got % x", expected, out.Bytes())
+	}
+	if err := encoder.Encode([]byte("{")); err == nil {
+		t.Errorf("Expected error for invalid JSON")
+	}
+}
+
+func TestRotatingFileSinkEncoding(t *testing.T) {
+	dir := t.TempDir()
+	sink := NewRotatingFileSink(dir, "tweets.json.gz", 0, 0)
+	sink.Encoding = NewGzipEncoder
+	sink.Write([]byte("{\"a\": 1}"))
+	if err := sink.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	sink.Write([]byte("{\"b\": 2}"))
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"tweets.json.gz":   "{\"a\": 1}\n",
+		"tweets.json.gz.1": "{\"b\": 2}\n",
+	}
+	for name, content := range expected {
+		file, err := os.Open(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		z, err := gzip.NewReader(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := io.ReadAll(z)
+		file.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(decoded) != content {
+			t.Errorf("%v: expected %q, got %q", name, content, decoded)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"github.com/kurrik/golibs/twurlrc"
+	"net/url"
+)
+
+// Public stream endpoints.
+const (
+	SampleURL   = "https://stream.twitter.com/1.1/statuses/sample.json"
+	FirehoseURL = "https://stream.twitter.com/1.1/statuses/firehose.json"
+	FilterURL   = "https://stream.twitter.com/1.1/statuses/filter.json"
+)
+
+// Returns a Connection to the sample stream, a random sample of all public
+// Tweets.  Conf may be nil, or may set handlers and other options; URL and
+// Method default to the sample endpoint.  Chunked and GZip are always set,
+// overriding conf, since the streaming endpoints send chunked, gzipped
+// responses.  Conf is copied and is not modified.
+func NewSampleStream(cred *twurlrc.Credentials, conf *Configuration) *Connection {
+	return newEndpointStream(SampleURL, "GET", cred, conf)
+}
+
+// Like NewSampleStream, but connects to the firehose, which delivers all
+// public Tweets and requires special permission.
+func NewFirehoseStream(cred *twurlrc.Credentials, conf *Configuration) *Connection {
+	return newEndpointStream(FirehoseURL, "GET", cred, conf)
+}
+
+// Copies conf, defaulting URL and Method to endpoint and method and forcing
+// Chunked and GZip on.
+func newEndpointStream(endpoint string, method string, cred *twurlrc.Credentials, conf *Configuration) *Connection {
+	copied := Configuration{}
+	if conf != nil {
+		copied = *conf
+	}
+	if copied.URL == nil {
+		copied.URL, _ = url.Parse(endpoint)
+	}
+	if copied.Method == "" {
+		copied.Method = method
+	}
+	copied.Chunked = true
+	copied.GZip = true
+	return NewConnection(&copied, cred)
+}
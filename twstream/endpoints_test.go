@@ -0,0 +1,50 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"github.com/kurrik/golibs/twurlrc"
+	"testing"
+)
+
+func TestEndpointStreams(t *testing.T) {
+	cred := &twurlrc.Credentials{}
+	handler := &CollectingHandler{}
+	conf := &Configuration{Handler: handler}
+	cases := map[string]*Connection{
+		SampleURL:   NewSampleStream(cred, conf),
+		FirehoseURL: NewFirehoseStream(cred, conf),
+	}
+	for endpoint, conn := range cases {
+		if conn.conf.URL.String() != endpoint {
+			t.Errorf("Expected URL %v, got %v", endpoint, conn.conf.URL)
+		}
+		if conn.conf.Method != "GET" || !conn.conf.Chunked || !conn.conf.GZip {
+			t.Errorf("Unexpected defaults for %v: %+v", endpoint, conn.conf)
+		}
+		if conn.conf.Handler != handler {
+			t.Errorf("Handler was not copied for %v", endpoint)
+		}
+		if conn.address() != "stream.twitter.com:443" {
+			t.Errorf("Unexpected address %v", conn.address())
+		}
+	}
+	if conf.URL != nil || conf.Chunked {
+		t.Errorf("Configuration was modified: %+v", conf)
+	}
+	if conn := NewSampleStream(cred, nil); conn.conf.URL.String() != SampleURL {
+		t.Errorf("Expected URL %v, got %v", SampleURL, conn.conf.URL)
+	}
+}
@@ -0,0 +1,44 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"time"
+)
+
+// A delivered payload with its receive metadata.  Seq numbers every message
+// read by a Connection from 1, across reconnections, so consumers which see
+// a gap know that messages were dropped in between, for example by a
+// ChanSink.  ReceivedAt is the time the message was read, which is also
+// the timestamp written by a Recorder.  Raw is only valid for the duration
+// of the call it is passed to.
+type Message struct {
+	Seq        uint64
+	ReceivedAt time.Time
+	Raw        []byte
+}
+
+// Receives messages wrapped with their receive metadata.  Returning a
+// non-nil error stops the stream.
+type EnvelopeHandler interface {
+	HandleEnvelope(msg *Message) error
+}
+
+// Adapts an ordinary function to the EnvelopeHandler interface.
+type EnvelopeHandlerFunc func(msg *Message) error
+
+func (f EnvelopeHandlerFunc) HandleEnvelope(msg *Message) error {
+	return f(msg)
+}
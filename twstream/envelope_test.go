@@ -0,0 +1,102 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+// Collects copies of the envelopes passed to an EnvelopeHandler.
+type CollectingEnvelopeHandler struct {
+	Messages []Message
+}
+
+func (h *CollectingEnvelopeHandler) HandleEnvelope(msg *Message) error {
+	copied := *msg
+	copied.Raw = append([]byte(nil), msg.Raw...)
+	h.Messages = append(h.Messages, copied)
+	return nil
+}
+
+func TestEnvelopeHandler(t *testing.T) {
+	handler := &CollectingEnvelopeHandler{}
+	conf := &Configuration{EnvelopeHandler: handler}
+	response := "HTTP/1.1 200 OK\r\n\r\n" +
+		"{\"a\": 1}\r\n\r\n{\"b\": 2}\r\n"
+	conn := newStubConnection(conf, response)
+	now := time.Date(2012, 10, 1, 12, 0, 0, 0, time.UTC)
+	conf.Clock = clockFunc(func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	})
+	for i := 0; i < 2; i++ {
+		if err := conn.Read(); err != io.EOF {
+			t.Fatalf("Expected EOF, got %v", err)
+		}
+	}
+	if len(handler.Messages) != 4 {
+		t.Fatalf("Expected 4 messages, got %v", len(handler.Messages))
+	}
+	for i, msg := range handler.Messages {
+		// Sequence numbers continue across connections.
+		if msg.Seq != uint64(i+1) {
+			t.Errorf("Expected sequence number %v, got %v", i+1, msg.Seq)
+		}
+		if i > 0 && !msg.ReceivedAt.After(handler.Messages[i-1].ReceivedAt) {
+			t.Errorf("Receive times out of order: %v", handler.Messages)
+		}
+	}
+	if string(handler.Messages[3].Raw) != "{\"b\": 2}" {
+		t.Errorf("Unexpected payload %q", handler.Messages[3].Raw)
+	}
+}
+
+func TestEnvelopeSequenceGaps(t *testing.T) {
+	handler := &CollectingEnvelopeHandler{}
+	conf := &Configuration{
+		EnvelopeHandler: handler,
+		Predicate: func(msg []byte) bool {
+			return string(msg) != "{\"b\": 2}"
+		},
+	}
+	response := "HTTP/1.1 200 OK\r\n\r\n" +
+		"{\"a\": 1}\r\n{\"b\": 2}\r\n{\"c\": 3}\r\n"
+	conn := newStubConnection(conf, response)
+	if err := conn.Read(); err != io.EOF {
+		t.Fatalf("Expected EOF, got %v", err)
+	}
+	if len(handler.Messages) != 2 || handler.Messages[0].Seq != 1 || handler.Messages[1].Seq != 3 {
+		t.Errorf("Expected a gap for the filtered message, got %v", handler.Messages)
+	}
+}
+
+func TestReplayEnvelopes(t *testing.T) {
+	recording := writeRecording(t,
+		"2012-10-01T12:00:00Z\t{\"a\": 1}\n"+
+			"2012-10-01T12:00:01Z\t{\"b\": 2}\n")
+	handler := &CollectingEnvelopeHandler{}
+	if err := NewReplay(&Configuration{EnvelopeHandler: handler}, recording).Run(); err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+	if len(handler.Messages) != 2 {
+		t.Fatalf("Expected 2 messages, got %v", len(handler.Messages))
+	}
+	expected := time.Date(2012, 10, 1, 12, 0, 1, 0, time.UTC)
+	if msg := handler.Messages[1]; msg.Seq != 2 || !msg.ReceivedAt.Equal(expected) {
+		t.Errorf("Expected sequence 2 received at %v, got %v at %v", expected, msg.Seq, msg.ReceivedAt)
+	}
+}
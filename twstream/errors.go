@@ -0,0 +1,279 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Returned when no data is received for Configuration.ReadIdleTimeout.
+var ErrIdleTimeout = errors.New("Read idle timeout")
+
+// Returned when the Watchdog closes a connection which has gone silent.
+var ErrStalled = errors.New("Stream stalled")
+
+// Returned, with class ClassConfig, when a request cannot be authorized
+// because a Connection has no credentials and neither BearerToken nor
+// Username is set, for example when NewV2SampleStream is given an empty
+// token, or when a CredentialProvider returns nil credentials.
+var ErrNoCredentials = errors.New("No credentials configured")
+
+// Returned when a message is longer than Configuration.MaxMessageSize.  The
+// rest of the stream cannot be framed reliably, so the connection is closed.
+type MessageTooLargeError struct {
+	Limit int
+}
+
+func (e *MessageTooLargeError) Error() string {
+	return fmt.Sprintf("Message exceeds maximum size of %v bytes", e.Limit)
+}
+
+// Closes the connection when Configuration.TTL expires.  Not returned to
+// callers, since expiry ends the stream successfully.
+var errExpired = errors.New("TTL expired")
+
+// Closes the connection when Close is called.  Not returned to callers,
+// since closing ends the stream successfully.
+var errClosed = errors.New("Connection closed")
+
+// Errors matched by StatusErrors with the corresponding response status, for
+// use with errors.Is.
+var (
+	ErrUnauthorized       = errors.New("Unauthorized")
+	ErrRateLimited        = errors.New("Rate limited")
+	ErrServiceUnavailable = errors.New("Service unavailable")
+)
+
+// Returned when the stream endpoint responds with a non-200 status.
+// RetryAfter holds the delay requested by a Retry-After response header, or
+// zero if none was sent.
+type StatusError struct {
+	StatusCode int
+	Status     string
+	Header     http.Header
+	RetryAfter time.Duration
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("Unexpected response status: %v", e.Status)
+}
+
+// Reports whether target is the sentinel error for this response status:
+// ErrUnauthorized for 401, ErrRateLimited for 420 and 429, or
+// ErrServiceUnavailable for 503.
+func (e *StatusError) Is(target error) bool {
+	switch target {
+	case ErrUnauthorized:
+		return e.StatusCode == 401
+	case ErrRateLimited:
+		return e.StatusCode == 420 || e.StatusCode == 429
+	case ErrServiceUnavailable:
+		return e.StatusCode == 503
+	}
+	return false
+}
+
+// Returned when the stream sends a disconnect message before closing the
+// connection.  Run reconnects after disconnects unless Permanent reports true.
+type DisconnectError struct {
+	Notice *DisconnectNotice
+}
+
+func (e *DisconnectError) Error() string {
+	return fmt.Sprintf("Stream disconnected: %v (code %v)", e.Notice.Reason, e.Notice.Code)
+}
+
+// Reports whether reconnecting cannot succeed, because the credentials used
+// for the stream have been revoked or logged out.
+func (e *DisconnectError) Permanent() bool {
+	switch e.Notice.Code {
+	case DisconnectTokenRevoked, DisconnectAdminLogout:
+		return true
+	}
+	return false
+}
+
+// Splits a HTTP response status line such as "HTTP/1.1 200 OK" into its
+// status code and status, "200 OK".
+func parseStatusLine(line string) (int, string, error) {
+	parts := strings.SplitN(line, " ", 3)
+	if len(parts) < 2 || !strings.HasPrefix(parts[0], "HTTP/") {
+		return 0, "", classify(ClassProtocol, fmt.Errorf("Malformed status line: %q", line))
+	}
+	code, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, "", classify(ClassProtocol, fmt.Errorf("Malformed status line: %q", line))
+	}
+	return code, strings.Join(parts[1:], " "), nil
+}
+
+// Returns a StatusError for any status code other than 200.  Retry-After
+// dates are interpreted relative to now.
+func checkStatus(code int, status string, header http.Header, now time.Time) error {
+	if code != 200 {
+		return &StatusError{
+			StatusCode: code,
+			Status:     status,
+			Header:     header,
+			RetryAfter: parseRetryAfter(header.Get("Retry-After"), now),
+		}
+	}
+	return nil
+}
+
+// Parses a Retry-After header value, which may be either a number of seconds
+// or a HTTP date, the delay to which is measured from now.  Returns zero for
+// empty or invalid values.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if delay := date.Sub(now); delay > 0 {
+			return delay
+		}
+	}
+	return 0
+}
+
+// The broad cause of an error returned by Read or Run, used to decide
+// whether to retry, rotate credentials or give up.
+type ErrorClass int
+
+const (
+	// Not raised by the stream, such as errors returned by handlers and
+	// cancelled contexts.
+	ClassUnknown ErrorClass = iota
+	// Failures to connect, timeouts and connections closed or reset while
+	// reading.  Retrying is expected to succeed.
+	ClassNetwork
+	// Unexpected HTTP statuses, and responses or messages which could not
+	// be read.  Retrying with backoff may succeed.
+	ClassProtocol
+	// Rejected or revoked credentials.  Retrying requires other
+	// credentials.
+	ClassAuth
+	// Rate limited responses.  Retrying succeeds after a long backoff.
+	ClassRateLimit
+	// Invalid requests, such as filter parameters the stream does not
+	// accept.  Retrying cannot succeed without changing the Configuration.
+	ClassConfig
+)
+
+var errorClassNames = []string{"unknown", "network", "protocol", "auth", "rate limit", "config"}
+
+func (c ErrorClass) String() string {
+	if c >= 0 && int(c) < len(errorClassNames) {
+		return errorClassNames[c]
+	}
+	return "ErrorClass(" + strconv.Itoa(int(c)) + ")"
+}
+
+// Wraps an error raised by the stream with its class.  Errors whose class
+// can be told from their type, such as StatusErrors and net.Errors, are
+// returned unwrapped.
+type ClassifiedError struct {
+	Class ErrorClass
+	Err   error
+}
+
+func (e *ClassifiedError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ClassifiedError) Unwrap() error {
+	return e.Err
+}
+
+// Returns the class of err, which may be wrapped.
+func Classify(err error) ErrorClass {
+	if err == nil {
+		return ClassUnknown
+	}
+	var classified *ClassifiedError
+	if errors.As(err, &classified) {
+		return classified.Class
+	}
+	var status *StatusError
+	if errors.As(err, &status) {
+		switch status.StatusCode {
+		case 401, 403:
+			return ClassAuth
+		case 420, 429:
+			return ClassRateLimit
+		case 400, 404, 406, 413, 416:
+			return ClassConfig
+		}
+		return ClassProtocol
+	}
+	var disconnect *DisconnectError
+	if errors.As(err, &disconnect) {
+		if disconnect.Permanent() {
+			return ClassAuth
+		}
+		return ClassNetwork
+	}
+	var tooLarge *MessageTooLargeError
+	if errors.As(err, &tooLarge) {
+		return ClassProtocol
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return ClassUnknown
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, ErrIdleTimeout) || errors.Is(err, ErrStalled) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, io.ErrClosedPipe) || errors.Is(err, net.ErrClosed) {
+		return ClassNetwork
+	}
+	return ClassUnknown
+}
+
+// Reports whether reconnecting after err may succeed without changing the
+// Configuration or credentials: true for network, protocol and rate limit
+// errors.
+func IsRetryable(err error) bool {
+	switch Classify(err) {
+	case ClassNetwork, ClassProtocol, ClassRateLimit:
+		return true
+	}
+	return false
+}
+
+// Wraps err with class, unless it is nil, a context error, or its class is
+// already known.
+func classify(class ErrorClass, err error) error {
+	if err == nil || Classify(err) != ClassUnknown {
+		return err
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	return &ClassifiedError{Class: class, Err: err}
+}
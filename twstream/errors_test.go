@@ -0,0 +1,169 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestStatusErrors(t *testing.T) {
+	cases := []struct {
+		response string
+		target   error
+	}{
+		{"HTTP/1.1 401 Unauthorized\r\n\r\n", ErrUnauthorized},
+		{"HTTP/1.1 420 Enhance Your Calm\r\n\r\n", ErrRateLimited},
+		{"HTTP/1.1 429 Too Many Requests\r\n\r\n", ErrRateLimited},
+		{"HTTP/1.1 503 Service Unavailable\r\n\r\n", ErrServiceUnavailable},
+	}
+	for _, c := range cases {
+		conn := newStubConnection(&Configuration{}, c.response)
+		err := conn.Read()
+		if !errors.Is(err, c.target) {
+			t.Errorf("Expected %v for %q, got %v", c.target, c.response, err)
+		}
+	}
+}
+
+func TestStatusErrorHeaders(t *testing.T) {
+	response := "HTTP/1.1 503 Service Unavailable\r\n" +
+		"Content-Type: text/html\r\n" +
+		"X-Connection-Hash: abc\r\n\r\n"
+	conn := newStubConnection(&Configuration{}, response)
+	err := conn.Read()
+	var status *StatusError
+	if !errors.As(err, &status) {
+		t.Fatalf("Expected StatusError, got %v", err)
+	}
+	if status.StatusCode != 503 || status.Status != "503 Service Unavailable" {
+		t.Errorf("Unexpected status %v %q", status.StatusCode, status.Status)
+	}
+	if status.Header.Get("X-Connection-Hash") != "abc" {
+		t.Errorf("Unexpected headers %v", status.Header)
+	}
+	if errors.Is(err, ErrUnauthorized) {
+		t.Errorf("503 should not match ErrUnauthorized")
+	}
+}
+
+func TestMalformedStatusLine(t *testing.T) {
+	conn := newStubConnection(&Configuration{}, "{\"foo\": \"bar\"}\r\n\r\n")
+	err := conn.Read()
+	if err == nil {
+		t.Fatal("Expected error for malformed status line")
+	}
+	var status *StatusError
+	if errors.As(err, &status) {
+		t.Errorf("Malformed status should not be a StatusError")
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	response := "HTTP/1.1 420 Enhance Your Calm\r\n" +
+		"Retry-After: 120\r\n\r\n"
+	conn := newStubConnection(&Configuration{}, response)
+	var status *StatusError
+	if err := conn.Read(); !errors.As(err, &status) {
+		t.Fatalf("Expected StatusError, got %v", err)
+	}
+	if status.RetryAfter != 2*time.Minute {
+		t.Errorf("Expected 2m Retry-After, got %v", status.RetryAfter)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Unix(1349740800, 0)
+	future := now.Add(time.Hour).UTC().Format(http.TimeFormat)
+	if delay := parseRetryAfter(future, now); delay != time.Hour {
+		t.Errorf("Unexpected delay for HTTP date: %v", delay)
+	}
+	for _, value := range []string{"", "-5", "soon", "Mon, 02 Jan 2006 15:04:05 GMT"} {
+		if delay := parseRetryAfter(value, now); delay != 0 {
+			t.Errorf("Expected no delay for %q, got %v", value, delay)
+		}
+	}
+}
+
+func TestClassify(t *testing.T) {
+	cases := []struct {
+		err       error
+		class     ErrorClass
+		retryable bool
+	}{
+		{nil, ClassUnknown, false},
+		{errors.New("handler"), ClassUnknown, false},
+		{context.Canceled, ClassUnknown, false},
+		{io.EOF, ClassNetwork, true},
+		{ErrIdleTimeout, ClassNetwork, true},
+		{&net.OpError{Op: "dial", Err: errors.New("refused")}, ClassNetwork, true},
+		{fmt.Errorf("read: %w", os.ErrDeadlineExceeded), ClassNetwork, true},
+		{&StatusError{StatusCode: 503}, ClassProtocol, true},
+		{&MessageTooLargeError{Limit: 1}, ClassProtocol, true},
+		{&StatusError{StatusCode: 401}, ClassAuth, false},
+		{&DisconnectError{&DisconnectNotice{Code: DisconnectTokenRevoked}}, ClassAuth, false},
+		{&DisconnectError{&DisconnectNotice{Code: DisconnectStall}}, ClassNetwork, true},
+		{&StatusError{StatusCode: 420}, ClassRateLimit, true},
+		{&StatusError{StatusCode: 406}, ClassConfig, false},
+		{&ClassifiedError{ClassConfig, io.EOF}, ClassConfig, false},
+	}
+	for _, c := range cases {
+		if class := Classify(c.err); class != c.class {
+			t.Errorf("Expected %v for %v, got %v", c.class, c.err, class)
+		}
+		if retryable := IsRetryable(c.err); retryable != c.retryable {
+			t.Errorf("Expected IsRetryable %v for %v, got %v", c.retryable, c.err, retryable)
+		}
+	}
+}
+
+func TestClassifyReadErrors(t *testing.T) {
+	cases := []struct {
+		conf     *Configuration
+		response string
+		class    ErrorClass
+	}{
+		{&Configuration{}, "HTTP/1.1 200 OK\r\n\r\n{\"a\": 1}\r\n", ClassNetwork},
+		{&Configuration{}, "garbage\r\n\r\n", ClassProtocol},
+		{&Configuration{}, "HTTP/1.1 200 OK\r\nContent-Encoding: br\r\n\r\n", ClassProtocol},
+		{&Configuration{Chunked: true}, "HTTP/1.1 200 OK\r\n\r\nzz\r\n", ClassProtocol},
+		{&Configuration{Languages: []string{"en,fr"}}, "", ClassConfig},
+	}
+	for _, c := range cases {
+		c.conf.Handler = &CollectingHandler{}
+		err := newStubConnection(c.conf, c.response).Read()
+		if class := Classify(err); class != c.class {
+			t.Errorf("Expected %v for %q, got %v (%v)", c.class, c.response, class, err)
+		}
+	}
+}
+
+func TestRunStopsOnConfigError(t *testing.T) {
+	conf := &Configuration{
+		FilterLevel: "everything",
+		Handler:     &CollectingHandler{},
+	}
+	conn := newStubConnection(conf, "HTTP/1.1 200 OK\r\n\r\n")
+	if err := conn.Run(); Classify(err) != ClassConfig {
+		t.Fatalf("Expected a config error, got %v", err)
+	}
+}
@@ -0,0 +1,180 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Appends newline delimited messages to a file, starting a new file once the
+// current one reaches MaxSize bytes or has been open for Interval.  Either
+// limit may be zero to disable it.
+//
+// File names are produced by formatting the time a file is opened with
+// Template, a time.Format layout such as "tweets-20060102-150405.json", and
+// joining the result to Dir.  If the formatted name is already in use a
+// numeric suffix is appended, so templates without a time component simply
+// produce numbered files.
+//
+// If Encoding is set, each file is written by its own Encoder, which is
+// closed when the file is rotated, and MaxSize counts encoded bytes.  For
+// example, NewGzipEncoder produces files which are each a complete gzip
+// stream.
+//
+// Intervals and file names use the time read from Clock, which defaults to
+// SystemClock.
+type RotatingFileSink struct {
+	Dir      string
+	Template string
+	MaxSize  int64
+	Interval time.Duration
+	Encoding Encoding
+	Clock    Clock
+
+	lock    sync.Mutex
+	file    *os.File
+	encoder Encoder
+	name    string
+	size    int64
+	opened  time.Time
+	buffer  []byte
+}
+
+func NewRotatingFileSink(dir string, template string, maxSize int64, interval time.Duration) *RotatingFileSink {
+	return &RotatingFileSink{
+		Dir:      dir,
+		Template: template,
+		MaxSize:  maxSize,
+		Interval: interval,
+	}
+}
+
+func (s *RotatingFileSink) Write(msg []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	size := int64(len(msg) + 1)
+	if s.file != nil && s.expired(size) {
+		if err := s.close(); err != nil {
+			return err
+		}
+	}
+	if s.file == nil {
+		if err := s.open(); err != nil {
+			return err
+		}
+	}
+	if s.encoder != nil {
+		return s.encoder.Encode(msg)
+	}
+	s.buffer = append(append(s.buffer[:0], msg...), '\n')
+	n, err := s.file.Write(s.buffer)
+	s.size += int64(n)
+	return err
+}
+
+// Counts the bytes an Encoder writes to the current file.
+type fileWriter struct {
+	sink *RotatingFileSink
+}
+
+func (w *fileWriter) Write(p []byte) (int, error) {
+	n, err := w.sink.file.Write(p)
+	w.sink.size += int64(n)
+	return n, err
+}
+
+// Returns the name of the file currently being written, or "" if no file is
+// open.
+func (s *RotatingFileSink) Name() string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.name
+}
+
+// Closes the current file.  The next message will be written to a new file.
+func (s *RotatingFileSink) Rotate() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.close()
+}
+
+// Closes the current file.
+func (s *RotatingFileSink) Close() error {
+	return s.Rotate()
+}
+
+// Reports whether the current file should be rotated before writing size
+// more bytes.  A message is always written to an empty file, however large.
+func (s *RotatingFileSink) expired(size int64) bool {
+	if s.MaxSize > 0 && s.size > 0 && s.size+size > s.MaxSize {
+		return true
+	}
+	if s.Interval > 0 && s.now().Sub(s.opened) >= s.Interval {
+		return true
+	}
+	return false
+}
+
+func (s *RotatingFileSink) now() time.Time {
+	return clockOr(s.Clock).Now()
+}
+
+func (s *RotatingFileSink) open() error {
+	now := s.now()
+	base := filepath.Join(s.Dir, now.Format(s.Template))
+	name := base
+	for i := 1; ; i++ {
+		if _, err := os.Stat(name); os.IsNotExist(err) {
+			break
+		}
+		name = fmt.Sprintf("%v.%v", base, i)
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	s.file = file
+	s.name = name
+	s.size = 0
+	s.opened = now
+	if s.Encoding != nil {
+		s.encoder = s.Encoding(&fileWriter{sink: s})
+	}
+	return nil
+}
+
+func (s *RotatingFileSink) close() error {
+	if s.file == nil {
+		return nil
+	}
+	var err error
+	if s.encoder != nil {
+		err = s.encoder.Close()
+		s.encoder = nil
+	}
+	if cerr := s.file.Close(); err == nil {
+		err = cerr
+	}
+	s.file = nil
+	s.name = ""
+	return err
+}
@@ -0,0 +1,75 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func readFile(t *testing.T, name string) string {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestRotatingFileSinkSize(t *testing.T) {
+	dir := t.TempDir()
+	sink := NewRotatingFileSink(dir, "tweets.json", 20, 0)
+	for _, msg := range []string{"{\"a\": 1}", "{\"b\": 2}", "{\"c\": 3}"} {
+		if err := sink.Write([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	first := readFile(t, filepath.Join(dir, "tweets.json"))
+	if first != "{\"a\": 1}\n{\"b\": 2}\n" {
+		t.Errorf("Unexpected first file %q", first)
+	}
+	second := readFile(t, filepath.Join(dir, "tweets.json.1"))
+	if second != "{\"c\": 3}\n" {
+		t.Errorf("Unexpected second file %q", second)
+	}
+}
+
+func TestRotatingFileSinkInterval(t *testing.T) {
+	dir := t.TempDir()
+	clock := NewFakeClock(time.Date(2012, 8, 29, 17, 0, 0, 0, time.UTC))
+	sink := NewRotatingFileSink(dir, "2006/01/02/150405.json", 0, time.Hour)
+	sink.Clock = clock
+	sink.Write([]byte("{\"a\": 1}"))
+	clock.Advance(30 * time.Minute)
+	sink.Write([]byte("{\"b\": 2}"))
+	clock.Advance(30 * time.Minute)
+	sink.Write([]byte("{\"c\": 3}"))
+	expected := filepath.Join(dir, "2012/08/29/180000.json")
+	if sink.Name() != expected {
+		t.Errorf("Expected %v, got %v", expected, sink.Name())
+	}
+	sink.Close()
+	first := readFile(t, filepath.Join(dir, "2012/08/29/170000.json"))
+	if first != "{\"a\": 1}\n{\"b\": 2}\n" {
+		t.Errorf("Unexpected first file %q", first)
+	}
+	if second := readFile(t, expected); second != "{\"c\": 3}\n" {
+		t.Errorf("Unexpected second file %q", second)
+	}
+}
@@ -0,0 +1,227 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Limits imposed by the statuses/filter endpoint for default access.
+const (
+	MaxTrackTerms = 400
+	MaxFollowIDs  = 5000
+	MaxLocations  = 25
+)
+
+// Values of Configuration.FilterLevel.  Tweets are assigned a level
+// according to their expected quality, and streams deliver only Tweets at
+// or above the requested level.
+const (
+	FilterLevelNone   = "none"
+	FilterLevelLow    = "low"
+	FilterLevelMedium = "medium"
+)
+
+// A geographic area given by its south-west and north-east corners, in
+// degrees.
+type BoundingBox struct {
+	SWLongitude float64
+	SWLatitude  float64
+	NELongitude float64
+	NELatitude  float64
+}
+
+// Returns the box in the format expected by the locations parameter:
+// "sw_lon,sw_lat,ne_lon,ne_lat".
+func (b BoundingBox) String() string {
+	coords := []float64{b.SWLongitude, b.SWLatitude, b.NELongitude, b.NELatitude}
+	parts := make([]string, len(coords))
+	for i, coord := range coords {
+		parts[i] = strconv.FormatFloat(coord, 'f', -1, 64)
+	}
+	return strings.Join(parts, ",")
+}
+
+// Returns an error if the box is not usable as a locations filter: its
+// coordinates must be in range, and its south-west corner must be south
+// and west of its north-east corner.  Boxes crossing the antimeridian are
+// rejected, and may be split with Normalize.
+func (b BoundingBox) Validate() error {
+	for _, lat := range []float64{b.SWLatitude, b.NELatitude} {
+		if lat < -90 || lat > 90 {
+			return fmt.Errorf("Latitude out of range in %v: %v", b, lat)
+		}
+	}
+	for _, lon := range []float64{b.SWLongitude, b.NELongitude} {
+		if lon < -180 || lon > 180 {
+			return fmt.Errorf("Longitude out of range in %v: %v", b, lon)
+		}
+	}
+	if b.SWLatitude > b.NELatitude {
+		return fmt.Errorf("South-west corner is north of north-east corner in %v", b)
+	}
+	if b.SWLongitude > b.NELongitude {
+		return fmt.Errorf("Box crosses the antimeridian or has its corners swapped: %v", b)
+	}
+	return nil
+}
+
+// Returns the box as one or more valid boxes covering the same area.
+// Longitudes outside [-180, 180] are wrapped, and a box crossing the
+// antimeridian, with its south-west longitude east of its north-east
+// longitude, is split in two.  Latitudes are clamped to [-90, 90].
+func (b BoundingBox) Normalize() []BoundingBox {
+	b.SWLatitude = math.Max(-90, math.Min(90, b.SWLatitude))
+	b.NELatitude = math.Max(-90, math.Min(90, b.NELatitude))
+	if b.NELongitude-b.SWLongitude >= 360 {
+		b.SWLongitude, b.NELongitude = -180, 180
+		return []BoundingBox{b}
+	}
+	b.SWLongitude = wrapLongitude(b.SWLongitude)
+	b.NELongitude = wrapLongitude(b.NELongitude)
+	if b.SWLongitude <= b.NELongitude {
+		return []BoundingBox{b}
+	}
+	west, east := b, b
+	west.NELongitude = 180
+	east.SWLongitude = -180
+	return []BoundingBox{west, east}
+}
+
+// Wraps lon into [-180, 180].
+func wrapLongitude(lon float64) float64 {
+	if lon >= -180 && lon <= 180 {
+		return lon
+	}
+	lon = math.Mod(lon+180, 360)
+	if lon < 0 {
+		lon += 360
+	}
+	return lon - 180
+}
+
+// The mean number of kilometres per degree of latitude.
+const kmPerDegree = 111.32
+
+// Returns boxes enclosing the circle of the given radius, in kilometres,
+// around a point.  The result is normalized, so it holds two boxes if the
+// circle crosses the antimeridian.
+func BoxAround(latitude float64, longitude float64, radius float64) []BoundingBox {
+	dlat := radius / kmPerDegree
+	box := BoundingBox{
+		SWLatitude: latitude - dlat,
+		NELatitude: latitude + dlat,
+	}
+	// Degrees of longitude shrink towards the poles, so use the latitude
+	// nearest a pole within the box.
+	widest := math.Min(90, math.Max(math.Abs(box.SWLatitude), math.Abs(box.NELatitude)))
+	dlon := 180.0
+	if cos := math.Cos(widest * math.Pi / 180); cos > 0 {
+		dlon = math.Min(180, radius/(kmPerDegree*cos))
+	}
+	box.SWLongitude = longitude - dlon
+	box.NELongitude = longitude + dlon
+	return box.Normalize()
+}
+
+// Approximate bounding boxes of some major cities, keyed by lower case
+// name, for use with CityBox.
+var Cities = map[string]BoundingBox{
+	"berlin":        {13.09, 52.34, 13.76, 52.68},
+	"chicago":       {-87.94, 41.64, -87.52, 42.02},
+	"london":        {-0.51, 51.28, 0.33, 51.69},
+	"los angeles":   {-118.67, 33.70, -118.16, 34.34},
+	"new york":      {-74, 40, -73, 41},
+	"paris":         {2.22, 48.81, 2.47, 48.91},
+	"san francisco": {-122.75, 36.8, -121.75, 37.8},
+	"sao paulo":     {-46.83, -24.01, -46.36, -23.36},
+	"sydney":        {150.52, -34.12, 151.34, -33.58},
+	"tokyo":         {139.56, 35.52, 139.92, 35.82},
+}
+
+// Returns the bounding box of a city in Cities, ignoring case.
+func CityBox(name string) (BoundingBox, error) {
+	box, ok := Cities[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return BoundingBox{}, fmt.Errorf("Unknown city: %q", name)
+	}
+	return box, nil
+}
+
+// Predicates for the statuses/filter endpoint.  At least one of Track,
+// Follow or Locations must be set.
+type FilterParams struct {
+	Track     []string
+	Follow    []int64
+	Locations []BoundingBox
+}
+
+// Returns an error if the parameters are empty or exceed the endpoint limits.
+func (p *FilterParams) Validate() error {
+	if len(p.Track) == 0 && len(p.Follow) == 0 && len(p.Locations) == 0 {
+		return errors.New("FilterParams requires Track, Follow or Locations")
+	}
+	if len(p.Track) > MaxTrackTerms {
+		return fmt.Errorf("Too many track terms: %v (max %v)", len(p.Track), MaxTrackTerms)
+	}
+	if len(p.Follow) > MaxFollowIDs {
+		return fmt.Errorf("Too many follow IDs: %v (max %v)", len(p.Follow), MaxFollowIDs)
+	}
+	if len(p.Locations) > MaxLocations {
+		return fmt.Errorf("Too many locations: %v (max %v)", len(p.Locations), MaxLocations)
+	}
+	for _, box := range p.Locations {
+		if err := box.Validate(); err != nil {
+			return err
+		}
+	}
+	for _, term := range p.Track {
+		if strings.Contains(term, ",") {
+			return fmt.Errorf("Track term may not contain a comma: %q", term)
+		}
+	}
+	return nil
+}
+
+// Validates the parameters and returns them encoded as request parameters.
+func (p *FilterParams) Values() (url.Values, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	values := url.Values{}
+	if len(p.Track) > 0 {
+		values.Set("track", strings.Join(p.Track, ","))
+	}
+	if len(p.Follow) > 0 {
+		ids := make([]string, len(p.Follow))
+		for i, id := range p.Follow {
+			ids[i] = strconv.FormatInt(id, 10)
+		}
+		values.Set("follow", strings.Join(ids, ","))
+	}
+	if len(p.Locations) > 0 {
+		boxes := make([]string, len(p.Locations))
+		for i, box := range p.Locations {
+			boxes[i] = box.String()
+		}
+		values.Set("locations", strings.Join(boxes, ","))
+	}
+	return values, nil
+}
@@ -0,0 +1,179 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/url"
+	"testing"
+)
+
+func TestFilterParamsValues(t *testing.T) {
+	params := &FilterParams{
+		Track:  []string{"twitter", "golang"},
+		Follow: []int64{12, 6253282},
+		Locations: []BoundingBox{
+			{-122.75, 36.8, -121.75, 37.8},
+			{-74, 40, -73, 41},
+		},
+	}
+	values, err := params.Values()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := url.Values{
+		"track":     {"twitter,golang"},
+		"follow":    {"12,6253282"},
+		"locations": {"-122.75,36.8,-121.75,37.8,-74,40,-73,41"},
+	}
+	if values.Encode() != expected.Encode() {
+		t.Errorf("Expected %v, got %v", expected.Encode(), values.Encode())
+	}
+}
+
+func TestFilterParamsValidate(t *testing.T) {
+	invalid := []*FilterParams{
+		{},
+		{Track: make([]string, MaxTrackTerms+1)},
+		{Follow: make([]int64, MaxFollowIDs+1)},
+		{Locations: make([]BoundingBox, MaxLocations+1)},
+		{Track: []string{"a,b"}},
+		{Locations: []BoundingBox{{-121.75, 37.8, -122.75, 36.8}}},
+	}
+	for _, params := range invalid {
+		if err := params.Validate(); err == nil {
+			t.Errorf("Expected error for %+v", params)
+		}
+	}
+	valid := &FilterParams{Track: make([]string, MaxTrackTerms)}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+}
+
+func TestFilterParamsRequest(t *testing.T) {
+	conf := &Configuration{
+		Method: "POST",
+		Filter: &FilterParams{Track: []string{"twitter"}},
+	}
+	conn := newStubConnection(conf, "HTTP/1.1 200 OK\r\n\r\n")
+	conn.Read()
+	req := sentRequest(t, conn)
+	req.ParseForm()
+	if req.PostForm.Get("track") != "twitter" {
+		t.Errorf("Unexpected body params %v", req.PostForm)
+	}
+}
+
+func TestBoundingBoxValidate(t *testing.T) {
+	invalid := []BoundingBox{
+		{-122.75, 37.8, -121.75, 36.8},
+		{170, 10, -170, 20},
+		{-190, 10, -170, 20},
+		{10, -91, 20, 10},
+	}
+	for _, box := range invalid {
+		if err := box.Validate(); err == nil {
+			t.Errorf("Expected error for %v", box)
+		}
+	}
+	if err := (BoundingBox{-180, -90, 180, 90}).Validate(); err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+}
+
+func TestBoundingBoxNormalize(t *testing.T) {
+	cases := map[BoundingBox]string{
+		{-122.75, 36.8, -121.75, 37.8}: "[-122.75,36.8,-121.75,37.8]",
+		{170, 10, -170, 20}:            "[170,10,180,20 -180,10,-170,20]",
+		{170, 10, 190, 20}:             "[170,10,180,20 -180,10,-170,20]",
+		{-10, -95, 10, 95}:             "[-10,-90,10,90]",
+		{-200, 0, 200, 10}:             "[-180,0,180,10]",
+	}
+	for box, expected := range cases {
+		normalized := box.Normalize()
+		if actual := fmt.Sprint(normalized); actual != expected {
+			t.Errorf("Normalize(%v): expected %v, got %v", box, expected, actual)
+		}
+		for _, b := range normalized {
+			if err := b.Validate(); err != nil {
+				t.Errorf("Normalize(%v) returned invalid box: %v", box, err)
+			}
+		}
+	}
+}
+
+func TestBoxAround(t *testing.T) {
+	boxes := BoxAround(0, 0, kmPerDegree)
+	if len(boxes) != 1 {
+		t.Fatalf("Expected one box, got %v", boxes)
+	}
+	// Longitude is widened slightly for the box's northern and southern
+	// edges.
+	if math.Abs(boxes[0].SWLatitude+1) > 1e-9 || math.Abs(boxes[0].SWLongitude+1) > 1e-3 {
+		t.Errorf("Expected a box 1 degree around the origin, got %v", boxes[0])
+	}
+	if boxes := BoxAround(0, 179.5, kmPerDegree); len(boxes) != 2 {
+		t.Errorf("Expected the box to be split at the antimeridian, got %v", boxes)
+	}
+	if boxes := BoxAround(89.9, 0, 100); len(boxes) != 1 || boxes[0].SWLongitude != -180 || boxes[0].NELatitude != 90 {
+		t.Errorf("Expected a polar box to span all longitudes, got %v", boxes)
+	}
+}
+
+func TestCityBox(t *testing.T) {
+	box, err := CityBox("San Francisco")
+	if err != nil || box.String() != "-122.75,36.8,-121.75,37.8" {
+		t.Errorf("Unexpected box %v, error %v", box, err)
+	}
+	if _, err := CityBox("Atlantis"); err == nil {
+		t.Errorf("Expected error for unknown city")
+	}
+	for name, box := range Cities {
+		if err := box.Validate(); err != nil {
+			t.Errorf("Invalid box for %v: %v", name, err)
+		}
+	}
+}
+
+func TestLanguageAndFilterLevel(t *testing.T) {
+	for _, method := range []string{"GET", "POST"} {
+		conf := &Configuration{
+			Method:      method,
+			Filter:      &FilterParams{Track: []string{"twitter"}},
+			Languages:   []string{"en", "es"},
+			FilterLevel: FilterLevelLow,
+		}
+		conn := newStubConnection(conf, "HTTP/1.1 200 OK\r\n\r\n")
+		conn.Read()
+		req := sentRequest(t, conn)
+		req.ParseForm()
+		if req.Form.Get("language") != "en,es" || req.Form.Get("filter_level") != "low" {
+			t.Errorf("%v: unexpected params %v", method, req.Form)
+		}
+	}
+	invalid := []*Configuration{
+		{Method: "GET", FilterLevel: "high"},
+		{Method: "GET", Languages: []string{"en,es"}},
+	}
+	for _, conf := range invalid {
+		conn := newStubConnection(conf, "HTTP/1.1 200 OK\r\n\r\n")
+		if err := conn.Read(); err == nil || err == io.EOF {
+			t.Errorf("Expected error for %+v, got %v", conf, err)
+		}
+	}
+}
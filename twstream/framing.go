@@ -0,0 +1,87 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+)
+
+// A bufio.SplitFunc which splits a stream into top-level JSON objects,
+// tracking nesting and string literals so that braces and newlines inside
+// strings, or newlines between the tokens of a pretty printed object, do not
+// end a message.  Objects split across reads are only returned once
+// complete.  Anything between objects, such as keepalive newlines and
+// delimited=length prefixes, is skipped.
+func ScanJSONObjects(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	start := bytes.IndexByte(data, '{')
+	if start < 0 {
+		return len(data), nil, nil
+	}
+	var (
+		depth    int
+		inString bool
+		escaped  bool
+	)
+	for i := start; i < len(data); i++ {
+		switch c := data[i]; {
+		case escaped:
+			escaped = false
+		case inString:
+			if c == '\\' {
+				escaped = true
+			} else if c == '"' {
+				inString = false
+			}
+		case c == '"':
+			inString = true
+		case c == '{':
+			depth++
+		case c == '}':
+			if depth--; depth == 0 {
+				return i + 1, data[start : i+1], nil
+			}
+		}
+	}
+	if atEOF {
+		return len(data), nil, io.ErrUnexpectedEOF
+	}
+	return start, nil, nil
+}
+
+// Reads messages framed by ScanJSONObjects from reader and delivers them
+// until an error occurs.
+func (c *Connection) readObjects(reader *bufio.Reader) error {
+	buffer := bufferPool.Get().(*[]byte)
+	defer bufferPool.Put(buffer)
+	max := c.maxMessageSize()
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(*buffer, max)
+	scanner.Split(ScanJSONObjects)
+	for scanner.Scan() {
+		if err := c.receive(scanner.Bytes()); err != nil {
+			return err
+		}
+	}
+	switch err := scanner.Err(); err {
+	case nil:
+		return io.EOF
+	case bufio.ErrTooLong:
+		return &MessageTooLargeError{Limit: max}
+	default:
+		return err
+	}
+}
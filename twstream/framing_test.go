@@ -0,0 +1,84 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestScanJSONObjects(t *testing.T) {
+	input := "\r\n{\"a\": \"}\\\"{\", \"b\": {\"c\": [1, {}]}}\r\n\r\n" +
+		"42\r\n{\n  \"text\": \"pretty\"\n}\n"
+	expected := []string{
+		"{\"a\": \"}\\\"{\", \"b\": {\"c\": [1, {}]}}",
+		"{\n  \"text\": \"pretty\"\n}",
+	}
+	scanner := bufio.NewScanner(iotest.OneByteReader(strings.NewReader(input)))
+	scanner.Split(ScanJSONObjects)
+	var actual []string
+	for scanner.Scan() {
+		actual = append(actual, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+	if len(actual) != len(expected) {
+		t.Fatalf("Expected %q, got %q", expected, actual)
+	}
+	for i := range expected {
+		if actual[i] != expected[i] {
+			t.Errorf("Expected %q, got %q", expected[i], actual[i])
+		}
+	}
+}
+
+func TestScanJSONObjectsTruncated(t *testing.T) {
+	scanner := bufio.NewScanner(strings.NewReader("{\"a\": 1}\r\n{\"b\": "))
+	scanner.Split(ScanJSONObjects)
+	if !scanner.Scan() || scanner.Text() != "{\"a\": 1}" {
+		t.Fatalf("Expected first object, got %q", scanner.Text())
+	}
+	if scanner.Scan() {
+		t.Fatalf("Expected truncated object to be dropped, got %q", scanner.Text())
+	}
+	if err := scanner.Err(); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("Expected ErrUnexpectedEOF, got %v", err)
+	}
+}
+
+func TestJSONFraming(t *testing.T) {
+	handler := &CollectingHandler{}
+	conf := &Configuration{
+		Chunked:     true,
+		JSONFraming: true,
+		Handler:     handler,
+	}
+	body := "{\n  \"a\": \"}\"\n}\r\n\r\n{\"b\": 2}\r\n"
+	response := "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n" +
+		chunk(body, 5)
+	conn := newStubConnection(conf, response)
+	if err := conn.Read(); err != io.EOF {
+		t.Fatalf("Expected EOF, got %v", err)
+	}
+	expected := []string{"{\n  \"a\": \"}\"\n}", "{\"b\": 2}"}
+	if len(handler.Messages) != 2 || handler.Messages[0] != expected[0] || handler.Messages[1] != expected[1] {
+		t.Errorf("Expected %q, got %q", expected, handler.Messages)
+	}
+}
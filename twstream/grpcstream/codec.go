@@ -0,0 +1,199 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build grpc
+
+package grpcstream
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"google.golang.org/grpc/encoding"
+)
+
+// The gRPC content subtype of the Stream service, under which Codec is
+// registered.  Clients must call with grpc.CallContentSubtype(ContentSubtype),
+// or send the content type "application/grpc+twstream", so that other
+// services on the same grpc.Server keep the default codec.
+const ContentSubtype = "twstream"
+
+func init() {
+	encoding.RegisterCodec(Codec{})
+}
+
+// Encodes the messages of stream.proto in the protobuf wire format, which
+// is all the service needs, so that the package does not depend on the
+// protobuf runtime.  Only this package's messages are supported.
+type Codec struct{}
+
+func (Codec) Name() string {
+	return ContentSubtype
+}
+
+func (Codec) Marshal(v interface{}) ([]byte, error) {
+	switch m := v.(type) {
+	case *Message:
+		return m.marshal(), nil
+	case *SubscribeRequest:
+		return m.marshal(), nil
+	}
+	return nil, fmt.Errorf("Cannot marshal %T", v)
+}
+
+func (Codec) Unmarshal(data []byte, v interface{}) error {
+	switch m := v.(type) {
+	case *Message:
+		return m.unmarshal(data)
+	case *SubscribeRequest:
+		return m.unmarshal(data)
+	}
+	return fmt.Errorf("Cannot unmarshal %T", v)
+}
+
+// Protobuf wire types.
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+var errMalformed = errors.New("Malformed protobuf message")
+
+func appendTag(b []byte, field int, wireType int) []byte {
+	return binary.AppendUvarint(b, uint64(field)<<3|uint64(wireType))
+}
+
+func appendBytes(b []byte, field int, value []byte) []byte {
+	b = appendTag(b, field, wireBytes)
+	b = binary.AppendUvarint(b, uint64(len(value)))
+	return append(b, value...)
+}
+
+// Calls f with each field of a message.  Value holds the varint for
+// varint fields, and data the contents of length delimited fields.
+func readFields(data []byte, f func(field int, wireType int, value uint64, data []byte) error) error {
+	for len(data) > 0 {
+		tag, n := binary.Uvarint(data)
+		if n <= 0 {
+			return errMalformed
+		}
+		data = data[n:]
+		field, wireType := int(tag>>3), int(tag&7)
+		var value uint64
+		var contents []byte
+		switch wireType {
+		case wireVarint:
+			if value, n = binary.Uvarint(data); n <= 0 {
+				return errMalformed
+			}
+			data = data[n:]
+		case wireFixed64, wireFixed32:
+			size := 8
+			if wireType == wireFixed32 {
+				size = 4
+			}
+			if len(data) < size {
+				return errMalformed
+			}
+			data = data[size:]
+		case wireBytes:
+			size, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < size {
+				return errMalformed
+			}
+			contents = data[n : n+int(size)]
+			data = data[n+int(size):]
+		default:
+			return errMalformed
+		}
+		if err := f(field, wireType, value, contents); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Message) marshal() []byte {
+	var b []byte
+	if m.Seq != 0 {
+		b = binary.AppendUvarint(appendTag(b, 1, wireVarint), m.Seq)
+	}
+	if m.ReceivedAtUnixNano != 0 {
+		b = binary.AppendUvarint(appendTag(b, 2, wireVarint), uint64(m.ReceivedAtUnixNano))
+	}
+	if len(m.Raw) > 0 {
+		b = appendBytes(b, 3, m.Raw)
+	}
+	return b
+}
+
+func (m *Message) unmarshal(data []byte) error {
+	*m = Message{}
+	return readFields(data, func(field int, wireType int, value uint64, data []byte) error {
+		switch {
+		case field == 1 && wireType == wireVarint:
+			m.Seq = value
+		case field == 2 && wireType == wireVarint:
+			m.ReceivedAtUnixNano = int64(value)
+		case field == 3 && wireType == wireBytes:
+			m.Raw = append([]byte(nil), data...)
+		}
+		return nil
+	})
+}
+
+func (r *SubscribeRequest) marshal() []byte {
+	var b []byte
+	for _, keyword := range r.Keywords {
+		b = appendBytes(b, 1, []byte(keyword))
+	}
+	for _, language := range r.Languages {
+		b = appendBytes(b, 2, []byte(language))
+	}
+	if len(r.Users) > 0 {
+		var packed []byte
+		for _, user := range r.Users {
+			packed = binary.AppendUvarint(packed, uint64(user))
+		}
+		b = appendBytes(b, 3, packed)
+	}
+	return b
+}
+
+func (r *SubscribeRequest) unmarshal(data []byte) error {
+	*r = SubscribeRequest{}
+	return readFields(data, func(field int, wireType int, value uint64, data []byte) error {
+		switch {
+		case field == 1 && wireType == wireBytes:
+			r.Keywords = append(r.Keywords, string(data))
+		case field == 2 && wireType == wireBytes:
+			r.Languages = append(r.Languages, string(data))
+		case field == 3 && wireType == wireVarint:
+			r.Users = append(r.Users, int64(value))
+		case field == 3 && wireType == wireBytes:
+			// Repeated scalars are packed by default in proto3.
+			for len(data) > 0 {
+				user, n := binary.Uvarint(data)
+				if n <= 0 {
+					return errMalformed
+				}
+				r.Users = append(r.Users, int64(user))
+				data = data[n:]
+			}
+		}
+		return nil
+	})
+}
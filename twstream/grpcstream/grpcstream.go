@@ -0,0 +1,220 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build grpc
+
+// Package grpcstream serves the messages read by a twstream.Connection to
+// gRPC clients, as the server-streaming Subscribe call defined in
+// stream.proto.  Each subscriber passes its own filters.
+//
+// Set the Server as the Connection's EnvelopeHandler, register it with a
+// grpc.Server, and serve:
+//
+//	server := grpcstream.NewServer(1000)
+//	conf.EnvelopeHandler = server
+//	grpcServer := grpc.NewServer()
+//	server.Register(grpcServer)
+//	go grpcServer.Serve(listener)
+//
+// Messages are encoded by Codec, registered under ContentSubtype, which
+// clients select with grpc.CallContentSubtype(grpcstream.ContentSubtype).
+//
+// The package depends on google.golang.org/grpc, so is only built with the
+// grpc build tag.
+package grpcstream
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/kurrik/golibs/twstream"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Filters selecting the messages sent to a subscriber, as in
+// twstream.Matcher.  Control messages are always sent.
+type SubscribeRequest struct {
+	Keywords  []string
+	Languages []string
+	Users     []int64
+}
+
+// A message sent to subscribers.  Seq and ReceivedAtUnixNano are those of
+// the twstream.Message it was read as.
+type Message struct {
+	Seq                uint64
+	ReceivedAtUnixNano int64
+	Raw                []byte
+}
+
+// Describes the twstream.Stream service of stream.proto.
+var ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "twstream.Stream",
+	HandlerType: (*interface{})(nil),
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Subscribe",
+			Handler:       subscribeHandler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "stream.proto",
+}
+
+// Re-serves the messages passed to it to gRPC subscribers.  Every
+// subscriber has a buffer of BufferSize messages, and a subscriber whose
+// buffer fills is disconnected with codes.ResourceExhausted, so a slow
+// subscriber never blocks the stream.
+type Server struct {
+	BufferSize int
+
+	lock        sync.Mutex
+	subscribers map[*subscriber]bool
+	closed      bool
+	evicted     uint64
+}
+
+type subscriber struct {
+	c       chan *Message
+	filter  twstream.Predicate
+	evicted bool
+}
+
+// Returns a Server buffering up to bufferSize messages per subscriber.
+func NewServer(bufferSize int) *Server {
+	return &Server{BufferSize: bufferSize}
+}
+
+// Registers the Stream service with r.
+func (s *Server) Register(r grpc.ServiceRegistrar) {
+	r.RegisterService(&ServiceDesc, s)
+}
+
+// Sends msg to every subscriber whose filters it matches, disconnecting
+// subscribers whose buffer is full.  Never blocks on slow subscribers.
+func (s *Server) HandleEnvelope(msg *twstream.Message) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var out *Message
+	for sub := range s.subscribers {
+		if sub.filter != nil && !sub.filter(msg.Raw) {
+			continue
+		}
+		if out == nil {
+			out = &Message{
+				Seq:                msg.Seq,
+				ReceivedAtUnixNano: msg.ReceivedAt.UnixNano(),
+				Raw:                append([]byte(nil), msg.Raw...),
+			}
+		}
+		select {
+		case sub.c <- out:
+		default:
+			atomic.AddUint64(&s.evicted, 1)
+			sub.evicted = true
+			s.remove(sub)
+		}
+	}
+	return nil
+}
+
+// Returns the number of connected subscribers.
+func (s *Server) Subscribers() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.subscribers)
+}
+
+// Returns the number of subscribers disconnected for falling behind.
+func (s *Server) Evicted() uint64 {
+	return atomic.LoadUint64(&s.evicted)
+}
+
+// Ends every subscription and refuses new ones.
+func (s *Server) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.closed = true
+	for sub := range s.subscribers {
+		s.remove(sub)
+	}
+	return nil
+}
+
+// Must be called with the lock held.
+func (s *Server) remove(sub *subscriber) {
+	if s.subscribers[sub] {
+		delete(s.subscribers, sub)
+		close(sub.c)
+	}
+}
+
+func subscribeHandler(srv interface{}, stream grpc.ServerStream) error {
+	req := &SubscribeRequest{}
+	if err := stream.RecvMsg(req); err != nil {
+		return err
+	}
+	return srv.(*Server).Subscribe(req, stream)
+}
+
+// Sends the messages matching req to stream until the call is cancelled,
+// the subscriber falls behind or the Server is closed.
+func (s *Server) Subscribe(req *SubscribeRequest, stream grpc.ServerStream) error {
+	sub := &subscriber{c: make(chan *Message, s.BufferSize)}
+	if len(req.Keywords) > 0 || len(req.Languages) > 0 || len(req.Users) > 0 {
+		matcher := &twstream.Matcher{
+			Keywords:  req.Keywords,
+			Languages: req.Languages,
+			Users:     req.Users,
+		}
+		sub.filter = matcher.Predicate()
+	}
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		return status.Error(codes.Unavailable, "Stream closed")
+	}
+	if s.subscribers == nil {
+		s.subscribers = map[*subscriber]bool{}
+	}
+	s.subscribers[sub] = true
+	s.lock.Unlock()
+	defer func() {
+		s.lock.Lock()
+		s.remove(sub)
+		s.lock.Unlock()
+	}()
+	ctx := stream.Context()
+	for {
+		select {
+		case <-ctx.Done():
+			return status.Error(codes.Canceled, ctx.Err().Error())
+		case msg, ok := <-sub.c:
+			if !ok {
+				s.lock.Lock()
+				evicted := sub.evicted
+				s.lock.Unlock()
+				if evicted {
+					return status.Error(codes.ResourceExhausted, "Subscriber too slow")
+				}
+				return nil
+			}
+			if err := stream.SendMsg(msg); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,201 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build grpc
+
+package grpcstream
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/kurrik/golibs/twstream"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/status"
+)
+
+func TestCodecRoundTrip(t *testing.T) {
+	codec := Codec{}
+	msg := &Message{Seq: 300, ReceivedAtUnixNano: 1349740800000000000, Raw: []byte(`{"text":"hi"}`)}
+	data, err := codec.Marshal(msg)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	decoded := &Message{}
+	if err := codec.Unmarshal(data, decoded); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if !reflect.DeepEqual(decoded, msg) {
+		t.Errorf("Expected %+v, got %+v", msg, decoded)
+	}
+	req := &SubscribeRequest{Keywords: []string{"go", "twitter"}, Languages: []string{"en"}, Users: []int64{12, 1 << 40}}
+	if data, err = codec.Marshal(req); err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	decodedReq := &SubscribeRequest{}
+	if err := codec.Unmarshal(data, decodedReq); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if !reflect.DeepEqual(decodedReq, req) {
+		t.Errorf("Expected %+v, got %+v", req, decodedReq)
+	}
+}
+
+func TestCodecRegistered(t *testing.T) {
+	if _, ok := encoding.GetCodec(ContentSubtype).(Codec); !ok {
+		t.Errorf("Expected Codec to be registered as %q", ContentSubtype)
+	}
+}
+
+func TestCodecWireFormat(t *testing.T) {
+	data, _ := Codec{}.Marshal(&Message{Seq: 150, Raw: []byte("ab")})
+	expected := []byte{0x08, 0x96, 0x01, 0x1a, 0x02, 'a', 'b'}
+	if !reflect.DeepEqual(data, expected) {
+		t.Errorf("Expected %x, got %x", expected, data)
+	}
+	// Unpacked users, and an unknown fixed32 field which must be skipped.
+	req := &SubscribeRequest{}
+	err := Codec{}.Unmarshal([]byte{0x18, 0x01, 0x25, 1, 2, 3, 4, 0x18, 0x02}, req)
+	if err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if !reflect.DeepEqual(req.Users, []int64{1, 2}) {
+		t.Errorf("Expected users [1 2], got %v", req.Users)
+	}
+	if err := (Codec{}).Unmarshal([]byte{0x0a, 0x05, 'a'}, req); err == nil {
+		t.Errorf("Expected error for truncated message")
+	}
+}
+
+// A grpc.ServerStream which receives a single request and records the
+// messages sent.
+type fakeStream struct {
+	grpc.ServerStream
+	ctx  context.Context
+	req  *SubscribeRequest
+	sent chan *Message
+}
+
+func newFakeStream(ctx context.Context, req *SubscribeRequest) *fakeStream {
+	return &fakeStream{ctx: ctx, req: req, sent: make(chan *Message, 10)}
+}
+
+func (s *fakeStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *fakeStream) RecvMsg(m interface{}) error {
+	*m.(*SubscribeRequest) = *s.req
+	return nil
+}
+
+func (s *fakeStream) SendMsg(m interface{}) error {
+	s.sent <- m.(*Message)
+	return nil
+}
+
+// Starts a subscription, returning its stream and a channel receiving the
+// handler's result.
+func subscribe(t *testing.T, s *Server, ctx context.Context, req *SubscribeRequest) (*fakeStream, chan error) {
+	stream := newFakeStream(ctx, req)
+	result := make(chan error, 1)
+	count := s.Subscribers()
+	go func() {
+		result <- subscribeHandler(s, stream)
+	}()
+	deadline := time.Now().Add(time.Second)
+	for s.Subscribers() == count {
+		if time.Now().After(deadline) {
+			t.Fatalf("Subscriber did not connect")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return stream, result
+}
+
+func received(t *testing.T, stream *fakeStream) *Message {
+	select {
+	case msg := <-stream.sent:
+		return msg
+	case <-time.After(time.Second):
+		t.Fatalf("No message sent")
+	}
+	return nil
+}
+
+func TestSubscribe(t *testing.T) {
+	s := NewServer(10)
+	ctx, cancel := context.WithCancel(context.Background())
+	all, allResult := subscribe(t, s, ctx, &SubscribeRequest{})
+	golang, _ := subscribe(t, s, ctx, &SubscribeRequest{Keywords: []string{"golang"}})
+	at := time.Unix(1349740800, 0)
+	s.HandleEnvelope(&twstream.Message{Seq: 1, ReceivedAt: at, Raw: []byte(`{"text":"hello"}`)})
+	s.HandleEnvelope(&twstream.Message{Seq: 2, ReceivedAt: at, Raw: []byte(`{"text":"golang rocks"}`)})
+	s.HandleEnvelope(&twstream.Message{Seq: 3, ReceivedAt: at, Raw: []byte(`{"delete":{"status":{"id":1}}}`)})
+	for _, seq := range []uint64{1, 2, 3} {
+		if msg := received(t, all); msg.Seq != seq || msg.ReceivedAtUnixNano != at.UnixNano() {
+			t.Errorf("Expected seq %v at %v, got %+v", seq, at.UnixNano(), msg)
+		}
+	}
+	for _, seq := range []uint64{2, 3} {
+		if msg := received(t, golang); msg.Seq != seq {
+			t.Errorf("Expected seq %v for filtered subscriber, got %v", seq, msg.Seq)
+		}
+	}
+	cancel()
+	if err := <-allResult; status.Code(err) != codes.Canceled {
+		t.Errorf("Expected Canceled, got %v", err)
+	}
+}
+
+func TestSubscribeEviction(t *testing.T) {
+	s := NewServer(1)
+	stream, result := subscribe(t, s, context.Background(), &SubscribeRequest{})
+	// Block the subscriber in SendMsg, so that its buffer fills.
+	stream.sent = make(chan *Message)
+	for i := 1; i <= 3; i++ {
+		s.HandleEnvelope(&twstream.Message{Seq: uint64(i), Raw: []byte(`{"text":"hi"}`)})
+	}
+	var err error
+	for done := false; !done; {
+		select {
+		case <-stream.sent:
+		case err = <-result:
+			done = true
+		}
+	}
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("Expected ResourceExhausted, got %v", err)
+	}
+	if s.Evicted() != 1 || s.Subscribers() != 0 {
+		t.Errorf("Expected 1 eviction and no subscribers, got %v and %v", s.Evicted(), s.Subscribers())
+	}
+}
+
+func TestServerClose(t *testing.T) {
+	s := NewServer(10)
+	_, result := subscribe(t, s, context.Background(), &SubscribeRequest{})
+	s.Close()
+	if err := <-result; err != nil {
+		t.Errorf("Expected nil after Close, got %v", err)
+	}
+	stream := newFakeStream(context.Background(), &SubscribeRequest{})
+	if err := subscribeHandler(s, stream); status.Code(err) != codes.Unavailable {
+		t.Errorf("Expected Unavailable after Close, got %v", err)
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// The HealthWindow used when a Configuration does not specify one: three
+// missed keepalives, which Twitter sends every 30 seconds.
+const DefaultHealthWindow = 90 * time.Second
+
+// Returns an http.Handler serving the connection's health, for process
+// supervisors such as Kubernetes probes:
+//
+// /healthz responds 200 if the stream is connected and has received data,
+// including keepalives, within the Configuration's HealthWindow, and 503
+// otherwise.
+//
+// /statusz responds with the connection's Stats as JSON.
+func (c *Connection) HealthHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", c.serveHealthz)
+	mux.HandleFunc("/statusz", c.serveStatusz)
+	return mux
+}
+
+func (c *Connection) serveHealthz(w http.ResponseWriter, r *http.Request) {
+	if err := c.healthy(); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, "ok")
+}
+
+func (c *Connection) serveStatusz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c.Stats())
+}
+
+// Returns an error describing why the connection is unhealthy, or nil.
+func (c *Connection) healthy() error {
+	if state := State(atomic.LoadInt32(&c.counters.state)); state != StateConnected {
+		return fmt.Errorf("Stream %v", state)
+	}
+	window := c.conf.HealthWindow
+	if window <= 0 {
+		window = DefaultHealthWindow
+	}
+	connected := time.Unix(0, atomic.LoadInt64(&c.counters.connected))
+	last := latest(connected, atomic.LoadInt64(&c.counters.lastRead))
+	if silence := c.now().Sub(last); silence > window {
+		return fmt.Errorf("Nothing received for %v", silence)
+	}
+	return nil
+}
+
+// Starts serving the HealthHandler on Configuration.HealthAddr, unless it is
+// unset or already being served.  The listener is closed by Close.
+func (c *Connection) serveHealth() error {
+	if c.conf.HealthAddr == "" {
+		return nil
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.health != nil || atomic.LoadInt32(&c.shutdown) != running {
+		return nil
+	}
+	listener, err := net.Listen("tcp", c.conf.HealthAddr)
+	if err != nil {
+		return classify(ClassConfig, err)
+	}
+	c.health = &http.Server{
+		Handler:           c.HealthHandler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	c.healthAddr = listener.Addr()
+	go c.health.Serve(listener)
+	return nil
+}
+
+// Stops serving the HealthHandler.
+func (c *Connection) closeHealth() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.health != nil {
+		c.health.Close()
+	}
+}
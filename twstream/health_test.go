@@ -0,0 +1,92 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func healthStatus(t *testing.T, handler http.Handler, path string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", path, nil))
+	return recorder
+}
+
+func TestHealthHandler(t *testing.T) {
+	now := time.Unix(1349740800, 0)
+	c := NewConnection(&Configuration{HealthWindow: time.Minute}, nil)
+	c.conf.Clock = clockFunc(func() time.Time { return now })
+	handler := c.HealthHandler()
+	if code := healthStatus(t, handler, "/healthz").Code; code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 while disconnected, got %v", code)
+	}
+	c.setState(StateConnected)
+	if code := healthStatus(t, handler, "/healthz").Code; code != http.StatusOK {
+		t.Errorf("Expected 200 once connected, got %v", code)
+	}
+	now = now.Add(2 * time.Minute)
+	if code := healthStatus(t, handler, "/healthz").Code; code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 after silence, got %v", code)
+	}
+	c.countBytes(2)
+	if code := healthStatus(t, handler, "/healthz").Code; code != http.StatusOK {
+		t.Errorf("Expected 200 after a keepalive, got %v", code)
+	}
+	recorder := healthStatus(t, handler, "/statusz")
+	status := struct {
+		Bytes uint64
+		State string
+	}{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &status); err != nil {
+		t.Fatalf("Could not decode /statusz: %v", err)
+	}
+	if status.Bytes != 2 || status.State != "connected" {
+		t.Errorf("Unexpected status %+v", status)
+	}
+}
+
+func TestHealthAddr(t *testing.T) {
+	codes := []int{}
+	var c *Connection
+	conf := &Configuration{
+		HealthAddr: "127.0.0.1:0",
+		Handler: HandlerFunc(func(msg []byte) error {
+			resp, err := http.Get("http://" + c.healthAddr.String() + "/healthz")
+			if err != nil {
+				return err
+			}
+			resp.Body.Close()
+			codes = append(codes, resp.StatusCode)
+			return nil
+		}),
+	}
+	c = newStubConnection(conf, SINK_RESPONSE)
+	if err := c.Read(); err != io.EOF {
+		t.Fatalf("Expected EOF, got %v", err)
+	}
+	if len(codes) != 2 || codes[0] != http.StatusOK || codes[1] != http.StatusOK {
+		t.Errorf("Expected healthy while reading, got %v", codes)
+	}
+	addr := c.healthAddr.String()
+	c.Close()
+	if _, err := http.Get("http://" + addr + "/healthz"); err == nil {
+		t.Errorf("Expected health listener to be closed")
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func newHTTPConnection(conf *Configuration, handler http.HandlerFunc) (*Connection, *httptest.Server) {
+	server := httptest.NewServer(handler)
+	conf.URL, _ = url.Parse(server.URL + "/1.1/statuses/sample.json")
+	conf.HTTPClient = server.Client()
+	return newStubConnection(conf, ""), server
+}
+
+func TestHTTPClient(t *testing.T) {
+	handler := &CollectingHandler{}
+	conf := &Configuration{
+		Handler:       handler,
+		Chunked:       true,
+		StallWarnings: true,
+	}
+	conn, server := newHTTPConnection(conf, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("stall_warnings") != "true" {
+			t.Errorf("Expected stall_warnings parameter, got %q", r.URL.RawQuery)
+		}
+		if r.Header.Get("Authorization") == "" {
+			t.Errorf("Request was not signed")
+		}
+		w.Header().Set("Trailer", "X-Stream-Status")
+		io.WriteString(w, "{\"a\": 1}\r\n")
+		w.(http.Flusher).Flush()
+		io.WriteString(w, "\r\n{\"b\": 2}\r\n")
+		w.Header().Set("X-Stream-Status", "done")
+	})
+	defer server.Close()
+	if err := conn.Read(); err != io.EOF {
+		t.Fatalf("Expected EOF, got %v", err)
+	}
+	if len(handler.Messages) != 2 || handler.Messages[1] != "{\"b\": 2}" {
+		t.Errorf("Unexpected messages %q", handler.Messages)
+	}
+	if status := conn.Trailer().Get("X-Stream-Status"); status != "done" {
+		t.Errorf("Expected trailer, got %q", status)
+	}
+}
+
+func TestHTTPClientGZip(t *testing.T) {
+	handler := &CollectingHandler{}
+	conf := &Configuration{Handler: handler, GZip: true}
+	conn, server := newHTTPConnection(conf, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		z := gzip.NewWriter(w)
+		io.WriteString(z, "{\"a\": 1}\r\n")
+		z.Close()
+	})
+	defer server.Close()
+	if err := conn.Read(); err != io.EOF {
+		t.Fatalf("Expected EOF, got %v", err)
+	}
+	if len(handler.Messages) != 1 || handler.Messages[0] != "{\"a\": 1}" {
+		t.Errorf("Unexpected messages %q", handler.Messages)
+	}
+}
+
+func TestHTTPClientStatus(t *testing.T) {
+	conf := &Configuration{}
+	conn, server := newHTTPConnection(conf, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(429)
+	})
+	defer server.Close()
+	err := conn.Read()
+	status, ok := err.(*StatusError)
+	if !ok || status.StatusCode != 429 || status.RetryAfter.Seconds() != 30 {
+		t.Errorf("Expected StatusError with RetryAfter, got %v", err)
+	}
+}
+
+func TestHTTPClientCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	conf := &Configuration{
+		Handler: HandlerFunc(func(msg []byte) error {
+			cancel()
+			return nil
+		}),
+	}
+	conn, server := newHTTPConnection(conf, func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "{\"a\": 1}\r\n")
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	})
+	defer server.Close()
+	if err := conn.ReadContext(ctx); err != context.Canceled {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build kafka
+
+// Package kafkasink forwards messages from a twstream Connection to a Kafka
+// topic using github.com/segmentio/kafka-go.
+//
+// The package depends on kafka-go, so is only built with the kafka build
+// tag.
+package kafkasink
+
+import (
+	"context"
+	"github.com/segmentio/kafka-go"
+	"sync"
+)
+
+// The BatchSize used when a Sink does not specify one, which matches the
+// default batch size of kafka.Writer.
+const DefaultBatchSize = 100
+
+// Writes messages to Kafka.  *kafka.Writer implements this interface.
+type MessageWriter interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// A twstream.QueueSink which writes messages to Kafka in batches of
+// BatchSize.  The Writer must be synchronous, which is the default for
+// kafka.Writer, so that writing a batch waits for the brokers to
+// acknowledge it according to the writer's RequiredAcks.  Give the Writer a
+// BatchSize of at least the Sink's, so full batches are sent without
+// waiting for its BatchTimeout.
+//
+// Write returns the error from writing a full batch.  If a batch fails it is
+// kept, and the next Write or Flush retries it.
+type Sink struct {
+	Writer    MessageWriter
+	BatchSize int
+	// Returns the key of each message, such as the Tweet ID, which selects
+	// its partition.  If nil, messages have no key.
+	Key   func(msg []byte) []byte
+	lock  sync.Mutex
+	batch []kafka.Message
+}
+
+// Returns a Sink writing batches of DefaultBatchSize messages with writer,
+// for example:
+//
+//	conf.Sink = kafkasink.New(&kafka.Writer{
+//		Addr:  kafka.TCP("localhost:9092"),
+//		Topic: "tweets",
+//	})
+func New(writer MessageWriter) *Sink {
+	return &Sink{Writer: writer, BatchSize: DefaultBatchSize}
+}
+
+func (s *Sink) Write(msg []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	message := kafka.Message{Value: append([]byte(nil), msg...)}
+	if s.Key != nil {
+		message.Key = s.Key(message.Value)
+	}
+	s.batch = append(s.batch, message)
+	size := s.BatchSize
+	if size <= 0 {
+		size = DefaultBatchSize
+	}
+	if len(s.batch) < size {
+		return nil
+	}
+	return s.flush()
+}
+
+// Writes any buffered messages, waiting for them to be acknowledged.
+func (s *Sink) Flush() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.flush()
+}
+
+func (s *Sink) flush() error {
+	if len(s.batch) == 0 {
+		return nil
+	}
+	if err := s.Writer.WriteMessages(context.Background(), s.batch...); err != nil {
+		return err
+	}
+	s.batch = nil
+	return nil
+}
+
+// Flushes buffered messages and closes the Writer.
+func (s *Sink) Close() error {
+	err := s.Flush()
+	if cerr := s.Writer.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,87 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build kafka
+
+package kafkasink
+
+import (
+	"context"
+	"errors"
+	"github.com/segmentio/kafka-go"
+	"testing"
+)
+
+type RecordingWriter struct {
+	Batches [][]kafka.Message
+	Err     error
+	Closed  bool
+}
+
+func (w *RecordingWriter) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	if w.Err != nil {
+		return w.Err
+	}
+	w.Batches = append(w.Batches, append([]kafka.Message(nil), msgs...))
+	return nil
+}
+
+func (w *RecordingWriter) Close() error {
+	w.Closed = true
+	return nil
+}
+
+func TestSinkBatches(t *testing.T) {
+	writer := &RecordingWriter{}
+	sink := &Sink{
+		Writer:    writer,
+		BatchSize: 2,
+		Key: func(msg []byte) []byte {
+			return msg[:1]
+		},
+	}
+	buffer := []byte("a1")
+	sink.Write(buffer)
+	// The stream reuses its buffer, so the sink must have copied it.
+	copy(buffer, "b2")
+	sink.Write(buffer)
+	sink.Write([]byte("c3"))
+	if len(writer.Batches) != 1 || len(writer.Batches[0]) != 2 {
+		t.Fatalf("Expected one batch of 2, got %v", writer.Batches)
+	}
+	if first := writer.Batches[0][0]; string(first.Value) != "a1" || string(first.Key) != "a" {
+		t.Errorf("Unexpected message %+v", first)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(writer.Batches) != 2 || string(writer.Batches[1][0].Value) != "c3" || !writer.Closed {
+		t.Errorf("Expected remaining message to be flushed on Close, got %v", writer.Batches)
+	}
+}
+
+func TestSinkRetriesFailedBatch(t *testing.T) {
+	writer := &RecordingWriter{Err: errors.New("not enough replicas")}
+	sink := &Sink{Writer: writer, BatchSize: 1}
+	if err := sink.Write([]byte("a1")); err != writer.Err {
+		t.Fatalf("Expected write error, got %v", err)
+	}
+	writer.Err = nil
+	if err := sink.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if len(writer.Batches) != 1 || string(writer.Batches[0][0].Value) != "a1" {
+		t.Errorf("Expected failed batch to be retried, got %v", writer.Batches)
+	}
+}
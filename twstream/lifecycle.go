@@ -0,0 +1,52 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"net/http"
+	"time"
+)
+
+// Sent to the EventHandler, if LifecycleEvents is set, before a connection
+// to the stream is opened.
+type Connecting struct {
+	URL string
+}
+
+// Sent to the EventHandler once the stream has responded successfully.
+type Connected struct {
+	Header http.Header
+}
+
+// Sent to the EventHandler when an opened connection ends.  Err is the
+// reason, or nil if the stream ended because its TTL expired.
+type Disconnected struct {
+	Err error
+}
+
+// Sent to the EventHandler by Run before it waits to reconnect.  Attempt
+// counts the reconnections since the stream was last connected, from 1.
+type Reconnecting struct {
+	Err     error
+	Attempt int
+	Delay   time.Duration
+}
+
+// Passes a lifecycle event to the EventHandler if LifecycleEvents is set.
+func (c *Connection) event(event Event) {
+	if c.conf.LifecycleEvents && c.conf.EventHandler != nil {
+		c.conf.EventHandler.HandleEvent(event)
+	}
+}
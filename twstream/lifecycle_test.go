@@ -0,0 +1,66 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestLifecycleEvents(t *testing.T) {
+	stop := errors.New("stop")
+	var events []string
+	conf := &Configuration{
+		Backoff:         &Backoff{HTTPInitial: time.Millisecond, HTTPMax: time.Millisecond},
+		LifecycleEvents: true,
+		EventHandler: EventHandlerFunc(func(event Event) {
+			switch e := event.(type) {
+			case *Connecting:
+				events = append(events, "connecting "+e.URL)
+			case *Connected:
+				events = append(events, "connected "+e.Header.Get("X-Test"))
+			case *Disconnected:
+				events = append(events, fmt.Sprintf("disconnected %v", e.Err))
+			case *Reconnecting:
+				events = append(events, fmt.Sprintf("reconnecting %v %v", e.Attempt, e.Delay))
+			}
+		}),
+		Handler: HandlerFunc(func(msg []byte) error {
+			return stop
+		}),
+	}
+	conf.Dialer = &SequenceDialer{Responses: []string{
+		"HTTP/1.1 503 Service Unavailable\r\n\r\n",
+		"HTTP/1.1 200 OK\r\nX-Test: yes\r\n\r\n{}\r\n",
+	}}
+	conn := newStubConnection(conf, "")
+	if err := conn.Run(); err != stop {
+		t.Fatalf("Expected handler error, got %v", err)
+	}
+	url := conf.URL.String()
+	expected := []string{
+		"connecting " + url,
+		"disconnected Unexpected response status: 503 Service Unavailable",
+		"reconnecting 1 1ms",
+		"connecting " + url,
+		"connected yes",
+		"disconnected stop",
+	}
+	if fmt.Sprint(events) != fmt.Sprint(expected) {
+		t.Errorf("Expected events %q, got %q", expected, events)
+	}
+}
@@ -0,0 +1,280 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// An out-of-band notification parsed from the stream, such as a
+// *StallWarning or *DeleteNotice, or a change in the state of the
+// connection, such as *Connected.  Use a type switch to distinguish events.
+type Event interface{}
+
+// Receives events from a stream.  Messages which are delivered as events are
+// not passed to the Handler or TweetHandler, but are still written to the
+// Sink.
+type EventHandler interface {
+	HandleEvent(event Event)
+}
+
+// Adapts an ordinary function to the EventHandler interface.
+type EventHandlerFunc func(event Event)
+
+func (f EventHandlerFunc) HandleEvent(event Event) {
+	f(event)
+}
+
+// Sent when the client is falling behind and risks being disconnected.
+// Requires Configuration.StallWarnings.
+type StallWarning struct {
+	Code        string `json:"code"`
+	Message     string `json:"message"`
+	PercentFull int    `json:"percent_full"`
+}
+
+// Sent when a Tweet has been deleted.  Applications storing Tweets must
+// delete the identified Tweet.
+type DeleteNotice struct {
+	ID        int64  `json:"id"`
+	IDStr     string `json:"id_str"`
+	UserID    int64  `json:"user_id"`
+	UserIDStr string `json:"user_id_str"`
+}
+
+// Sent on user and site streams when a direct message has been deleted.
+// Applications storing direct messages must delete the identified message.
+type DirectMessageDeleteNotice struct {
+	ID        int64  `json:"id"`
+	IDStr     string `json:"id_str"`
+	UserID    int64  `json:"user_id"`
+	UserIDStr string `json:"user_id_str"`
+}
+
+// Sent when a filtered stream matches more Tweets than it is allowed to
+// deliver.  Track is the number of undelivered Tweets since the connection
+// was opened.
+type LimitNotice struct {
+	Track       int64  `json:"track"`
+	TimestampMs string `json:"timestamp_ms"`
+}
+
+// Sent when a user has removed geolocation data from their Tweets.
+// Applications must strip geodata from the user's Tweets up to and including
+// UpToStatusID.
+type ScrubGeoNotice struct {
+	UserID          int64  `json:"user_id"`
+	UserIDStr       string `json:"user_id_str"`
+	UpToStatusID    int64  `json:"up_to_status_id"`
+	UpToStatusIDStr string `json:"up_to_status_id_str"`
+}
+
+// Sent when a Tweet is withheld in the given countries.
+type StatusWithheldNotice struct {
+	ID                  int64    `json:"id"`
+	UserID              int64    `json:"user_id"`
+	WithheldInCountries []string `json:"withheld_in_countries"`
+}
+
+// Sent when a user is withheld in the given countries.
+type UserWithheldNotice struct {
+	ID                  int64    `json:"id"`
+	WithheldInCountries []string `json:"withheld_in_countries"`
+}
+
+// Reason codes sent in disconnect messages.
+const (
+	DisconnectShutdown        = 1
+	DisconnectDuplicateStream = 2
+	DisconnectControlRequest  = 3
+	DisconnectStall           = 4
+	DisconnectNormal          = 5
+	DisconnectTokenRevoked    = 6
+	DisconnectAdminLogout     = 7
+	DisconnectMaxMessageLimit = 9
+	DisconnectStreamException = 10
+	DisconnectBrokerStall     = 11
+	DisconnectShedLoad        = 12
+)
+
+// Sent immediately before the stream closes the connection.  Code is one
+// of the Disconnect constants.  Disconnect notices are passed to the
+// EventHandler if one is set, and the read then ends with a DisconnectError.
+type DisconnectNotice struct {
+	Code       int    `json:"code"`
+	StreamName string `json:"stream_name"`
+	Reason     string `json:"reason"`
+}
+
+// Sent at the start of a user stream, listing the IDs of the users the
+// authenticated user follows.  IDs are decoded from either the friends or
+// the friends_str form, as requested by Configuration.StringifyFriendIDs.
+type FriendsList struct {
+	IDs []string
+}
+
+// Holds the payload of any of the control messages decoded as events.
+type controlEnvelope struct {
+	Warning *StallWarning `json:"warning"`
+	Delete  *struct {
+		Status        *DeleteNotice              `json:"status"`
+		DirectMessage *DirectMessageDeleteNotice `json:"direct_message"`
+	} `json:"delete"`
+	Limit          *LimitNotice          `json:"limit"`
+	ScrubGeo       *ScrubGeoNotice       `json:"scrub_geo"`
+	StatusWithheld *StatusWithheldNotice `json:"status_withheld"`
+	UserWithheld   *UserWithheldNotice   `json:"user_withheld"`
+	Disconnect     *DisconnectNotice     `json:"disconnect"`
+	Control        *ControlNotice        `json:"control"`
+	Error          *PowerTrackNotice     `json:"error"`
+	Warn           *PowerTrackNotice     `json:"warn"`
+	Info           *PowerTrackNotice     `json:"info"`
+	Friends        []json.Number         `json:"friends"`
+	FriendsStr     []string              `json:"friends_str"`
+	UserDelete     *UserComplianceNotice `json:"user_delete"`
+	UserUndelete   *UserComplianceNotice `json:"user_undelete"`
+	UserProtect    *UserComplianceNotice `json:"user_protect"`
+	UserUnprotect  *UserComplianceNotice `json:"user_unprotect"`
+	UserSuspend    *UserComplianceNotice `json:"user_suspend"`
+	UserUnsuspend  *UserComplianceNotice `json:"user_unsuspend"`
+}
+
+// Returns the first key of the JSON object in msg, which identifies the type
+// of Twitter's single-key control messages such as {"warning": {...}}.
+// Returns "" if msg does not start with an object key.
+func messageType(msg []byte) string {
+	return string(messageKey(msg))
+}
+
+// Like messageType, but returns the key as a slice of msg, which avoids an
+// allocation per message when only comparing it.
+func messageKey(msg []byte) []byte {
+	i := skipSpace(msg, 0)
+	if i >= len(msg) || msg[i] != '{' {
+		return nil
+	}
+	i = skipSpace(msg, i+1)
+	if i >= len(msg) || msg[i] != '"' {
+		return nil
+	}
+	start := i + 1
+	for i = start; i < len(msg); i++ {
+		switch msg[i] {
+		case '\\':
+			return nil
+		case '"':
+			return msg[start:i]
+		}
+	}
+	return nil
+}
+
+func skipSpace(msg []byte, i int) int {
+	for i < len(msg) {
+		switch msg[i] {
+		case ' ', '\t', '\r', '\n':
+			i++
+		default:
+			return i
+		}
+	}
+	return i
+}
+
+// Reports whether kind, as returned by messageType, identifies a control
+// message which is decoded as an Event.
+func isControl(kind string) bool {
+	switch kind {
+	case "warning", "delete", "limit", "scrub_geo", "status_withheld",
+		"user_withheld", "disconnect", "control", PowerTrackError,
+		PowerTrackWarn, PowerTrackInfo, "friends", "friends_str", UserDelete,
+		UserUndelete, UserProtect, UserUnprotect, UserSuspend, UserUnsuspend:
+		return true
+	}
+	return false
+}
+
+// Decodes msg into an Event if it is a recognized control message.  Returns
+// a nil Event for other messages, such as Tweets.
+func decodeEvent(msg []byte) (Event, error) {
+	kind := messageType(msg)
+	if !isControl(kind) {
+		return nil, nil
+	}
+	envelope := &controlEnvelope{}
+	if err := json.Unmarshal(msg, envelope); err != nil {
+		return nil, err
+	}
+	var event Event
+	switch {
+	case envelope.Warning != nil:
+		event = envelope.Warning
+	case envelope.Delete != nil && envelope.Delete.Status != nil:
+		event = envelope.Delete.Status
+	case envelope.Delete != nil && envelope.Delete.DirectMessage != nil:
+		event = envelope.Delete.DirectMessage
+	case envelope.Limit != nil:
+		event = envelope.Limit
+	case envelope.ScrubGeo != nil:
+		event = envelope.ScrubGeo
+	case envelope.StatusWithheld != nil:
+		event = envelope.StatusWithheld
+	case envelope.UserWithheld != nil:
+		event = envelope.UserWithheld
+	case envelope.Disconnect != nil:
+		event = envelope.Disconnect
+	case envelope.Control != nil:
+		event = envelope.Control
+	case envelope.Error != nil:
+		envelope.Error.Kind = PowerTrackError
+		event = envelope.Error
+	case envelope.Warn != nil:
+		envelope.Warn.Kind = PowerTrackWarn
+		event = envelope.Warn
+	case envelope.Info != nil:
+		envelope.Info.Kind = PowerTrackInfo
+		event = envelope.Info
+	case envelope.Friends != nil:
+		friends := &FriendsList{IDs: make([]string, len(envelope.Friends))}
+		for i, id := range envelope.Friends {
+			friends.IDs[i] = id.String()
+		}
+		event = friends
+	case envelope.FriendsStr != nil:
+		event = &FriendsList{IDs: envelope.FriendsStr}
+	case envelope.UserDelete != nil:
+		envelope.UserDelete.Kind = UserDelete
+		event = envelope.UserDelete
+	case envelope.UserUndelete != nil:
+		envelope.UserUndelete.Kind = UserUndelete
+		event = envelope.UserUndelete
+	case envelope.UserProtect != nil:
+		envelope.UserProtect.Kind = UserProtect
+		event = envelope.UserProtect
+	case envelope.UserUnprotect != nil:
+		envelope.UserUnprotect.Kind = UserUnprotect
+		event = envelope.UserUnprotect
+	case envelope.UserSuspend != nil:
+		envelope.UserSuspend.Kind = UserSuspend
+		event = envelope.UserSuspend
+	case envelope.UserUnsuspend != nil:
+		envelope.UserUnsuspend.Kind = UserUnsuspend
+		event = envelope.UserUnsuspend
+	default:
+		return nil, fmt.Errorf("Malformed %v message", kind)
+	}
+	return event, nil
+}
@@ -0,0 +1,230 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const WARNING_JSON = `{"warning":{"code":"FALLING_BEHIND",` +
+	`"message":"Your connection is falling behind.","percent_full":60}}`
+
+func TestMessageType(t *testing.T) {
+	cases := map[string]string{
+		WARNING_JSON:             "warning",
+		` { "delete" : {}}`:      "delete",
+		`{"created_at":"x"}`:     "created_at",
+		``:                       "",
+		`[1, 2]`:                 "",
+		`{"unterminated`:         "",
+		`{"esc\"aped": 1}`:       "",
+		"\r\n{\"limit\":{}}\r\n": "limit",
+	}
+	for msg, expected := range cases {
+		if actual := messageType([]byte(msg)); actual != expected {
+			t.Errorf("messageType(%q): expected %q, got %q", msg, expected, actual)
+		}
+	}
+}
+
+func TestStallWarningEvent(t *testing.T) {
+	var events []Event
+	var messages []string
+	conf := &Configuration{
+		StallWarnings: true,
+		EventHandler: EventHandlerFunc(func(event Event) {
+			events = append(events, event)
+		}),
+		Handler: HandlerFunc(func(msg []byte) error {
+			messages = append(messages, string(msg))
+			return nil
+		}),
+	}
+	response := "HTTP/1.1 200 OK\r\n\r\n" +
+		WARNING_JSON + "\r\n" +
+		TWEET_JSON + "\r\n"
+	conn := newStubConnection(conf, response)
+	if err := conn.Read(); err != io.EOF {
+		t.Fatalf("Expected EOF, got %v", err)
+	}
+	if req := sentRequest(t, conn); req.URL.Query().Get("stall_warnings") != "true" {
+		t.Errorf("Expected stall_warnings parameter, got %q", req.URL.RawQuery)
+	}
+	if len(events) != 1 {
+		t.Fatalf("Expected 1 event, got %v", len(events))
+	}
+	warning, ok := events[0].(*StallWarning)
+	if !ok {
+		t.Fatalf("Expected *StallWarning, got %T", events[0])
+	}
+	if warning.Code != "FALLING_BEHIND" || warning.PercentFull != 60 {
+		t.Errorf("Unexpected warning %+v", warning)
+	}
+	if len(messages) != 1 || messages[0] != TWEET_JSON {
+		t.Errorf("Unexpected messages %q", messages)
+	}
+}
+
+func TestDecodeControlEvents(t *testing.T) {
+	cases := map[string]Event{
+		`{"delete":{"status":{"id":1234,"id_str":"1234","user_id":3,"user_id_str":"3"}}}`: &DeleteNotice{
+			ID:        1234,
+			IDStr:     "1234",
+			UserID:    3,
+			UserIDStr: "3",
+		},
+		`{"delete":{"direct_message":{"id":1234,"id_str":"1234","user_id":3,"user_id_str":"3"}}}`: &DirectMessageDeleteNotice{
+			ID:        1234,
+			IDStr:     "1234",
+			UserID:    3,
+			UserIDStr: "3",
+		},
+		`{"limit":{"track":1234,"timestamp_ms":"1378316738765"}}`: &LimitNotice{
+			Track:       1234,
+			TimestampMs: "1378316738765",
+		},
+		`{"scrub_geo":{"user_id":14090452,"user_id_str":"14090452","up_to_status_id":23260136625,"up_to_status_id_str":"23260136625"}}`: &ScrubGeoNotice{
+			UserID:          14090452,
+			UserIDStr:       "14090452",
+			UpToStatusID:    23260136625,
+			UpToStatusIDStr: "23260136625",
+		},
+		`{"status_withheld":{"id":1234567890,"user_id":123456,"withheld_in_countries":["DE","AR"]}}`: &StatusWithheldNotice{
+			ID:                  1234567890,
+			UserID:              123456,
+			WithheldInCountries: []string{"DE", "AR"},
+		},
+		`{"user_withheld":{"id":123456,"withheld_in_countries":["DE","AR"]}}`: &UserWithheldNotice{
+			ID:                  123456,
+			WithheldInCountries: []string{"DE", "AR"},
+		},
+		`{"friends":[1497,9223372036854775807]}`: &FriendsList{
+			IDs: []string{"1497", "9223372036854775807"},
+		},
+		`{"friends_str":["1497","9223372036854775807"]}`: &FriendsList{
+			IDs: []string{"1497", "9223372036854775807"},
+		},
+		TWEET_JSON: nil,
+	}
+	for msg, expected := range cases {
+		event, err := decodeEvent([]byte(msg))
+		if err != nil {
+			t.Errorf("decodeEvent(%q) returned error: %v", msg, err)
+			continue
+		}
+		if !reflect.DeepEqual(event, expected) {
+			t.Errorf("decodeEvent(%q): expected %#v, got %#v", msg, expected, event)
+		}
+	}
+	if _, err := decodeEvent([]byte(`{"delete":{}}`)); err == nil {
+		t.Errorf("Expected error for malformed delete notice")
+	}
+}
+
+func TestDirectMessageDelete(t *testing.T) {
+	var events []Event
+	handler := &CollectingHandler{}
+	conf := &Configuration{
+		Handler: handler,
+		EventHandler: EventHandlerFunc(func(event Event) {
+			events = append(events, event)
+		}),
+	}
+	response := "HTTP/1.1 200 OK\r\n\r\n" +
+		`{"delete":{"direct_message":{"id":1234,"user_id":3}}}` + "\r\n" +
+		"{\"a\": 1}\r\n"
+	conn := newStubConnection(conf, response)
+	if err := conn.Read(); err != io.EOF {
+		t.Fatalf("Expected EOF, got %v", err)
+	}
+	if len(events) != 1 {
+		t.Fatalf("Expected 1 event, got %v", len(events))
+	}
+	if notice, ok := events[0].(*DirectMessageDeleteNotice); !ok || notice.ID != 1234 {
+		t.Errorf("Unexpected event %#v", events[0])
+	}
+	if len(handler.Messages) != 1 {
+		t.Errorf("Expected the following message to be delivered, got %q", handler.Messages)
+	}
+}
+
+func TestDisconnect(t *testing.T) {
+	cases := map[int]int{
+		DisconnectStall:        2,
+		DisconnectTokenRevoked: 1,
+		DisconnectAdminLogout:  1,
+	}
+	stop := errors.New("stop")
+	for code, dials := range cases {
+		var events []Event
+		disconnect := fmt.Sprintf(
+			"HTTP/1.1 200 OK\r\n\r\n{\"disconnect\":{\"code\":%v,"+
+				"\"stream_name\":\"stream\",\"reason\":\"reason\"}}\r\n", code)
+		conf := &Configuration{
+			Backoff: &Backoff{NetworkStep: time.Millisecond},
+			EventHandler: EventHandlerFunc(func(event Event) {
+				events = append(events, event)
+			}),
+			Handler: HandlerFunc(func(msg []byte) error {
+				return stop
+			}),
+		}
+		dialer := &SequenceDialer{Responses: []string{
+			disconnect,
+			"HTTP/1.1 200 OK\r\n\r\n{}\r\n",
+		}}
+		conf.Dialer = dialer
+		conn := newStubConnection(conf, "")
+		err := conn.Run()
+		if dialer.Dials != dials {
+			t.Errorf("Code %v: expected %v dials, got %v", code, dials, dialer.Dials)
+		}
+		if len(events) != 1 {
+			t.Fatalf("Code %v: expected 1 event, got %v", code, events)
+		}
+		notice, ok := events[0].(*DisconnectNotice)
+		if !ok || notice.Code != code || notice.Reason != "reason" {
+			t.Errorf("Code %v: unexpected event %#v", code, events[0])
+		}
+		if code == DisconnectStall {
+			if err != stop {
+				t.Errorf("Code %v: expected handler error, got %v", code, err)
+			}
+			continue
+		}
+		disconnectErr, ok := err.(*DisconnectError)
+		if !ok || disconnectErr.Notice != notice {
+			t.Errorf("Code %v: expected DisconnectError, got %v", code, err)
+		}
+	}
+}
+
+func TestDisconnectWithOtherKey(t *testing.T) {
+	response := "HTTP/1.1 200 OK\r\n\r\n" +
+		`{"disconnect":{"code":4,"reason":"reason"},` + WARNING_JSON[1:] + "\r\n"
+	conn := newStubConnection(&Configuration{Handler: &CollectingHandler{}}, response)
+	err := conn.Read()
+	if err == nil || err == io.EOF {
+		t.Fatalf("Expected malformed disconnect error, got %v", err)
+	}
+	if class := Classify(err); class != ClassProtocol {
+		t.Errorf("Expected ClassProtocol, got %v", class)
+	}
+}
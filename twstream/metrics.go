@@ -0,0 +1,123 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Names of the metrics reported to a Metrics implementation.
+const (
+	// Counter of messages read, not counting keepalive lines.
+	MetricMessages = "twstream_messages_total"
+	// Counter of bytes read from the network, before decompression.
+	MetricBytes = "twstream_bytes_total"
+	// Counter of messages which could not be decoded.
+	MetricDecodeErrors = "twstream_decode_errors_total"
+	// Counter of reconnection attempts made by Run.
+	MetricReconnects = "twstream_reconnects_total"
+	// Gauge of the delay before the next reconnection attempt.
+	MetricBackoffSeconds = "twstream_backoff_seconds"
+	// Gauge which is 1 while a stream is connected and 0 otherwise.
+	MetricConnected = "twstream_connected"
+	// Histogram of the time taken to deliver each message to the
+	// configured handlers and sink.
+	MetricDeliverySeconds = "twstream_delivery_seconds"
+	// Histogram of the time between Twitter timestamping each Tweet and its
+	// delivery, when Tweets are decoded for a TweetHandler.  Rising latency
+	// means the consumer, or Twitter, is falling behind.
+	MetricLatencySeconds = "twstream_latency_seconds"
+)
+
+// Receives measurements from a Connection.  Adapters for monitoring systems
+// such as Prometheus implement this interface, so this package does not
+// depend on any of them.  Methods may be called concurrently and should not
+// block.
+type Metrics interface {
+	// Adds delta to the named counter.
+	Counter(name string, delta float64)
+	// Sets the named gauge to value.
+	Gauge(name string, value float64)
+	// Records value as an observation of the named histogram.
+	Observe(name string, value float64)
+}
+
+func (c *Connection) countBytes(n int) {
+	atomic.AddUint64(&c.counters.bytes, uint64(n))
+	atomic.StoreInt64(&c.counters.lastRead, c.now().UnixNano())
+	if c.conf.Metrics != nil {
+		c.conf.Metrics.Counter(MetricBytes, float64(n))
+	}
+}
+
+// Counts a message, returning its sequence number and receive time.
+func (c *Connection) countMessage() (uint64, time.Time) {
+	seq := atomic.AddUint64(&c.counters.messages, 1)
+	now := c.now()
+	atomic.StoreInt64(&c.counters.lastMessage, now.UnixNano())
+	if c.conf.Metrics != nil {
+		c.conf.Metrics.Counter(MetricMessages, 1)
+	}
+	return seq, now
+}
+
+func (c *Connection) countDecodeError() {
+	atomic.AddUint64(&c.counters.decodeErrors, 1)
+	if c.conf.Metrics != nil {
+		c.conf.Metrics.Counter(MetricDecodeErrors, 1)
+	}
+}
+
+func (c *Connection) countReconnect(delay time.Duration) {
+	atomic.AddUint64(&c.counters.reconnects, 1)
+	atomic.StoreInt64(&c.counters.backoff, int64(delay))
+	if c.conf.Metrics != nil {
+		c.conf.Metrics.Counter(MetricReconnects, 1)
+		c.conf.Metrics.Gauge(MetricBackoffSeconds, delay.Seconds())
+	}
+}
+
+// Records whether the stream is connected.  Connecting also clears the
+// current backoff.
+func (c *Connection) setConnected(connected bool) {
+	if connected {
+		atomic.StoreInt64(&c.counters.backoff, 0)
+	}
+	if c.conf.Metrics != nil {
+		value := 0.0
+		if connected {
+			value = 1
+			c.conf.Metrics.Gauge(MetricBackoffSeconds, 0)
+		}
+		c.conf.Metrics.Gauge(MetricConnected, value)
+	}
+}
+
+func (c *Connection) observe(name string, value float64) {
+	if c.conf.Metrics != nil {
+		c.conf.Metrics.Observe(name, value)
+	}
+}
+
+// Records the latency of tweet if it carries a timestamp.
+func (c *Connection) observeLatency(tweet *Tweet) {
+	if c.conf.Metrics == nil || tweet.TimestampMs == "" {
+		return
+	}
+	if sent, err := tweet.Timestamp(); err == nil {
+		c.conf.Metrics.Observe(MetricLatencySeconds, c.now().Sub(sent).Seconds())
+	}
+}
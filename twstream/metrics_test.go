@@ -0,0 +1,127 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"io"
+	"math"
+	"sync"
+	"testing"
+	"time"
+)
+
+type RecordingMetrics struct {
+	lock         sync.Mutex
+	Counters     map[string]float64
+	Gauges       map[string][]float64
+	Observations map[string][]float64
+}
+
+func NewRecordingMetrics() *RecordingMetrics {
+	return &RecordingMetrics{
+		Counters:     map[string]float64{},
+		Gauges:       map[string][]float64{},
+		Observations: map[string][]float64{},
+	}
+}
+
+func (m *RecordingMetrics) Counter(name string, delta float64) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.Counters[name] += delta
+}
+
+func (m *RecordingMetrics) Gauge(name string, value float64) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.Gauges[name] = append(m.Gauges[name], value)
+}
+
+func (m *RecordingMetrics) Observe(name string, value float64) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.Observations[name] = append(m.Observations[name], value)
+}
+
+func TestMetrics(t *testing.T) {
+	metrics := NewRecordingMetrics()
+	conf := &Configuration{
+		Handler: &CollectingHandler{},
+		Metrics: metrics,
+	}
+	response := "HTTP/1.1 200 OK\r\n\r\n{\"a\": 1}\r\n\r\n{\"b\": 2}\r\n"
+	conn := newStubConnection(conf, response)
+	if err := conn.Read(); err != io.EOF {
+		t.Fatalf("Expected io.EOF, got %v", err)
+	}
+	if count := metrics.Counters[MetricMessages]; count != 2 {
+		t.Errorf("Expected 2 messages, got %v", count)
+	}
+	if count := metrics.Counters[MetricBytes]; count != float64(len(response)) {
+		t.Errorf("Expected %v bytes, got %v", len(response), count)
+	}
+	if count := len(metrics.Observations[MetricDeliverySeconds]); count != 2 {
+		t.Errorf("Expected 2 delivery observations, got %v", count)
+	}
+	connected := metrics.Gauges[MetricConnected]
+	if len(connected) != 2 || connected[0] != 1 || connected[1] != 0 {
+		t.Errorf("Expected connected gauge to be set then cleared, got %v", connected)
+	}
+}
+
+func TestLatencyMetric(t *testing.T) {
+	metrics := NewRecordingMetrics()
+	conf := &Configuration{
+		TweetHandler: TweetHandlerFunc(func(tweet *Tweet) error {
+			return nil
+		}),
+		Metrics: metrics,
+	}
+	response := "HTTP/1.1 200 OK\r\n\r\n" +
+		"{\"id_str\": \"1\", \"timestamp_ms\": \"1378316738765\"}\r\n" +
+		"{\"id_str\": \"2\"}\r\n"
+	conn := newStubConnection(conf, response)
+	conf.Clock = clockFunc(func() time.Time {
+		return time.Unix(1378316740, 0)
+	})
+	if err := conn.Read(); err != io.EOF {
+		t.Fatalf("Expected io.EOF, got %v", err)
+	}
+	latency := metrics.Observations[MetricLatencySeconds]
+	if len(latency) != 1 || math.Abs(latency[0]-1.235) > 1e-9 {
+		t.Errorf("Expected a latency of 1.235s, got %v", latency)
+	}
+}
+
+func TestDeliveryMetric(t *testing.T) {
+	metrics := NewRecordingMetrics()
+	clock := NewFakeClock(time.Unix(1378316740, 0))
+	conf := &Configuration{
+		Handler: HandlerFunc(func(msg []byte) error {
+			clock.Advance(2 * time.Second)
+			return nil
+		}),
+		Metrics: metrics,
+		Clock:   clock,
+	}
+	conn := newStubConnection(conf, "HTTP/1.1 200 OK\r\n\r\n{\"a\": 1}\r\n")
+	if err := conn.Read(); err != io.EOF {
+		t.Fatalf("Expected io.EOF, got %v", err)
+	}
+	delivery := metrics.Observations[MetricDeliverySeconds]
+	if len(delivery) != 1 || delivery[0] != 2 {
+		t.Errorf("Expected a delivery time of 2s on the Clock, got %v", delivery)
+	}
+}
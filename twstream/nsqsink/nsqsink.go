@@ -0,0 +1,57 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package nsqsink forwards messages from a twstream Connection to an NSQ
+// topic.
+package nsqsink
+
+// Publishes messages to nsqd.  *nsq.Producer from github.com/nsqio/go-nsq
+// implements this interface.
+type Publisher interface {
+	Publish(topic string, body []byte) error
+	Stop()
+}
+
+// A twstream.QueueSink which publishes each message to Topic.  Publish
+// waits for nsqd to acknowledge each message, so Write returns only once
+// the message has been accepted, and Flush has nothing to wait for.
+type Sink struct {
+	Producer Publisher
+	Topic    string
+}
+
+// Returns a Sink publishing to topic with producer, for example:
+//
+//	producer, err := nsq.NewProducer("127.0.0.1:4150", nsq.NewConfig())
+//	...
+//	conf.Sink = nsqsink.New(producer, "tweets")
+func New(producer Publisher, topic string) *Sink {
+	return &Sink{Producer: producer, Topic: topic}
+}
+
+// Publishes msg, which is not retained once nsqd has responded, so it is
+// not copied.
+func (s *Sink) Write(msg []byte) error {
+	return s.Producer.Publish(s.Topic, msg)
+}
+
+func (s *Sink) Flush() error {
+	return nil
+}
+
+// Stops the producer.
+func (s *Sink) Close() error {
+	s.Producer.Stop()
+	return nil
+}
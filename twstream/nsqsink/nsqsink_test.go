@@ -0,0 +1,59 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package nsqsink
+
+import (
+	"errors"
+	"testing"
+)
+
+type RecordingPublisher struct {
+	Topics  []string
+	Bodies  []string
+	Err     error
+	Stopped bool
+}
+
+func (p *RecordingPublisher) Publish(topic string, body []byte) error {
+	if p.Err != nil {
+		return p.Err
+	}
+	p.Topics = append(p.Topics, topic)
+	p.Bodies = append(p.Bodies, string(body))
+	return nil
+}
+
+func (p *RecordingPublisher) Stop() {
+	p.Stopped = true
+}
+
+func TestSink(t *testing.T) {
+	producer := &RecordingPublisher{}
+	sink := New(producer, "tweets")
+	if err := sink.Write([]byte(`{"a": 1}`)); err != nil {
+		t.Fatal(err)
+	}
+	if len(producer.Bodies) != 1 || producer.Topics[0] != "tweets" || producer.Bodies[0] != `{"a": 1}` {
+		t.Errorf("Unexpected publishes %v %q", producer.Topics, producer.Bodies)
+	}
+	producer.Err = errors.New("E_PUB_FAILED")
+	if err := sink.Write([]byte(`{"b": 2}`)); err != producer.Err {
+		t.Errorf("Expected publish error, got %v", err)
+	}
+	sink.Close()
+	if !producer.Stopped {
+		t.Errorf("Producer was not stopped")
+	}
+}
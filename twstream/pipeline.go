@@ -0,0 +1,130 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"io"
+	"sync"
+)
+
+// The size of the chunks passed between pipeline stages.
+const pipelineChunkSize = 32 << 10
+
+var chunkPool = sync.Pool{
+	New: func() interface{} {
+		chunk := make([]byte, pipelineChunkSize)
+		return &chunk
+	},
+}
+
+// Runs stages of the read path in their own goroutines, connected by
+// bounded queues of chunks, so that a slow stage does not stop earlier ones
+// from making progress.  Closing the pipeline stops every stage.
+type pipeline struct {
+	depth int
+	stop  chan struct{}
+	once  sync.Once
+}
+
+func newPipeline(depth int) *pipeline {
+	return &pipeline{depth: depth, stop: make(chan struct{})}
+}
+
+func (p *pipeline) close() {
+	p.once.Do(func() {
+		close(p.stop)
+	})
+}
+
+// Returns a reader whose data is read ahead from r by a new goroutine,
+// which queues up to the pipeline depth chunks.  The goroutine exits once
+// r returns an error, or once the pipeline is closed and any pending read
+// of r returns.
+func (p *pipeline) reader(r io.Reader) *pipeReader {
+	pr := &pipeReader{
+		chunks: make(chan pipeChunk, p.depth),
+		stop:   p.stop,
+		done:   make(chan struct{}),
+	}
+	go pr.fill(r)
+	return pr
+}
+
+type pipeChunk struct {
+	buffer *[]byte
+	n      int
+	err    error
+}
+
+type pipeReader struct {
+	chunks  chan pipeChunk
+	stop    chan struct{}
+	done    chan struct{}
+	current *[]byte
+	data    []byte
+	err     error
+}
+
+func (r *pipeReader) fill(source io.Reader) {
+	defer close(r.done)
+	for {
+		buffer := chunkPool.Get().(*[]byte)
+		n, err := source.Read(*buffer)
+		select {
+		case r.chunks <- pipeChunk{buffer: buffer, n: n, err: err}:
+		case <-r.stop:
+			chunkPool.Put(buffer)
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (r *pipeReader) Read(p []byte) (int, error) {
+	for len(r.data) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.release()
+		select {
+		case chunk := <-r.chunks:
+			r.current = chunk.buffer
+			r.data = (*chunk.buffer)[:chunk.n]
+			r.err = chunk.err
+		case <-r.stop:
+			return 0, io.ErrClosedPipe
+		}
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+// Returns the current chunk to the pool.
+func (r *pipeReader) release() {
+	if r.current != nil {
+		chunkPool.Put(r.current)
+		r.current = nil
+	}
+}
+
+// Waits for the goroutine filling r to exit, which requires the pipeline to
+// have been closed or the source to have failed.
+func (r *pipeReader) wait() {
+	<-r.done
+	r.release()
+}
@@ -0,0 +1,133 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPipelineChunkedGZip(t *testing.T) {
+	var expected []string
+	var payload bytes.Buffer
+	for i := 0; i < 200; i++ {
+		msg := fmt.Sprintf("{\"id\": %d, \"text\": \"%s\"}", i, strings.Repeat("x", 1000))
+		expected = append(expected, msg)
+		payload.WriteString(msg + "\r\n")
+	}
+	compressed := gzipPayload(payload.Bytes())
+	for _, depth := range []int{1, 4} {
+		handler := &CollectingHandler{}
+		conf := &Configuration{
+			Chunked:       true,
+			GZip:          true,
+			PipelineDepth: depth,
+			Handler:       handler,
+		}
+		response := "HTTP/1.1 200 OK\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"Content-Encoding: gzip\r\n\r\n" +
+			chunk(string(compressed), 512)
+		conn := newStubConnection(conf, response)
+		if err := conn.Read(); err != io.EOF {
+			t.Fatalf("Depth %v: expected EOF, got %v", depth, err)
+		}
+		if fmt.Sprint(handler.Messages) != fmt.Sprint(expected) {
+			t.Errorf("Depth %v: got %v messages, expected %v", depth, len(handler.Messages), len(expected))
+		}
+	}
+}
+
+func TestPipelinePlain(t *testing.T) {
+	handler := &CollectingHandler{}
+	conf := &Configuration{
+		PipelineDepth: 2,
+		Handler:       handler,
+	}
+	response := "HTTP/1.1 200 OK\r\n\r\n{\"a\": 1}\r\n\r\n{\"b\": 2}\r\n"
+	conn := newStubConnection(conf, response)
+	if err := conn.Read(); err != io.EOF {
+		t.Fatalf("Expected EOF, got %v", err)
+	}
+	expected := []string{"{\"a\": 1}", "{\"b\": 2}"}
+	if fmt.Sprint(handler.Messages) != fmt.Sprint(expected) {
+		t.Errorf("Expected %q, got %q", expected, handler.Messages)
+	}
+}
+
+func TestPipelineHandlerStops(t *testing.T) {
+	stop := errors.New("stop")
+	count := 0
+	conf := &Configuration{
+		GZip:          true,
+		PipelineDepth: 2,
+		Handler: HandlerFunc(func(msg []byte) error {
+			count++
+			return stop
+		}),
+	}
+	body := gzipPayload(benchmarkPayload(false))
+	conf.Dialer = &PipeDialer{Server: func(server net.Conn) {
+		header := "HTTP/1.1 200 OK\r\nContent-Encoding: gzip\r\n\r\n"
+		if err := respond(server, header+string(body)); err != nil {
+			return
+		}
+		// The connection stays open, so the pipeline must be stopped
+		// rather than reaching the end of the body.
+		io.Copy(io.Discard, server)
+	}}
+	conn := newStubConnection(conf, "")
+	if err := conn.Read(); err != stop {
+		t.Fatalf("Expected handler error, got %v", err)
+	}
+	if count != 1 {
+		t.Errorf("Expected 1 message, got %v", count)
+	}
+}
+
+func TestPipelineClose(t *testing.T) {
+	source, writer := io.Pipe()
+	defer writer.Close()
+	p := newPipeline(1)
+	reader := p.reader(source)
+	go io.WriteString(writer, "data")
+	buffer := make([]byte, 16)
+	if n, err := reader.Read(buffer); err != nil || string(buffer[:n]) != "data" {
+		t.Fatalf("Expected data, got %q, %v", buffer[:n], err)
+	}
+	read := make(chan error)
+	go func() {
+		_, err := reader.Read(buffer)
+		read <- err
+	}()
+	p.close()
+	select {
+	case err := <-read:
+		if err != io.ErrClosedPipe {
+			t.Errorf("Expected ErrClosedPipe, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Read did not return after the pipeline was closed")
+	}
+	// The filling goroutine exits once its pending read returns.
+	writer.Close()
+	reader.wait()
+}
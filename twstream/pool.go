@@ -0,0 +1,78 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"bufio"
+	"io"
+	"sync"
+)
+
+// The size of pooled read buffers.  Messages are read in place from the
+// buffer when they fit, and full-fidelity firehose Tweets are commonly
+// larger than bufio's default of 4KB, so a larger buffer avoids copying
+// most messages.
+const readBufferSize = 64 << 10
+
+// Buffered readers are reused across connections and decompressors, so
+// reconnecting does not allocate a fresh read buffer each time.
+var readerPool = sync.Pool{
+	New: func() interface{} {
+		return bufio.NewReaderSize(nil, readBufferSize)
+	},
+}
+
+// Returns a pooled buffered reader reading from r.  The reader must be
+// returned with putReader once nothing refers to its buffer.
+func getReader(r io.Reader) *bufio.Reader {
+	reader := readerPool.Get().(*bufio.Reader)
+	reader.Reset(r)
+	return reader
+}
+
+// Returns reader to the pool, dropping its reference to the source.
+func putReader(reader *bufio.Reader) {
+	reader.Reset(nil)
+	readerPool.Put(reader)
+}
+
+// Length prefixed messages are read into buffers from this pool.  A pointer
+// is stored so that returning a buffer does not allocate.
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		buffer := make([]byte, 0, 8192)
+		return &buffer
+	},
+}
+
+// Returns a slice of length size backed by buffer, growing it if needed.
+func grow(buffer *[]byte, size int) []byte {
+	if cap(*buffer) < size {
+		*buffer = make([]byte, size)
+	}
+	return (*buffer)[:size]
+}
+
+// Returns its buffered reader to the pool when closed.
+type pooledReadCloser struct {
+	io.ReadCloser
+	reader *bufio.Reader
+}
+
+func (p *pooledReadCloser) Close() error {
+	err := p.ReadCloser.Close()
+	putReader(p.reader)
+	return err
+}
@@ -0,0 +1,71 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"fmt"
+	"time"
+)
+
+// The ReadIdleTimeout used by NewPowerTrackStream when conf does not set
+// one.  PowerTrack sends a blank keepalive line every 10 seconds, so this
+// detects stalls sooner than the 90 seconds suggested for public streams.
+const PowerTrackReadIdleTimeout = 30 * time.Second
+
+// Returns the URL of the enterprise PowerTrack stream with the given account
+// name and stream label, such as "prod".
+func PowerTrackURL(account string, label string) string {
+	return fmt.Sprintf("https://gnip-stream.twitter.com/stream/powertrack/accounts/%v/publishers/twitter/%v.json", account, label)
+}
+
+// Returns a Connection to an enterprise PowerTrack stream, authorized with
+// HTTP basic auth.  The ReadIdleTimeout defaults to
+// PowerTrackReadIdleTimeout.  System messages are passed to the
+// EventHandler as *PowerTrackNotice events, and the rules matching each
+// Tweet are decoded into Tweet.MatchingRules.  Conf is otherwise copied as
+// by NewSampleStream, and Chunked and GZip are always set.
+func NewPowerTrackStream(account string, label string, username string, password string, conf *Configuration) *Connection {
+	conn := newEndpointStream(PowerTrackURL(account, label), "GET", nil, conf)
+	conn.conf.Username = username
+	conn.conf.Password = password
+	if conn.conf.ReadIdleTimeout == 0 {
+		conn.conf.ReadIdleTimeout = PowerTrackReadIdleTimeout
+	}
+	return conn
+}
+
+// Kinds of PowerTrack system message.
+const (
+	PowerTrackError = "error"
+	PowerTrackWarn  = "warn"
+	PowerTrackInfo  = "info"
+)
+
+// A system message sent by a PowerTrack stream, such as
+// {"error": {"message": "Forced Disconnect: ...", "sent": "..."}}.  Kind is
+// one of the PowerTrack constants.
+type PowerTrackNotice struct {
+	Kind          string `json:"-"`
+	Message       string `json:"message"`
+	Sent          string `json:"sent"`
+	ActivityCount int    `json:"activity_count"`
+}
+
+// A PowerTrack rule which matched a Tweet.
+type MatchingRule struct {
+	Tag   string `json:"tag"`
+	ID    int64  `json:"id"`
+	IDStr string `json:"id_str"`
+}
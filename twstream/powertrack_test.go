@@ -0,0 +1,90 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestPowerTrackReadIdleTimeout(t *testing.T) {
+	conn := NewPowerTrackStream("acme", "prod", "user", "pass", nil)
+	if conn.conf.ReadIdleTimeout != PowerTrackReadIdleTimeout {
+		t.Errorf("Expected default ReadIdleTimeout, got %v", conn.conf.ReadIdleTimeout)
+	}
+	conf := &Configuration{ReadIdleTimeout: time.Minute}
+	conn = NewPowerTrackStream("acme", "prod", "user", "pass", conf)
+	if conn.conf.ReadIdleTimeout != time.Minute {
+		t.Errorf("Expected configured ReadIdleTimeout, got %v", conn.conf.ReadIdleTimeout)
+	}
+}
+
+func TestPowerTrackStreamWithoutUsername(t *testing.T) {
+	conn := NewPowerTrackStream("acme", "prod", "", "", &Configuration{Handler: &CollectingHandler{}})
+	conn.conf.Dialer = &StubDialer{Response: "HTTP/1.1 200 OK\r\n\r\n"}
+	if err := conn.Run(); !errors.Is(err, ErrNoCredentials) {
+		t.Errorf("Expected ErrNoCredentials, got %v", err)
+	}
+}
+
+func TestPowerTrackStream(t *testing.T) {
+	var (
+		events []Event
+		tweets []*Tweet
+	)
+	conf := &Configuration{
+		EventHandler: EventHandlerFunc(func(event Event) {
+			events = append(events, event)
+		}),
+		TweetHandler: TweetHandlerFunc(func(tweet *Tweet) error {
+			tweets = append(tweets, tweet)
+			return nil
+		}),
+	}
+	conn := NewPowerTrackStream("acme", "prod", "user", "pass", conf)
+	body := "\r\n" +
+		"{\"id_str\": \"1\", \"matching_rules\": [{\"tag\": \"golang\", \"id\": 5, \"id_str\": \"5\"}]}\r\n" +
+		"\r\n" +
+		"{\"info\": {\"message\": \"Replay Request Completed\", \"sent\": \"2017-01-01T00:00:00+00:00\", \"activity_count\": 1}}\r\n"
+	conn.conf.Dialer = &StubDialer{Response: "HTTP/1.1 200 OK\r\n" +
+		"Transfer-Encoding: chunked\r\n\r\n" + chunk(body, 64)}
+	if err := conn.Read(); err != io.EOF {
+		t.Fatalf("Expected EOF, got %v", err)
+	}
+	req := sentRequest(t, conn)
+	if user, pass, ok := req.BasicAuth(); !ok || user != "user" || pass != "pass" {
+		t.Errorf("Expected basic auth, got %q", req.Header.Get("Authorization"))
+	}
+	if req.Host != "gnip-stream.twitter.com" ||
+		req.URL.Path != "/stream/powertrack/accounts/acme/publishers/twitter/prod.json" {
+		t.Errorf("Unexpected URL %v", req.URL)
+	}
+	if len(tweets) != 1 || len(tweets[0].MatchingRules) != 1 {
+		t.Fatalf("Expected one Tweet with matching rules, got %+v", tweets)
+	}
+	if rule := tweets[0].MatchingRules[0]; rule.Tag != "golang" || rule.IDStr != "5" {
+		t.Errorf("Unexpected matching rule %+v", rule)
+	}
+	if len(events) != 1 {
+		t.Fatalf("Expected one event, got %v", events)
+	}
+	notice, ok := events[0].(*PowerTrackNotice)
+	if !ok || notice.Kind != PowerTrackInfo || notice.ActivityCount != 1 ||
+		notice.Message != "Replay Request Completed" {
+		t.Errorf("Unexpected event %+v", events[0])
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+)
+
+// Selects the messages delivered to handlers.  Predicates are called for
+// every message, so should be cheap.  Messages are only valid for the
+// duration of the call.
+type Predicate func(msg []byte) bool
+
+// Matches Tweets by keyword, language or author.  A Tweet matches if it
+// satisfies every non-empty field, and a field is satisfied if the Tweet
+// matches any of its values.  Control messages, such as delete notices,
+// always match so that compliance handling is unaffected.
+//
+// Only the fields needed for matching are decoded, and keywords which
+// cannot appear escaped in JSON are first checked against the raw payload,
+// so most non-matching Tweets are rejected without decoding.
+type Matcher struct {
+	// Matched case-insensitively against the Tweet's full text.
+	Keywords []string
+	// Matched against the Tweet's lang field.
+	Languages []string
+	// Matched against the ID of the Tweet's author.
+	Users []int64
+}
+
+// Returns a Predicate which reports whether messages match.
+func (m *Matcher) Predicate() Predicate {
+	keywords := make([][]byte, len(m.Keywords))
+	raw := true
+	for i, keyword := range m.Keywords {
+		keywords[i] = []byte(strings.ToLower(keyword))
+		raw = raw && !needsEscape(keyword)
+	}
+	return func(msg []byte) bool {
+		return m.match(msg, keywords, raw)
+	}
+}
+
+// Reports whether keyword may be escaped in a JSON payload, for example
+// as \/ or \u00e9, in which case it cannot be found in the raw payload.
+func needsEscape(keyword string) bool {
+	for i := 0; i < len(keyword); i++ {
+		switch c := keyword[i]; {
+		case c < 0x20 || c >= 0x7f:
+			return true
+		case c == '"' || c == '\\' || c == '/' || c == '<' || c == '>' || c == '&':
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Matcher) match(msg []byte, keywords [][]byte, raw bool) bool {
+	if isControl(messageType(msg)) {
+		return true
+	}
+	if len(keywords) > 0 && raw && !containsAny(bytes.ToLower(msg), keywords) {
+		return false
+	}
+	tweet := &struct {
+		Text          string `json:"text"`
+		FullText      string `json:"full_text"`
+		ExtendedTweet *struct {
+			FullText string `json:"full_text"`
+		} `json:"extended_tweet"`
+		Lang string `json:"lang"`
+		User *struct {
+			ID int64 `json:"id"`
+		} `json:"user"`
+	}{}
+	if err := json.Unmarshal(msg, tweet); err != nil {
+		return false
+	}
+	text := tweet.Text
+	if tweet.ExtendedTweet != nil {
+		text = tweet.ExtendedTweet.FullText
+	} else if tweet.FullText != "" {
+		text = tweet.FullText
+	}
+	if len(keywords) > 0 && !containsAny([]byte(strings.ToLower(text)), keywords) {
+		return false
+	}
+	if len(m.Languages) > 0 && !containsString(m.Languages, tweet.Lang) {
+		return false
+	}
+	if len(m.Users) > 0 {
+		if tweet.User == nil || !containsID(m.Users, tweet.User.ID) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsAny(text []byte, keywords [][]byte) bool {
+	for _, keyword := range keywords {
+		if bytes.Contains(text, keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func containsID(ids []int64, id int64) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
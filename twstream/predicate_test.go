@@ -0,0 +1,72 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"io"
+	"testing"
+)
+
+func TestMatcher(t *testing.T) {
+	escaped := `{"id_str":"1","text":"café http:\/\/t.co","lang":"fr","user":{"id":1}}`
+	cases := []struct {
+		matcher  *Matcher
+		msg      string
+		expected bool
+	}{
+		{&Matcher{}, TWEET_JSON, true},
+		{&Matcher{Keywords: []string{"twittercertified"}}, TWEET_JSON, true},
+		{&Matcher{Keywords: []string{"golang", "introducing"}}, TWEET_JSON, true},
+		{&Matcher{Keywords: []string{"golang"}}, TWEET_JSON, false},
+		{&Matcher{Keywords: []string{"screen_name"}}, TWEET_JSON, false},
+		{&Matcher{Languages: []string{"en"}}, TWEET_JSON, true},
+		{&Matcher{Languages: []string{"ja"}}, TWEET_JSON, false},
+		{&Matcher{Users: []int64{6253282}}, TWEET_JSON, true},
+		{&Matcher{Users: []int64{1}}, TWEET_JSON, false},
+		{&Matcher{Keywords: []string{"introducing"}, Languages: []string{"ja"}}, TWEET_JSON, false},
+		{&Matcher{Keywords: []string{"café"}}, escaped, true},
+		{&Matcher{Keywords: []string{"http://"}}, escaped, true},
+		{&Matcher{Keywords: []string{"golang"}}, WARNING_JSON, true},
+		{&Matcher{Keywords: []string{"golang"}}, `not json`, false},
+		{&Matcher{Keywords: []string{"golang"}}, `{"id_str":"1","full_text":"Go #golang"}`, true},
+		{&Matcher{Keywords: []string{"golang"}}, `{"id_str":"1","text":"Go…","extended_tweet":{"full_text":"Go #golang"}}`, true},
+	}
+	for _, c := range cases {
+		if actual := c.matcher.Predicate()([]byte(c.msg)); actual != c.expected {
+			t.Errorf("%+v matching %q: expected %v, got %v", c.matcher, c.msg, c.expected, actual)
+		}
+	}
+}
+
+func TestPredicate(t *testing.T) {
+	handler := &CollectingHandler{}
+	recorder := NewChanSink(10)
+	conf := &Configuration{
+		Handler:   handler,
+		Recorder:  recorder,
+		Predicate: (&Matcher{Languages: []string{"en"}}).Predicate(),
+	}
+	conn := newStubConnection(conf, "HTTP/1.1 200 OK\r\n\r\n"+
+		TWEET_JSON+"\r\n{\"lang\":\"ja\"}\r\n")
+	if err := conn.Read(); err != io.EOF {
+		t.Fatalf("Expected EOF, got %v", err)
+	}
+	if len(handler.Messages) != 1 || handler.Messages[0] != TWEET_JSON {
+		t.Errorf("Unexpected messages %q", handler.Messages)
+	}
+	if len(recorder.C) != 2 {
+		t.Errorf("Expected the Recorder to receive every message, got %v", len(recorder.C))
+	}
+}
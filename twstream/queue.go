@@ -0,0 +1,39 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+// A Sink which forwards messages to a message queue, such as Kafka or NSQ,
+// with delivery acknowledgment.  Write may return before the queue has
+// acknowledged a message, for example while batching, but Flush waits until
+// every message written so far has been acknowledged or has failed.  A nil
+// error from Flush means all messages were accepted by the queue.
+//
+// A Connection flushes a QueueSink set as its Sink whenever a connection
+// ends, so messages are not left unacknowledged while reconnecting.  Write
+// and Flush errors stop the stream.  Implementations must copy messages
+// they retain after Write returns.
+type QueueSink interface {
+	Sink
+	Flush() error
+	Close() error
+}
+
+// Flushes the Sink if it is a QueueSink.
+func (c *Connection) flushQueue() error {
+	if q, ok := c.conf.Sink.(QueueSink); ok {
+		return q.Flush()
+	}
+	return nil
+}
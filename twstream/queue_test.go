@@ -0,0 +1,71 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"errors"
+	"io"
+	"testing"
+)
+
+// Buffers messages until they are flushed.
+type BufferedQueue struct {
+	Pending  []string
+	Flushed  []string
+	FlushErr error
+}
+
+func (q *BufferedQueue) Write(msg []byte) error {
+	q.Pending = append(q.Pending, string(msg))
+	return nil
+}
+
+func (q *BufferedQueue) Flush() error {
+	if q.FlushErr != nil {
+		return q.FlushErr
+	}
+	q.Flushed = append(q.Flushed, q.Pending...)
+	q.Pending = nil
+	return nil
+}
+
+func (q *BufferedQueue) Close() error {
+	return q.Flush()
+}
+
+func TestQueueSinkFlushedOnDisconnect(t *testing.T) {
+	queue := &BufferedQueue{}
+	conf := &Configuration{Sink: queue}
+	conn := newStubConnection(conf, "HTTP/1.1 200 OK\r\n\r\n{\"a\": 1}\r\n{\"b\": 2}\r\n")
+	if err := conn.Read(); err != io.EOF {
+		t.Fatalf("Expected EOF, got %v", err)
+	}
+	if len(queue.Pending) != 0 || len(queue.Flushed) != 2 {
+		t.Errorf("Expected 2 flushed messages, got %+v", queue)
+	}
+}
+
+func TestQueueSinkFlushError(t *testing.T) {
+	failed := errors.New("not acknowledged")
+	queue := &BufferedQueue{FlushErr: failed}
+	conf := &Configuration{Sink: queue}
+	conf.Dialer = &SequenceDialer{Responses: []string{
+		"HTTP/1.1 200 OK\r\n\r\n{\"a\": 1}\r\n",
+	}}
+	conn := newStubConnection(conf, "")
+	if err := conn.Run(); err != failed {
+		t.Errorf("Expected flush error to stop Run, got %v", err)
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// The layout of the receive timestamps written by a Recorder.
+const RecordTimeLayout = time.RFC3339Nano
+
+// Archives payloads, each prefixed with the time it was received, to
+// rotating files.  Every line of a recording has the form
+// "timestamp\tpayload", with the timestamp formatted using RecordTimeLayout.
+// Payloads spanning several lines, as sent with JSONFraming, are compacted
+// onto one.  Set Configuration.Recorder to record a stream regardless of how
+// its messages are handled, and use Replay to play recordings back.
+type Recorder struct {
+	*RotatingFileSink
+
+	lock   sync.Mutex
+	buffer []byte
+}
+
+// Returns a Recorder which writes to files named as described for
+// RotatingFileSink.
+func NewRecorder(dir string, template string, maxSize int64, interval time.Duration) *Recorder {
+	return &Recorder{
+		RotatingFileSink: NewRotatingFileSink(dir, template, maxSize, interval),
+	}
+}
+
+// Records msg with the current time, read from the RotatingFileSink's
+// Clock.
+func (r *Recorder) Write(msg []byte) error {
+	return r.write(r.now(), msg)
+}
+
+// Records msg with the time it was received, so that recordings can be
+// correlated with the Message.ReceivedAt seen by handlers.
+func (r *Recorder) HandleEnvelope(msg *Message) error {
+	return r.write(msg.ReceivedAt, msg.Raw)
+}
+
+func (r *Recorder) write(received time.Time, msg []byte) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.buffer = received.UTC().AppendFormat(r.buffer[:0], RecordTimeLayout)
+	r.buffer = append(r.buffer, '\t')
+	r.buffer = appendLine(r.buffer, msg)
+	return r.RotatingFileSink.Write(r.buffer)
+}
+
+// Appends msg to buffer without line breaks, so that it can be read back as
+// a single record.  Line breaks in JSON may only appear as whitespace, so
+// messages which fail to compact have them replaced with spaces.
+func appendLine(buffer []byte, msg []byte) []byte {
+	if bytes.IndexAny(msg, "\r\n") < 0 {
+		return append(buffer, msg...)
+	}
+	compacted := bytes.NewBuffer(buffer)
+	if err := json.Compact(compacted, msg); err == nil {
+		return compacted.Bytes()
+	}
+	start := len(buffer)
+	buffer = append(buffer[:start], msg...)
+	for i := start; i < len(buffer); i++ {
+		if buffer[i] == '\r' || buffer[i] == '\n' {
+			buffer[i] = ' '
+		}
+	}
+	return buffer
+}
@@ -0,0 +1,88 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"fmt"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestRecorder(t *testing.T) {
+	recorder := NewRecorder(t.TempDir(), "stream.log", 0, 0)
+	handler := &CollectingHandler{}
+	var received []time.Time
+	conf := &Configuration{
+		Handler:  handler,
+		Recorder: recorder,
+		EnvelopeHandler: EnvelopeHandlerFunc(func(msg *Message) error {
+			received = append(received, msg.ReceivedAt)
+			return nil
+		}),
+	}
+	conn := newStubConnection(conf, "HTTP/1.1 200 OK\r\n\r\n"+
+		"{\"a\": 1}\r\n\r\n"+WARNING_JSON+"\r\n")
+	now := time.Date(2012, 10, 1, 12, 0, 0, 500, time.UTC)
+	conf.Clock = clockFunc(func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	})
+	if err := conn.Read(); err != io.EOF {
+		t.Fatalf("Expected EOF, got %v", err)
+	}
+	name := recorder.Name()
+	if err := recorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(received) != 2 {
+		t.Fatalf("Expected 2 envelopes, got %v", len(received))
+	}
+	// Recordings are stamped with the receive time seen by handlers.
+	expected := received[0].Format(RecordTimeLayout) + "\t{\"a\": 1}\n" +
+		received[1].Format(RecordTimeLayout) + "\t" + WARNING_JSON + "\n"
+	if actual := readFile(t, name); actual != expected {
+		t.Errorf("Expected recording %q, got %q", expected, actual)
+	}
+	if len(handler.Messages) != 2 {
+		t.Errorf("Expected messages to be delivered, got %q", handler.Messages)
+	}
+}
+
+func TestRecorderMultilineRoundTrip(t *testing.T) {
+	recorder := NewRecorder(t.TempDir(), "stream.log", 0, 0)
+	messages := []string{
+		"{\n  \"a\": 1,\n  \"text\": \"x y\"\n}",
+		"{\"b\":\r\n 2}",
+		"not json\nat all",
+	}
+	for _, msg := range messages {
+		if err := recorder.Write([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	name := recorder.Name()
+	if err := recorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+	handler := &CollectingHandler{}
+	if err := NewReplay(&Configuration{Handler: handler}, name).Run(); err != nil {
+		t.Fatalf("Replay returned error: %v", err)
+	}
+	expected := []string{"{\"a\":1,\"text\":\"x y\"}", "{\"b\":2}", "not json at all"}
+	if fmt.Sprint(handler.Messages) != fmt.Sprint(expected) {
+		t.Errorf("Expected %q, got %q", expected, handler.Messages)
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Sent to the EventHandler, if LifecycleEvents is set, when a redirect is
+// followed.  Permanent redirects are remembered for later reconnections.
+type Redirected struct {
+	From       string
+	To         string
+	StatusCode int
+}
+
+// Reads the stream like read, following up to MaxRedirects redirects.
+func (c *Connection) follow(ctx context.Context) error {
+	c.redirect = nil
+	for redirects := 0; ; redirects++ {
+		err := c.read(ctx)
+		if c.conf.MaxRedirects <= 0 || redirects >= c.conf.MaxRedirects {
+			return err
+		}
+		var status *StatusError
+		if !errors.As(err, &status) {
+			return err
+		}
+		location, permanent, rerr := c.location(status)
+		if rerr != nil {
+			return rerr
+		}
+		if location == nil {
+			return err
+		}
+		c.event(&Redirected{From: c.target().String(), To: location.String(), StatusCode: status.StatusCode})
+		if permanent {
+			c.moved = location
+			c.redirect = nil
+		} else {
+			c.redirect = location
+		}
+	}
+}
+
+// Returns the URL a response redirects to, and whether the redirect is
+// permanent, or nil if the response is not a redirect.  Redirects from
+// https to another scheme are refused, since they would expose the
+// credentials.
+func (c *Connection) location(status *StatusError) (*url.URL, bool, error) {
+	var permanent bool
+	switch status.StatusCode {
+	case http.StatusMovedPermanently, http.StatusPermanentRedirect:
+		permanent = true
+	case http.StatusFound, http.StatusTemporaryRedirect:
+	default:
+		return nil, false, nil
+	}
+	value := status.Header.Get("Location")
+	if value == "" {
+		return nil, false, nil
+	}
+	current := c.target()
+	location, err := current.Parse(value)
+	if err != nil {
+		return nil, false, classify(ClassProtocol, fmt.Errorf("Invalid redirect location %q: %v", value, err))
+	}
+	if current.Scheme == "https" && location.Scheme != "https" {
+		return nil, false, classify(ClassProtocol, fmt.Errorf("Refusing redirect to %v", location))
+	}
+	return location, permanent, nil
+}
+
+// Returns the URL to request: the location of a redirect being followed,
+// the location the stream has permanently moved to, or the configured URL.
+func (c *Connection) target() *url.URL {
+	if c.redirect != nil {
+		return c.redirect
+	}
+	if c.moved != nil {
+		return c.moved
+	}
+	return c.conf.URL
+}
+
+// Reports whether the stream has been redirected to a host other than the
+// configured one.
+func (c *Connection) offsite() bool {
+	target := c.target()
+	if target == c.conf.URL {
+		return false
+	}
+	return !strings.EqualFold(target.Hostname(), c.conf.URL.Hostname())
+}
@@ -0,0 +1,171 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// Returns the request sent over the ith connection opened by dialer.
+func sequenceRequest(t *testing.T, dialer *SequenceDialer, i int) *http.Request {
+	req, err := http.ReadRequest(bufio.NewReader(&dialer.Conns[i].Sent))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
+
+func redirectResponse(code int, location string) string {
+	return fmt.Sprintf("HTTP/1.1 %d %v\r\nLocation: %v\r\n\r\n", code, http.StatusText(code), location)
+}
+
+func TestRedirect(t *testing.T) {
+	var events []Event
+	conf := &Configuration{
+		MaxRedirects:    2,
+		Handler:         &CollectingHandler{},
+		LifecycleEvents: true,
+		EventHandler: EventHandlerFunc(func(event Event) {
+			if _, ok := event.(*Redirected); ok {
+				events = append(events, event)
+			}
+		}),
+	}
+	dialer := &SequenceDialer{Responses: []string{
+		redirectResponse(307, "https://stream2.twitter.com:8443/1/statuses/sample.json?x=1"),
+		"HTTP/1.1 200 OK\r\n\r\n{\"a\": 1}\r\n",
+		"HTTP/1.1 200 OK\r\n\r\n",
+	}}
+	conf.Dialer = dialer
+	conn := newStubConnection(conf, "")
+	if err := conn.Read(); err != io.EOF {
+		t.Fatalf("Expected EOF, got %v", err)
+	}
+	req := sequenceRequest(t, dialer, 1)
+	if req.URL.Path != "/1/statuses/sample.json" || req.URL.Query().Get("x") != "1" {
+		t.Errorf("Unexpected redirected request %v", req.URL)
+	}
+	if auth := req.Header.Get("Authorization"); !strings.HasPrefix(auth, "OAuth ") {
+		t.Errorf("Expected redirected request to be signed, got %q", auth)
+	}
+	if len(events) != 1 || events[0].(*Redirected).To != "https://stream2.twitter.com:8443/1/statuses/sample.json?x=1" {
+		t.Errorf("Unexpected events %v", events)
+	}
+	// Temporary redirects are not used for the next connection.
+	if err := conn.Read(); err != io.EOF {
+		t.Fatalf("Expected EOF, got %v", err)
+	}
+	expected := []string{"stream.twitter.com:443", "stream2.twitter.com:8443", "stream.twitter.com:443"}
+	if fmt.Sprint(dialer.Addrs) != fmt.Sprint(expected) {
+		t.Errorf("Expected dials to %v, got %v", expected, dialer.Addrs)
+	}
+	if conf.URL.Host != "stream.twitter.com" {
+		t.Errorf("Configuration should not be modified by redirects")
+	}
+}
+
+func TestRedirectOffsiteCredentials(t *testing.T) {
+	conf := &Configuration{
+		MaxRedirects: 2,
+		BearerToken:  "token",
+		Headers:      http.Header{"X-Gateway-Token": {"secret"}},
+		Handler:      &CollectingHandler{},
+	}
+	dialer := &SequenceDialer{Responses: []string{
+		redirectResponse(307, "https://stream.twitter.com:8443/1/statuses/sample.json"),
+		redirectResponse(307, "https://example.com/1/statuses/sample.json"),
+		"HTTP/1.1 200 OK\r\n\r\n",
+	}}
+	conf.Dialer = dialer
+	conn := newStubConnection(conf, "")
+	if err := conn.Read(); err != io.EOF {
+		t.Fatalf("Expected EOF, got %v", err)
+	}
+	// A redirect to another port on the same host keeps the credentials.
+	req := sequenceRequest(t, dialer, 1)
+	if req.Header.Get("Authorization") != "Bearer token" || req.Header.Get("X-Gateway-Token") != "secret" {
+		t.Errorf("Expected credentials for the same host, got %v", req.Header)
+	}
+	req = sequenceRequest(t, dialer, 2)
+	if auth := req.Header.Get("Authorization"); auth != "" {
+		t.Errorf("Expected no Authorization for another host, got %q", auth)
+	}
+	if token := req.Header.Get("X-Gateway-Token"); token != "" {
+		t.Errorf("Expected no custom headers for another host, got %q", token)
+	}
+}
+
+func TestRedirectPermanent(t *testing.T) {
+	conf := &Configuration{MaxRedirects: 1, Handler: &CollectingHandler{}}
+	dialer := &SequenceDialer{Responses: []string{
+		redirectResponse(301, "/2/statuses/sample.json"),
+		"HTTP/1.1 200 OK\r\n\r\n",
+		"HTTP/1.1 200 OK\r\n\r\n",
+	}}
+	conf.Dialer = dialer
+	conn := newStubConnection(conf, "")
+	for i := 0; i < 2; i++ {
+		if err := conn.Read(); err != io.EOF {
+			t.Fatalf("Expected EOF, got %v", err)
+		}
+	}
+	for i := 1; i < 3; i++ {
+		if req := sequenceRequest(t, dialer, i); req.URL.Path != "/2/statuses/sample.json" {
+			t.Errorf("Request %v: expected moved path, got %v", i, req.URL.Path)
+		}
+	}
+}
+
+func TestRedirectLimit(t *testing.T) {
+	for _, limit := range []int{0, 1} {
+		conf := &Configuration{MaxRedirects: limit, Handler: &CollectingHandler{}}
+		dialer := &SequenceDialer{Responses: []string{
+			redirectResponse(302, "https://a.twitter.com/"),
+			redirectResponse(302, "https://b.twitter.com/"),
+			"HTTP/1.1 200 OK\r\n\r\n",
+		}}
+		conf.Dialer = dialer
+		conn := newStubConnection(conf, "")
+		var status *StatusError
+		if err := conn.Read(); !errors.As(err, &status) || status.StatusCode != 302 {
+			t.Errorf("Limit %v: expected StatusError 302, got %v", limit, err)
+		}
+		if dialer.Dials != limit+1 {
+			t.Errorf("Limit %v: expected %v dials, got %v", limit, limit+1, dialer.Dials)
+		}
+	}
+}
+
+func TestRedirectInsecure(t *testing.T) {
+	conf := &Configuration{MaxRedirects: 1, Handler: &CollectingHandler{}}
+	dialer := &SequenceDialer{Responses: []string{
+		redirectResponse(301, "http://stream.twitter.com/1/statuses/sample.json"),
+		"HTTP/1.1 200 OK\r\n\r\n",
+	}}
+	conf.Dialer = dialer
+	conn := newStubConnection(conf, "")
+	if err := conn.Read(); Classify(err) != ClassProtocol {
+		t.Errorf("Expected protocol error, got %v", err)
+	}
+	if dialer.Dials != 1 {
+		t.Errorf("Expected insecure redirect not to be followed, got %v dials", dialer.Dials)
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Plays back recordings written by a Recorder, passing each payload to the
+// handlers and sink of a Configuration as if it had been read from a stream.
+// Messages passed to an EnvelopeHandler carry the time they were recorded.
+type Replay struct {
+	Files []string
+
+	// If true, waits between messages for the time which separated them
+	// when they were recorded, divided by Speed.
+	RealTime bool
+
+	// Scales the playback rate when RealTime is set, so that 2 plays back
+	// twice as fast as recorded.  Zero is treated as 1.
+	Speed float64
+
+	// Times the waits made when RealTime is set.  NewReplay sets it to the
+	// Configuration's Clock, and nil uses SystemClock.
+	Clock Clock
+
+	conn *Connection
+}
+
+// Returns a Replay of the given recording files, which are played in order.
+func NewReplay(conf *Configuration, files ...string) *Replay {
+	return &Replay{
+		Files: files,
+		Clock: conf.Clock,
+		conn:  &Connection{conf: conf},
+	}
+}
+
+// Plays back all files, returning nil once they have been read, or the
+// first error returned by a handler or encountered reading a file.
+func (r *Replay) Run() error {
+	return r.RunContext(context.Background())
+}
+
+// Like Run, but stops and returns ctx.Err() if ctx is cancelled.
+func (r *Replay) RunContext(ctx context.Context) error {
+	var last time.Time
+	for _, name := range r.Files {
+		if err := r.play(ctx, name, &last); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Replay) play(ctx context.Context, name string, last *time.Time) error {
+	file, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF && len(line) == 0 {
+			return nil
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+		line = bytes.TrimRight(line, "\r\n")
+		if len(line) == 0 {
+			continue
+		}
+		received, msg, err := parseRecord(line)
+		if err != nil {
+			return fmt.Errorf("%v: %v", name, err)
+		}
+		if r.RealTime && !last.IsZero() {
+			if err = r.wait(ctx, received.Sub(*last)); err != nil {
+				return err
+			}
+		}
+		*last = received
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		r.conn.envelope.Seq++
+		r.conn.envelope.ReceivedAt = received
+		if err = r.conn.deliver(msg); err != nil {
+			if stop, ok := err.(*stopError); ok {
+				return stop.err
+			}
+			return err
+		}
+	}
+}
+
+// Waits for the recorded delay between two messages, scaled by Speed.
+func (r *Replay) wait(ctx context.Context, delay time.Duration) error {
+	if r.Speed > 0 {
+		delay = time.Duration(float64(delay) / r.Speed)
+	}
+	if delay <= 0 {
+		return nil
+	}
+	wake, timer := after(r.Clock, delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-wake:
+		return nil
+	}
+}
+
+// Splits a line written by a Recorder into its receive time and payload.
+func parseRecord(line []byte) (time.Time, []byte, error) {
+	i := bytes.IndexByte(line, '\t')
+	if i < 0 {
+		return time.Time{}, nil, fmt.Errorf("Malformed record: %q", line)
+	}
+	received, err := time.Parse(RecordTimeLayout, string(line[:i]))
+	if err != nil {
+		return time.Time{}, nil, fmt.Errorf("Malformed record timestamp: %q", line[:i])
+	}
+	return received, line[i+1:], nil
+}
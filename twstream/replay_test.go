@@ -0,0 +1,79 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeRecording(t *testing.T, lines string) string {
+	name := filepath.Join(t.TempDir(), "stream.log")
+	if err := os.WriteFile(name, []byte(lines), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestReplay(t *testing.T) {
+	first := writeRecording(t,
+		"2012-10-01T12:00:00Z\t{\"a\": 1}\n"+
+			"2012-10-01T12:00:00.05Z\t"+WARNING_JSON+"\n")
+	second := writeRecording(t, "2012-10-01T12:00:00.1Z\t{\"b\": 2}\n")
+	var events []Event
+	handler := &CollectingHandler{}
+	conf := &Configuration{
+		Handler: handler,
+		EventHandler: EventHandlerFunc(func(event Event) {
+			events = append(events, event)
+		}),
+	}
+	replay := NewReplay(conf, first, second)
+	replay.RealTime = true
+	replay.Speed = 2
+	start := time.Now()
+	if err := replay.Run(); err != nil {
+		t.Fatalf("Run returned error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("Expected recorded timing to be honored, took %v", elapsed)
+	}
+	if len(handler.Messages) != 2 || handler.Messages[1] != "{\"b\": 2}" {
+		t.Errorf("Unexpected messages %q", handler.Messages)
+	}
+	if len(events) != 1 {
+		t.Errorf("Expected 1 event, got %v", events)
+	}
+}
+
+func TestReplayErrors(t *testing.T) {
+	malformed := writeRecording(t, "{\"a\": 1}\n")
+	if err := NewReplay(&Configuration{Handler: &CollectingHandler{}}, malformed).Run(); err == nil {
+		t.Errorf("Expected error for malformed recording")
+	}
+	slow := writeRecording(t,
+		"2012-10-01T12:00:00Z\t{\"a\": 1}\n"+
+			"2012-10-01T13:00:00Z\t{\"b\": 2}\n")
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	replay := NewReplay(&Configuration{Handler: &CollectingHandler{}}, slow)
+	replay.RealTime = true
+	if err := replay.RunContext(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"io"
+)
+
+// The route of Tweets, and of any other message which is not a control
+// message, in a RouteSink.
+const RouteTweets = "tweets"
+
+// Writes each message to the sink routed for its type, so that one
+// connection can feed several differently handled destinations, for example
+// Tweets to Kafka, delete notices to a compliance file and limit notices and
+// stall warnings to a log.
+//
+// Control messages are routed by their type, the key of their single-key
+// JSON object, such as "delete", "limit", "warning" or UserSuspend.  All
+// other messages are routed as RouteTweets.  Messages without a route are
+// written to Default, or dropped if Default is nil.  Control messages reach
+// the sink whether or not an EventHandler is also set.
+type RouteSink struct {
+	Routes  map[string]Sink
+	Default Sink
+}
+
+// Returns a RouteSink with no routes, which writes every message to
+// defaultSink until routes are added.
+func NewRouteSink(defaultSink Sink) *RouteSink {
+	return &RouteSink{Routes: map[string]Sink{}, Default: defaultSink}
+}
+
+// Routes messages of each of the given types to sink, replacing any
+// existing routes for them.  Returns s so that calls may be chained.
+func (s *RouteSink) Route(sink Sink, kinds ...string) *RouteSink {
+	if s.Routes == nil {
+		s.Routes = map[string]Sink{}
+	}
+	for _, kind := range kinds {
+		s.Routes[kind] = sink
+	}
+	return s
+}
+
+// Returns the sink msg is routed to, or nil if it is dropped.
+func (s *RouteSink) Sink(msg []byte) Sink {
+	kind := RouteTweets
+	if key := messageType(msg); isControl(key) {
+		kind = key
+	}
+	if sink, ok := s.Routes[kind]; ok {
+		return sink
+	}
+	return s.Default
+}
+
+func (s *RouteSink) Write(msg []byte) error {
+	if sink := s.Sink(msg); sink != nil {
+		return sink.Write(msg)
+	}
+	return nil
+}
+
+// Closes every routed sink and the Default which implements io.Closer,
+// each once however many routes it serves, and returns the first error.
+func (s *RouteSink) Close() error {
+	var first error
+	closed := map[Sink]bool{}
+	sinks := []Sink{s.Default}
+	for _, sink := range s.Routes {
+		sinks = append(sinks, sink)
+	}
+	for _, sink := range sinks {
+		closer, ok := sink.(io.Closer)
+		if !ok || closed[sink] {
+			continue
+		}
+		closed[sink] = true
+		if err := closer.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
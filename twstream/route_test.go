@@ -0,0 +1,140 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+type closingSink struct {
+	bytes.Buffer
+	closes int
+	err    error
+}
+
+func (s *closingSink) Write(msg []byte) error {
+	s.Buffer.Write(msg)
+	s.Buffer.WriteByte('\n')
+	return s.err
+}
+
+func (s *closingSink) Close() error {
+	s.closes++
+	return nil
+}
+
+func TestRouteSink(t *testing.T) {
+	tweets := &closingSink{}
+	deletes := &closingSink{}
+	notices := &closingSink{}
+	sink := NewRouteSink(nil).
+		Route(tweets, RouteTweets).
+		Route(deletes, "delete").
+		Route(notices, "limit", "warning")
+	response := "HTTP/1.1 200 OK\r\n\r\n" +
+		TWEET_JSON + "\r\n" +
+		`{"delete":{"status":{"id":1,"user_id":2}}}` + "\r\n" +
+		`{"limit":{"track":5}}` + "\r\n" +
+		WARNING_JSON + "\r\n" +
+		`{"scrub_geo":{"user_id":3,"up_to_status_id":4}}` + "\r\n"
+	conn := newStubConnection(&Configuration{Sink: sink}, response)
+	if err := conn.Read(); err != io.EOF {
+		t.Fatalf("Expected EOF, got %v", err)
+	}
+	if tweets.String() != TWEET_JSON+"\n" {
+		t.Errorf("Unexpected Tweets %q", tweets.String())
+	}
+	if deletes.String() != `{"delete":{"status":{"id":1,"user_id":2}}}`+"\n" {
+		t.Errorf("Unexpected deletes %q", deletes.String())
+	}
+	if notices.String() != `{"limit":{"track":5}}`+"\n"+WARNING_JSON+"\n" {
+		t.Errorf("Unexpected notices %q", notices.String())
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if tweets.closes != 1 || deletes.closes != 1 || notices.closes != 1 {
+		t.Errorf("Expected each sink closed once, got %v, %v and %v", tweets.closes, deletes.closes, notices.closes)
+	}
+}
+
+func TestRouteSinkWithEventHandler(t *testing.T) {
+	var events []Event
+	deletes := &closingSink{}
+	tweets := &closingSink{}
+	sink := NewRouteSink(nil).Route(deletes, "delete").Route(tweets, RouteTweets)
+	conf := &Configuration{
+		Sink: sink,
+		EventHandler: EventHandlerFunc(func(event Event) {
+			events = append(events, event)
+		}),
+	}
+	response := "HTTP/1.1 200 OK\r\n\r\n" +
+		`{"delete":{"status":{"id":1,"user_id":2}}}` + "\r\n" +
+		TWEET_JSON + "\r\n"
+	conn := newStubConnection(conf, response)
+	if err := conn.Read(); err != io.EOF {
+		t.Fatalf("Expected EOF, got %v", err)
+	}
+	if len(events) != 1 {
+		t.Errorf("Expected 1 event, got %v", events)
+	}
+	if deletes.String() != `{"delete":{"status":{"id":1,"user_id":2}}}`+"\n" {
+		t.Errorf("Unexpected deletes %q", deletes.String())
+	}
+	if tweets.String() != TWEET_JSON+"\n" {
+		t.Errorf("Unexpected Tweets %q", tweets.String())
+	}
+}
+
+func TestRouteSinkDefault(t *testing.T) {
+	other := &closingSink{}
+	deletes := &closingSink{}
+	sink := NewRouteSink(other).Route(deletes, "delete")
+	if err := sink.Write([]byte(`{"limit":{"track":5}}`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Write([]byte(`{"a": 1}`)); err != nil {
+		t.Fatal(err)
+	}
+	if other.String() != "{\"limit\":{\"track\":5}}\n{\"a\": 1}\n" {
+		t.Errorf("Unexpected default output %q", other.String())
+	}
+	if deletes.Len() != 0 {
+		t.Errorf("Unexpected deletes %q", deletes.String())
+	}
+
+	// Without a Default, unrouted messages are dropped.
+	sink = NewRouteSink(nil).Route(deletes, "delete")
+	if err := sink.Write([]byte(`{"a": 1}`)); err != nil {
+		t.Fatal(err)
+	}
+	if deletes.Len() != 0 {
+		t.Errorf("Unexpected deletes %q", deletes.String())
+	}
+}
+
+func TestRouteSinkStopsStream(t *testing.T) {
+	stop := errors.New("stop")
+	failing := &closingSink{err: stop}
+	sink := NewRouteSink(&closingSink{}).Route(failing, RouteTweets)
+	conn := newStubConnection(&Configuration{Sink: sink}, SINK_RESPONSE)
+	if err := conn.Read(); err != stop {
+		t.Fatalf("Expected sink error, got %v", err)
+	}
+}
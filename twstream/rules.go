@@ -0,0 +1,135 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// A v2 filtered stream rule.  ID is assigned by Twitter when the rule is
+// added.
+type Rule struct {
+	ID    string `json:"id,omitempty"`
+	Value string `json:"value"`
+	Tag   string `json:"tag,omitempty"`
+}
+
+// Lists, adds and deletes the rules of the v2 filtered stream.
+type RulesClient struct {
+	URL         *url.URL
+	BearerToken string
+
+	// Used to send requests.  Nil uses http.DefaultClient.
+	Client *http.Client
+
+	// Used to interpret Retry-After dates.  Defaults to SystemClock.
+	Clock Clock
+}
+
+// Returns a RulesClient for V2RulesURL which authorizes requests with
+// bearerToken.
+func NewRulesClient(bearerToken string) *RulesClient {
+	rules, _ := url.Parse(V2RulesURL)
+	return &RulesClient{URL: rules, BearerToken: bearerToken}
+}
+
+// Returns the rules with the given IDs, or all rules if none are given.
+func (r *RulesClient) List(ids ...string) ([]Rule, error) {
+	query := url.Values{}
+	if len(ids) > 0 {
+		query.Set("ids", strings.Join(ids, ","))
+	}
+	return r.do("GET", query, nil)
+}
+
+// Adds rules to the stream, returning the created rules with their IDs.  If
+// dryRun is true, the rules are validated but not added.  If some rules
+// could not be created, the error is a V2Errors describing them.
+func (r *RulesClient) Add(rules []Rule, dryRun bool) ([]Rule, error) {
+	body := struct {
+		Add []Rule `json:"add"`
+	}{rules}
+	return r.do("POST", dryRunQuery(dryRun), body)
+}
+
+// Deletes the rules with the given IDs.  If dryRun is true, the request is
+// validated but no rules are deleted.
+func (r *RulesClient) Delete(ids []string, dryRun bool) error {
+	body := struct {
+		Delete struct {
+			IDs []string `json:"ids"`
+		} `json:"delete"`
+	}{}
+	body.Delete.IDs = ids
+	_, err := r.do("POST", dryRunQuery(dryRun), body)
+	return err
+}
+
+func dryRunQuery(dryRun bool) url.Values {
+	query := url.Values{}
+	if dryRun {
+		query.Set("dry_run", "true")
+	}
+	return query
+}
+
+func (r *RulesClient) do(method string, query url.Values, body interface{}) ([]Rule, error) {
+	reqUrl := *r.URL
+	if len(query) > 0 {
+		reqUrl.RawQuery = query.Encode()
+	}
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return nil, err
+		}
+	}
+	req, err := http.NewRequest(method, reqUrl.String(), bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Authorization", "Bearer "+r.BearerToken)
+	client := r.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 && resp.StatusCode != 201 {
+		return nil, checkStatus(resp.StatusCode, resp.Status, resp.Header, clockOr(r.Clock).Now())
+	}
+	response := &struct {
+		Data   []Rule   `json:"data"`
+		Errors V2Errors `json:"errors"`
+	}{}
+	if err = json.NewDecoder(resp.Body).Decode(response); err != nil {
+		return nil, err
+	}
+	if len(response.Errors) > 0 {
+		return response.Data, response.Errors
+	}
+	return response.Data, nil
+}
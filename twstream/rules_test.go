@@ -0,0 +1,86 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestRulesClient(t *testing.T) {
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer bearer" {
+			w.WriteHeader(401)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, r.Method+" "+r.URL.RawQuery+" "+string(body))
+		switch {
+		case r.Method == "GET":
+			io.WriteString(w, `{"data":[{"id":"1","value":"cat has:images","tag":"cats"}]}`)
+		case r.URL.Query().Get("dry_run") == "true":
+			w.WriteHeader(201)
+			io.WriteString(w, `{"data":[{"id":"2","value":"dog","tag":"dogs"}],`+
+				`"errors":[{"title":"DuplicateRule","value":"cat has:images"}]}`)
+		default:
+			io.WriteString(w, `{"meta":{"summary":{"deleted":1}}}`)
+		}
+	}))
+	defer server.Close()
+	client := NewRulesClient("bearer")
+	client.URL, _ = url.Parse(server.URL + "/2/tweets/search/stream/rules")
+
+	rules, err := client.List("1")
+	if err != nil {
+		t.Fatalf("List returned error: %v", err)
+	}
+	if len(rules) != 1 || rules[0].Tag != "cats" {
+		t.Errorf("Unexpected rules %+v", rules)
+	}
+	rules, err = client.Add([]Rule{{Value: "dog", Tag: "dogs"}, {Value: "cat has:images"}}, true)
+	if len(rules) != 1 || rules[0].ID != "2" {
+		t.Errorf("Unexpected rules %+v", rules)
+	}
+	if errs, ok := err.(V2Errors); !ok || len(errs) != 1 || errs[0].Title != "DuplicateRule" {
+		t.Errorf("Expected V2Errors, got %v", err)
+	}
+	if err = client.Delete([]string{"1"}, false); err != nil {
+		t.Errorf("Delete returned error: %v", err)
+	}
+	expected := []string{
+		`GET ids=1 `,
+		`POST dry_run=true {"add":[{"value":"dog","tag":"dogs"},{"value":"cat has:images"}]}`,
+		`POST  {"delete":{"ids":["1"]}}`,
+	}
+	if len(bodies) != len(expected) {
+		t.Fatalf("Expected %v requests, got %q", len(expected), bodies)
+	}
+	for i := range expected {
+		if bodies[i] != expected[i] {
+			t.Errorf("Expected request %q, got %q", expected[i], bodies[i])
+		}
+	}
+
+	client.BearerToken = "invalid"
+	if _, err = client.List(); err == nil {
+		t.Errorf("Expected error for unauthorized request")
+	} else if status, ok := err.(*StatusError); !ok || status.StatusCode != 401 {
+		t.Errorf("Expected StatusError, got %v", err)
+	}
+}
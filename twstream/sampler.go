@@ -0,0 +1,99 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Thins out the messages delivered to handlers and sinks, for dashboards or
+// debugging sessions which do not need the full volume.  One in every Every
+// messages is kept, and of those at most Rate per second are delivered.
+// Control messages, such as delete notices, are always delivered so that
+// compliance handling is unaffected, and the Recorder still receives every
+// message.  Set Configuration.Sampler to sample a stream.
+type Sampler struct {
+	// Keeps one in every Every messages.  Zero or one keeps every message.
+	Every int
+	// Delivers at most this many messages per second, allowing bursts of
+	// up to one second's worth.  Zero means no limit.
+	Rate float64
+	// Times the Rate.  Defaults to SystemClock.
+	Clock Clock
+
+	lock    sync.Mutex
+	count   int
+	tokens  float64
+	last    time.Time
+	skipped uint64
+}
+
+// Returns a Sampler keeping one in every messages, at no more than rate
+// messages per second.
+func NewSampler(every int, rate float64) *Sampler {
+	return &Sampler{Every: every, Rate: rate}
+}
+
+// Reports whether the next message should be delivered, counting it as
+// skipped if not.
+func (s *Sampler) Sample() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.Every > 1 {
+		s.count++
+		if s.count < s.Every {
+			atomic.AddUint64(&s.skipped, 1)
+			return false
+		}
+		s.count = 0
+	}
+	if s.Rate > 0 {
+		now := clockOr(s.Clock).Now()
+		burst := s.Rate
+		if burst < 1 {
+			burst = 1
+		}
+		if s.last.IsZero() {
+			s.tokens = burst
+		} else {
+			s.tokens += now.Sub(s.last).Seconds() * s.Rate
+			if s.tokens > burst {
+				s.tokens = burst
+			}
+		}
+		s.last = now
+		if s.tokens < 1 {
+			atomic.AddUint64(&s.skipped, 1)
+			return false
+		}
+		s.tokens--
+	}
+	return true
+}
+
+// Returns the number of messages skipped.
+func (s *Sampler) Skipped() uint64 {
+	return atomic.LoadUint64(&s.skipped)
+}
+
+// Reports whether msg should be delivered.  Control messages always are.
+func (s *Sampler) sample(msg []byte) bool {
+	if isControl(messageType(msg)) {
+		return true
+	}
+	return s.Sample()
+}
@@ -0,0 +1,81 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+func TestSamplerEvery(t *testing.T) {
+	sampler := NewSampler(3, 0)
+	var kept []int
+	for i := 1; i <= 9; i++ {
+		if sampler.Sample() {
+			kept = append(kept, i)
+		}
+	}
+	if len(kept) != 3 || kept[0] != 3 || kept[1] != 6 || kept[2] != 9 {
+		t.Errorf("Expected every third message, got %v", kept)
+	}
+	if sampler.Skipped() != 6 {
+		t.Errorf("Expected 6 skipped, got %v", sampler.Skipped())
+	}
+}
+
+func TestSamplerRate(t *testing.T) {
+	sampler := NewSampler(0, 2)
+	clock := NewFakeClock(time.Date(2012, 10, 1, 12, 0, 0, 0, time.UTC))
+	sampler.Clock = clock
+	kept := 0
+	for i := 0; i < 5; i++ {
+		if sampler.Sample() {
+			kept++
+		}
+	}
+	if kept != 2 {
+		t.Errorf("Expected a burst of 2, got %v", kept)
+	}
+	clock.Advance(500 * time.Millisecond)
+	if !sampler.Sample() {
+		t.Errorf("Expected a message after half a second")
+	}
+	if sampler.Sample() {
+		t.Errorf("Expected the rate to be enforced")
+	}
+	if sampler.Skipped() != 4 {
+		t.Errorf("Expected 4 skipped, got %v", sampler.Skipped())
+	}
+}
+
+func TestSamplerConfiguration(t *testing.T) {
+	handler := &CollectingHandler{}
+	conf := &Configuration{
+		Sampler: NewSampler(2, 0),
+		Handler: handler,
+	}
+	response := "HTTP/1.1 200 OK\r\n\r\n" +
+		"{\"a\": 1}\r\n{\"b\": 2}\r\n" + WARNING_JSON + "\r\n{\"c\": 3}\r\n{\"d\": 4}\r\n"
+	conn := newStubConnection(conf, response)
+	if err := conn.Read(); err != io.EOF {
+		t.Fatalf("Expected EOF, got %v", err)
+	}
+	expected := []string{"{\"b\": 2}", WARNING_JSON, "{\"d\": 4}"}
+	if len(handler.Messages) != 3 || handler.Messages[0] != expected[0] ||
+		handler.Messages[1] != expected[1] || handler.Messages[2] != expected[2] {
+		t.Errorf("Expected %q, got %q", expected, handler.Messages)
+	}
+}
@@ -0,0 +1,238 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"sync/atomic"
+)
+
+// Receives messages read from a stream.  Messages passed to Write are only
+// valid for the duration of the call, so sinks which retain them must make a
+// copy.  Returning a non-nil error stops the stream.
+type Sink interface {
+	Write(msg []byte) error
+}
+
+// Adapts an ordinary function to the Sink interface.
+type SinkFunc func(msg []byte) error
+
+func (f SinkFunc) Write(msg []byte) error {
+	return f(msg)
+}
+
+// Writes each message to an io.Writer, followed by a newline.  If Encoding
+// is set, messages are instead written by an Encoder it creates, and Close
+// must be called to complete the output.
+type WriterSink struct {
+	Writer   io.Writer
+	Encoding Encoding
+	buffer   []byte
+	encoder  Encoder
+}
+
+func NewWriterSink(w io.Writer) *WriterSink {
+	return &WriterSink{Writer: w}
+}
+
+func (s *WriterSink) Write(msg []byte) error {
+	if s.Encoding != nil {
+		if s.encoder == nil {
+			s.encoder = s.Encoding(s.Writer)
+		}
+		return s.encoder.Encode(msg)
+	}
+	s.buffer = append(append(s.buffer[:0], msg...), '\n')
+	_, err := s.Writer.Write(s.buffer)
+	return err
+}
+
+// Closes the Encoder, if one was created.  The Writer is not closed.
+func (s *WriterSink) Close() error {
+	if s.encoder == nil {
+		return nil
+	}
+	err := s.encoder.Close()
+	s.encoder = nil
+	return err
+}
+
+// Determines what happens when a message is sent to a full channel.
+type OverflowPolicy int
+
+const (
+	// Wait until the consumer receives a message.
+	Block OverflowPolicy = iota
+	// Discard the oldest buffered message to make room for the new one.
+	DropOldest
+	// Discard the new message.
+	DropNewest
+)
+
+// Sends msg on c according to policy, returning the number of messages
+// dropped.  Blocking sends are abandoned if done is closed.  An unbuffered
+// channel holds no message to discard, so DropOldest then drops msg unless
+// a receiver is waiting.
+func send(c chan []byte, msg []byte, policy OverflowPolicy, done <-chan bool) uint64 {
+	if policy == DropOldest && cap(c) == 0 {
+		policy = DropNewest
+	}
+	switch policy {
+	case DropNewest:
+		select {
+		case c <- msg:
+			return 0
+		default:
+			return 1
+		}
+	case DropOldest:
+		var dropped uint64
+		for {
+			select {
+			case c <- msg:
+				return dropped
+			default:
+			}
+			select {
+			case <-c:
+				dropped++
+			default:
+			}
+		}
+	}
+	select {
+	case c <- msg:
+	case <-done:
+	}
+	return 0
+}
+
+// Sends a copy of each message on C.  When C is full, Policy determines
+// whether Write blocks or a message is dropped.
+type ChanSink struct {
+	C       chan []byte
+	Policy  OverflowPolicy
+	dropped uint64
+}
+
+// Returns a blocking ChanSink whose channel has the given buffer size.
+func NewChanSink(size int) *ChanSink {
+	return &ChanSink{C: make(chan []byte, size)}
+}
+
+func (s *ChanSink) Write(msg []byte) error {
+	dropped := send(s.C, append([]byte(nil), msg...), s.Policy, nil)
+	if dropped > 0 {
+		atomic.AddUint64(&s.dropped, dropped)
+	}
+	return nil
+}
+
+// Returns the number of messages dropped because C was full.
+func (s *ChanSink) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Closes C.  Call once the stream has stopped to signal receivers.
+func (s *ChanSink) Close() error {
+	close(s.C)
+	return nil
+}
+
+// Shards messages across several channels by a hash of the ID of the user
+// they concern, so that each of several workers receives all of a user's
+// messages in the order they were read.  Tweets are keyed by their author
+// and delete notices by the author of the deleted Tweet, so a deletion is
+// never handled before the Tweet it deletes.  Other messages are sent on
+// C[0].  When a channel is full, Policy determines whether Write blocks or
+// a message is dropped.
+type PartitionSink struct {
+	C       []chan []byte
+	Policy  OverflowPolicy
+	dropped uint64
+}
+
+// Returns a blocking PartitionSink with the given number of channels, each
+// with the given buffer size.  Panics if partitions is less than 1.
+func NewPartitionSink(partitions int, size int) *PartitionSink {
+	if partitions < 1 {
+		panic(fmt.Sprintf("Invalid partition count: %v", partitions))
+	}
+	s := &PartitionSink{C: make([]chan []byte, partitions)}
+	for i := range s.C {
+		s.C[i] = make(chan []byte, size)
+	}
+	return s
+}
+
+func (s *PartitionSink) Write(msg []byte) error {
+	c := s.C[s.Partition(messageUserID(msg))]
+	dropped := send(c, append([]byte(nil), msg...), s.Policy, nil)
+	if dropped > 0 {
+		atomic.AddUint64(&s.dropped, dropped)
+	}
+	return nil
+}
+
+// Returns the index of the channel messages concerning the given user ID
+// are sent on.  An empty ID maps to 0.
+func (s *PartitionSink) Partition(userID string) int {
+	if userID == "" {
+		return 0
+	}
+	hash := fnv.New32a()
+	hash.Write([]byte(userID))
+	return int(hash.Sum32() % uint32(len(s.C)))
+}
+
+// Returns the number of messages dropped because a channel was full.
+func (s *PartitionSink) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Closes every channel.  Call once the stream has stopped to signal
+// receivers.
+func (s *PartitionSink) Close() error {
+	for _, c := range s.C {
+		close(c)
+	}
+	return nil
+}
+
+// Returns the ID of the author of a Tweet or of the Tweet deleted by a
+// delete notice, or "" for other messages.
+func messageUserID(msg []byte) string {
+	envelope := &struct {
+		User *struct {
+			IDStr string `json:"id_str"`
+		} `json:"user"`
+		Delete *struct {
+			Status *DeleteNotice `json:"status"`
+		} `json:"delete"`
+	}{}
+	if err := json.Unmarshal(msg, envelope); err != nil {
+		return ""
+	}
+	switch {
+	case envelope.User != nil:
+		return envelope.User.IDStr
+	case envelope.Delete != nil && envelope.Delete.Status != nil:
+		return envelope.Delete.Status.UserIDStr
+	}
+	return ""
+}
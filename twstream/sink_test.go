@@ -0,0 +1,173 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const SINK_RESPONSE = "HTTP/1.1 200 OK\r\n\r\n" +
+	"{\"a\": 1}\r\n" +
+	"\r\n" +
+	"{\"b\": 2}\r\n"
+
+func TestWriterSink(t *testing.T) {
+	var out bytes.Buffer
+	conf := &Configuration{Sink: NewWriterSink(&out)}
+	conn := newStubConnection(conf, SINK_RESPONSE)
+	if err := conn.Read(); err != io.EOF {
+		t.Fatalf("Expected EOF, got %v", err)
+	}
+	if out.String() != "{\"a\": 1}\n{\"b\": 2}\n" {
+		t.Errorf("Unexpected output %q", out.String())
+	}
+}
+
+func TestChanSink(t *testing.T) {
+	sink := NewChanSink(10)
+	conf := &Configuration{Sink: sink}
+	conn := newStubConnection(conf, SINK_RESPONSE)
+	if err := conn.Read(); err != io.EOF {
+		t.Fatalf("Expected EOF, got %v", err)
+	}
+	sink.Close()
+	var messages []string
+	for msg := range sink.C {
+		messages = append(messages, string(msg))
+	}
+	if len(messages) != 2 || messages[0] != "{\"a\": 1}" || messages[1] != "{\"b\": 2}" {
+		t.Errorf("Unexpected messages %q", messages)
+	}
+}
+
+func TestSinkFuncStopsStream(t *testing.T) {
+	full := errors.New("disk full")
+	conf := &Configuration{Sink: SinkFunc(func(msg []byte) error {
+		return full
+	})}
+	conn := newStubConnection(conf, SINK_RESPONSE)
+	if err := conn.Read(); err != full {
+		t.Fatalf("Expected sink error, got %v", err)
+	}
+}
+
+func TestChanSinkPolicies(t *testing.T) {
+	cases := []struct {
+		policy   OverflowPolicy
+		expected []string
+	}{
+		{DropNewest, []string{"1", "2"}},
+		{DropOldest, []string{"4", "5"}},
+	}
+	for _, c := range cases {
+		sink := NewChanSink(2)
+		sink.Policy = c.policy
+		for _, msg := range []string{"1", "2", "3", "4", "5"} {
+			sink.Write([]byte(msg))
+		}
+		sink.Close()
+		var received []string
+		for msg := range sink.C {
+			received = append(received, string(msg))
+		}
+		if len(received) != 2 || received[0] != c.expected[0] || received[1] != c.expected[1] {
+			t.Errorf("Policy %v: expected %q, got %q", c.policy, c.expected, received)
+		}
+		if sink.Dropped() != 3 {
+			t.Errorf("Policy %v: expected 3 dropped, got %v", c.policy, sink.Dropped())
+		}
+	}
+}
+
+func TestChanSinkDropOldestUnbuffered(t *testing.T) {
+	sink := NewChanSink(0)
+	sink.Policy = DropOldest
+	written := make(chan bool)
+	go func() {
+		sink.Write([]byte("1"))
+		written <- true
+	}()
+	select {
+	case <-written:
+	case <-time.After(time.Second):
+		t.Fatal("Write did not return without a receiver")
+	}
+	if sink.Dropped() != 1 {
+		t.Errorf("Expected 1 dropped, got %v", sink.Dropped())
+	}
+}
+
+func TestPartitionSink(t *testing.T) {
+	sink := NewPartitionSink(4, 10)
+	messages := []string{
+		`{"id_str": "1", "user": {"id_str": "100"}}`,
+		`{"id_str": "2", "user": {"id_str": "200"}}`,
+		`{"id_str": "3", "user": {"id_str": "100"}}`,
+		`{"delete": {"status": {"id_str": "1", "user_id_str": "100"}}}`,
+		`{"limit": {"track": 5}}`,
+	}
+	users := []string{"100", "200", "100", "100", ""}
+	for _, msg := range messages {
+		sink.Write([]byte(msg))
+	}
+	sink.Close()
+	received := map[int][]string{}
+	for i, c := range sink.C {
+		for msg := range c {
+			received[i] = append(received[i], string(msg))
+		}
+	}
+	expected := map[int][]string{}
+	for i, msg := range messages {
+		partition := sink.Partition(users[i])
+		expected[partition] = append(expected[partition], msg)
+	}
+	if fmt.Sprint(received) != fmt.Sprint(expected) {
+		t.Errorf("Expected %q, got %q", expected, received)
+	}
+	if sink.Partition("") != 0 {
+		t.Errorf("Expected messages without a user on partition 0")
+	}
+}
+
+func TestPartitionSinkWithoutPartitions(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expected NewPartitionSink(0, 10) to panic")
+		}
+	}()
+	NewPartitionSink(0, 10)
+}
+
+func TestPartitionSinkSpread(t *testing.T) {
+	sink := NewPartitionSink(8, 0)
+	used := map[int]bool{}
+	for id := 1; id <= 1000; id++ {
+		partition := sink.Partition(strconv.Itoa(id))
+		if partition < 0 || partition >= len(sink.C) {
+			t.Fatalf("Partition %v out of range", partition)
+		}
+		used[partition] = true
+	}
+	if len(used) != len(sink.C) {
+		t.Errorf("Expected all %v partitions used, got %v", len(sink.C), len(used))
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/kurrik/golibs/twurlrc"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// The maximum number of users which may be added or removed by a single
+// control stream request.
+const MaxControlUsers = 100
+
+// Sent at the start of a site stream.  ControlURI is the path of the
+// stream's control endpoint, which is used to add and remove users.
+type ControlNotice struct {
+	ControlURI string `json:"control_uri"`
+}
+
+// Adds and removes users from a running site stream.
+type ControlStream struct {
+	// The control stream URL, such as
+	// https://sitestream.twitter.com/1.1/site/c/1_1_54e345d655ee3e8df359.
+	URL *url.URL
+
+	// Used to send requests.  Nil uses http.DefaultClient.
+	Client *http.Client
+
+	// Used to interpret Retry-After dates.  Defaults to SystemClock.
+	Clock Clock
+
+	cred *twurlrc.Credentials
+}
+
+// Returns a ControlStream for the site stream at stream, using the control
+// URI sent in notice.  Requests are signed with cred, which must be the
+// credentials used to open the site stream.
+func NewControlStream(stream *url.URL, notice *ControlNotice, cred *twurlrc.Credentials) (*ControlStream, error) {
+	control, err := url.Parse(notice.ControlURI)
+	if err != nil {
+		return nil, err
+	}
+	return &ControlStream{
+		URL:  stream.ResolveReference(control),
+		cred: cred,
+	}, nil
+}
+
+// Adds users to the site stream.
+func (s *ControlStream) AddUsers(ids ...int64) error {
+	return s.update("add_user.json", ids)
+}
+
+// Removes users from the site stream.
+func (s *ControlStream) RemoveUsers(ids ...int64) error {
+	return s.update("remove_user.json", ids)
+}
+
+func (s *ControlStream) update(endpoint string, ids []int64) error {
+	if len(ids) == 0 {
+		return fmt.Errorf("No users given")
+	}
+	if len(ids) > MaxControlUsers {
+		return fmt.Errorf("Too many users: %v (max %v)", len(ids), MaxControlUsers)
+	}
+	users := make([]string, len(ids))
+	for i, id := range ids {
+		users[i] = strconv.FormatInt(id, 10)
+	}
+	body := url.Values{"user_id": {strings.Join(users, ",")}}.Encode()
+	reqUrl := strings.TrimSuffix(s.URL.String(), "/") + "/" + endpoint
+	req, err := http.NewRequest("POST", reqUrl, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if err = sign(req, s.cred, body); err != nil {
+		return err
+	}
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return &StatusError{
+			StatusCode: resp.StatusCode,
+			Status:     resp.Status,
+			Header:     resp.Header,
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), clockOr(s.Clock).Now()),
+		}
+	}
+	return nil
+}
+
+// Receives messages from a site stream along with the ID of the user they
+// were sent to.
+type UserHandler interface {
+	HandleUserMessage(userID int64, msg []byte) error
+}
+
+// Adapts an ordinary function to the UserHandler interface.
+type UserHandlerFunc func(userID int64, msg []byte) error
+
+func (f UserHandlerFunc) HandleUserMessage(userID int64, msg []byte) error {
+	return f(userID, msg)
+}
+
+// Returns a Handler which unwraps the for_user envelope of site stream
+// messages and passes the inner message to h.  Messages without an envelope
+// are passed with a user ID of zero.  Malformed envelopes stop the stream
+// like any other Handler error.
+func NewSiteStreamHandler(h UserHandler) Handler {
+	return HandlerFunc(func(msg []byte) error {
+		if messageType(msg) != "for_user" {
+			return h.HandleUserMessage(0, msg)
+		}
+		envelope := &struct {
+			ForUser json.Number     `json:"for_user"`
+			Message json.RawMessage `json:"message"`
+		}{}
+		if err := json.Unmarshal(msg, envelope); err != nil {
+			return err
+		}
+		userID, err := envelope.ForUser.Int64()
+		if err != nil {
+			return fmt.Errorf("Malformed for_user: %q", envelope.ForUser)
+		}
+		return h.HandleUserMessage(userID, envelope.Message)
+	})
+}
@@ -0,0 +1,113 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"fmt"
+	"github.com/kurrik/golibs/twurlrc"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+const CONTROL_JSON = `{"control":{"control_uri":"/1.1/site/c/1_1_54e345d655ee3e8df359ac033648530bfbe26c5f"}}`
+
+func TestControlStream(t *testing.T) {
+	var requests []*http.Request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		requests = append(requests, r)
+		if r.Form.Get("user_id") == "3" {
+			w.WriteHeader(400)
+		}
+	}))
+	defer server.Close()
+	event, err := decodeEvent([]byte(CONTROL_JSON))
+	if err != nil {
+		t.Fatalf("decodeEvent returned error: %v", err)
+	}
+	notice, ok := event.(*ControlNotice)
+	if !ok {
+		t.Fatalf("Expected *ControlNotice, got %T", event)
+	}
+	stream, _ := url.Parse(server.URL + "/1.1/site.json")
+	cred := &twurlrc.Credentials{Token: "token", Secret: "secret"}
+	control, err := NewControlStream(stream, notice, cred)
+	if err != nil {
+		t.Fatalf("NewControlStream returned error: %v", err)
+	}
+	if err = control.AddUsers(1, 2); err != nil {
+		t.Errorf("AddUsers returned error: %v", err)
+	}
+	if err = control.RemoveUsers(3); err == nil {
+		t.Errorf("Expected error for 400 response")
+	} else if status, ok := err.(*StatusError); !ok || status.StatusCode != 400 {
+		t.Errorf("Expected StatusError, got %v", err)
+	}
+	if len(requests) != 2 {
+		t.Fatalf("Expected 2 requests, got %v", len(requests))
+	}
+	expected := []struct {
+		path  string
+		users string
+	}{
+		{"/1.1/site/c/1_1_54e345d655ee3e8df359ac033648530bfbe26c5f/add_user.json", "1,2"},
+		{"/1.1/site/c/1_1_54e345d655ee3e8df359ac033648530bfbe26c5f/remove_user.json", "3"},
+	}
+	for i, e := range expected {
+		req := requests[i]
+		if req.Method != "POST" || req.URL.Path != e.path || req.Form.Get("user_id") != e.users {
+			t.Errorf("Unexpected request %v %v %v", req.Method, req.URL.Path, req.Form)
+		}
+		if req.Header.Get("Authorization") == "" {
+			t.Errorf("Request was not signed")
+		}
+	}
+	if err = control.AddUsers(make([]int64, MaxControlUsers+1)...); err == nil {
+		t.Errorf("Expected error adding too many users")
+	}
+}
+
+func TestSiteStreamHandler(t *testing.T) {
+	var received []string
+	handler := NewSiteStreamHandler(UserHandlerFunc(func(userID int64, msg []byte) error {
+		received = append(received, fmt.Sprintf("%v %s", userID, msg))
+		return nil
+	}))
+	messages := []string{
+		`{"for_user":1888,"message":{"friends":[1,2]}}`,
+		`{"for_user":"1889","message":{"text":"hi"}}`,
+		`{"text":"no envelope"}`,
+	}
+	for _, msg := range messages {
+		if err := handler.HandleMessage([]byte(msg)); err != nil {
+			t.Fatalf("HandleMessage(%q) returned error: %v", msg, err)
+		}
+	}
+	expected := []string{
+		`1888 {"friends":[1,2]}`,
+		`1889 {"text":"hi"}`,
+		`0 {"text":"no envelope"}`,
+	}
+	for i := range expected {
+		if received[i] != expected[i] {
+			t.Errorf("Expected %q, got %q", expected[i], received[i])
+		}
+	}
+	if err := handler.HandleMessage([]byte(`{"for_user":"x","message":{}}`)); err == nil {
+		t.Errorf("Expected error for malformed envelope")
+	}
+}
@@ -0,0 +1,198 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// Re-serves the messages written to it as Server-Sent Events, for HTTP
+// clients such as a browser EventSource.  Like WebSocketBridge, an
+// SSEBridge is both a Sink and an http.Handler.
+//
+// Each message is sent as an event whose id is its sequence number, and the
+// most recent History messages are kept in memory.  A client which
+// reconnects with a Last-Event-ID header first receives the kept messages
+// it missed.  Every client has a buffer of BufferSize messages, and a
+// client whose buffer fills is disconnected, after which it may reconnect
+// and catch up.
+type SSEBridge struct {
+	History    int
+	BufferSize int
+
+	lock    sync.Mutex
+	history []sseEvent
+	seq     uint64
+	clients map[*sseClient]bool
+	closed  bool
+	evicted uint64
+}
+
+type sseEvent struct {
+	id   uint64
+	data []byte
+}
+
+type sseClient struct {
+	c chan sseEvent
+}
+
+// Returns an SSEBridge keeping history messages for catch-up and buffering
+// up to bufferSize messages per client.
+func NewSSEBridge(history int, bufferSize int) *SSEBridge {
+	return &SSEBridge{History: history, BufferSize: bufferSize}
+}
+
+// Records msg and sends it to every connected client, disconnecting clients
+// whose buffer is full.  Never blocks on slow clients.
+func (b *SSEBridge) Write(msg []byte) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.seq++
+	event := sseEvent{id: b.seq, data: append([]byte(nil), msg...)}
+	if b.History > 0 {
+		if len(b.history) < b.History {
+			b.history = append(b.history, event)
+		} else {
+			b.history[int((event.id-1)%uint64(b.History))] = event
+		}
+	}
+	for client := range b.clients {
+		select {
+		case client.c <- event:
+		default:
+			atomic.AddUint64(&b.evicted, 1)
+			b.remove(client)
+		}
+	}
+	return nil
+}
+
+// Returns the number of connected clients.
+func (b *SSEBridge) Clients() int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return len(b.clients)
+}
+
+// Returns the number of clients disconnected for falling behind.
+func (b *SSEBridge) Evicted() uint64 {
+	return atomic.LoadUint64(&b.evicted)
+}
+
+// Disconnects all clients and refuses new ones.
+func (b *SSEBridge) Close() error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.closed = true
+	for client := range b.clients {
+		b.remove(client)
+	}
+	return nil
+}
+
+// Must be called with the lock held.
+func (b *SSEBridge) remove(client *sseClient) {
+	if b.clients[client] {
+		delete(b.clients, client)
+		close(client.c)
+	}
+}
+
+// Returns the kept events with IDs after last, oldest first.  Must be
+// called with the lock held.
+func (b *SSEBridge) since(last uint64) []sseEvent {
+	var events []sseEvent
+	for i := range b.history {
+		// The oldest event is the one after the most recent.
+		event := b.history[(int(b.seq)+i)%len(b.history)]
+		if event.id > last {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+// Streams events to the client until it disconnects or is evicted.
+func (b *SSEBridge) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	client := &sseClient{c: make(chan sseEvent, b.BufferSize)}
+	var backlog []sseEvent
+	b.lock.Lock()
+	if b.closed {
+		b.lock.Unlock()
+		http.Error(w, "Stream closed", http.StatusServiceUnavailable)
+		return
+	}
+	if last, err := strconv.ParseUint(r.Header.Get("Last-Event-ID"), 10, 64); err == nil {
+		backlog = b.since(last)
+	}
+	if b.clients == nil {
+		b.clients = map[*sseClient]bool{}
+	}
+	b.clients[client] = true
+	b.lock.Unlock()
+	defer func() {
+		b.lock.Lock()
+		b.remove(client)
+		b.lock.Unlock()
+	}()
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	var buffer []byte
+	for _, event := range backlog {
+		buffer = appendEvent(buffer, event)
+	}
+	if _, err := w.Write(buffer); err != nil {
+		return
+	}
+	flusher.Flush()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-client.c:
+			if !ok {
+				return
+			}
+			buffer = appendEvent(buffer[:0], event)
+			if _, err := w.Write(buffer); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// Appends event in the text/event-stream format.  Lines of the message
+// are sent as separate data fields.
+func appendEvent(b []byte, event sseEvent) []byte {
+	b = append(b, "id: "...)
+	b = strconv.AppendUint(b, event.id, 10)
+	b = append(b, '\n')
+	for _, line := range bytes.Split(event.data, []byte("\n")) {
+		b = append(append(append(b, "data: "...), bytes.TrimSuffix(line, []byte("\r"))...), '\n')
+	}
+	return append(b, '\n')
+}
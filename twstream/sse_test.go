@@ -0,0 +1,125 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Opens an event stream, returning a reader positioned at the first event.
+func openEvents(t *testing.T, url string, lastEventID string) (*http.Response, *bufio.Reader) {
+	req, _ := http.NewRequest("GET", url, nil)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Unexpected Content-Type %q", ct)
+	}
+	return resp, bufio.NewReader(resp.Body)
+}
+
+// Reads a single event.
+func readEvent(t *testing.T, reader *bufio.Reader) string {
+	var lines []string
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Expected event, got %v", err)
+		}
+		if line == "\n" {
+			return strings.Join(lines, "|")
+		}
+		lines = append(lines, strings.TrimSuffix(line, "\n"))
+	}
+}
+
+func TestSSEBridge(t *testing.T) {
+	bridge := NewSSEBridge(10, 10)
+	server := httptest.NewServer(bridge)
+	defer server.Close()
+	resp, reader := openEvents(t, server.URL, "")
+	defer resp.Body.Close()
+	waitForClients(t, bridge.Clients, 1)
+	bridge.Write([]byte("{\"a\": 1}"))
+	bridge.Write([]byte("line one\nline two"))
+	if event := readEvent(t, reader); event != "id: 1|data: {\"a\": 1}" {
+		t.Errorf("Unexpected event %q", event)
+	}
+	if event := readEvent(t, reader); event != "id: 2|data: line one|data: line two" {
+		t.Errorf("Unexpected event %q", event)
+	}
+	bridge.Close()
+	if _, err := reader.ReadString('\n'); err == nil {
+		t.Errorf("Expected stream to end when the bridge is closed")
+	}
+}
+
+func TestSSEBridgeCatchUp(t *testing.T) {
+	bridge := NewSSEBridge(3, 10)
+	server := httptest.NewServer(bridge)
+	defer server.Close()
+	for _, msg := range []string{"1", "2", "3", "4", "5"} {
+		bridge.Write([]byte(msg))
+	}
+	cases := []struct {
+		last     string
+		expected []string
+	}{
+		{"3", []string{"id: 4|data: 4", "id: 5|data: 5"}},
+		// Only the three most recent messages, including the live message
+		// sent to the first client, are kept.
+		{"0", []string{"id: 4|data: 4", "id: 5|data: 5", "id: 6|data: live"}},
+	}
+	for _, c := range cases {
+		resp, reader := openEvents(t, server.URL, c.last)
+		for _, expected := range c.expected {
+			if event := readEvent(t, reader); event != expected {
+				t.Errorf("Last-Event-ID %v: expected %q, got %q", c.last, expected, event)
+			}
+		}
+		waitForClients(t, bridge.Clients, 1)
+		bridge.Write([]byte("live"))
+		if event := readEvent(t, reader); !strings.HasSuffix(event, "data: live") {
+			t.Errorf("Expected live event after catch-up, got %q", event)
+		}
+		resp.Body.Close()
+		waitForClients(t, bridge.Clients, 0)
+	}
+}
+
+func TestSSEBridgeEviction(t *testing.T) {
+	bridge := NewSSEBridge(0, 1)
+	client := &sseClient{c: make(chan sseEvent, 1)}
+	bridge.clients = map[*sseClient]bool{client: true}
+	bridge.Write([]byte("1"))
+	bridge.Write([]byte("2"))
+	if bridge.Evicted() != 1 || bridge.Clients() != 0 {
+		t.Errorf("Expected client to be evicted, got %v evicted and %v clients", bridge.Evicted(), bridge.Clients())
+	}
+	if event := <-client.c; event.id != 1 {
+		t.Errorf("Expected buffered event to be kept, got %v", event.id)
+	}
+	if _, ok := <-client.c; ok {
+		t.Errorf("Expected evicted client's channel to be closed")
+	}
+}
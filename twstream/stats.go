@@ -0,0 +1,138 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"expvar"
+	"io"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// A snapshot of the counters kept by a Connection.
+type Stats struct {
+	// Messages delivered, not counting keepalive lines.
+	Messages uint64
+	// Bytes read from the network, before decompression.
+	Bytes uint64
+	// Messages which could not be decoded as a Tweet or event.
+	DecodeErrors uint64
+	// Reconnection attempts made by Run.
+	Reconnects uint64
+	// The delay before the next reconnection attempt, or zero if connected.
+	Backoff time.Duration
+	// The state of the connection.
+	State State
+	// How long the current connection has been established, or zero if the
+	// stream is not connected.
+	Uptime time.Duration
+	// When the last message was read, or the zero time if none has been.
+	LastMessage time.Time
+}
+
+// The state of a Connection, as reported by Stats.
+type State int32
+
+const (
+	// Not connected, and not trying to connect.
+	StateDisconnected State = iota
+	// Opening a connection and waiting for the response.
+	StateConnecting
+	// Reading messages from the stream.
+	StateConnected
+	// Waiting to reconnect after the stream was disconnected.
+	StateReconnecting
+)
+
+var stateNames = []string{"disconnected", "connecting", "connected", "reconnecting"}
+
+func (s State) String() string {
+	if s >= 0 && int(s) < len(stateNames) {
+		return stateNames[s]
+	}
+	return "State(" + strconv.Itoa(int(s)) + ")"
+}
+
+// Marshals the state by name, so published Stats are readable.
+func (s State) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// Counters updated atomically while a Connection is running.
+type counters struct {
+	messages     uint64
+	bytes        uint64
+	decodeErrors uint64
+	reconnects   uint64
+	backoff      int64
+	state        int32
+	connected    int64
+	lastMessage  int64
+	lastRead     int64
+}
+
+// Returns the current counters for the connection.  Stats may be called
+// while the connection is running.
+func (c *Connection) Stats() Stats {
+	stats := Stats{
+		Messages:     atomic.LoadUint64(&c.counters.messages),
+		Bytes:        atomic.LoadUint64(&c.counters.bytes),
+		DecodeErrors: atomic.LoadUint64(&c.counters.decodeErrors),
+		Reconnects:   atomic.LoadUint64(&c.counters.reconnects),
+		Backoff:      time.Duration(atomic.LoadInt64(&c.counters.backoff)),
+		State:        State(atomic.LoadInt32(&c.counters.state)),
+	}
+	if connected := atomic.LoadInt64(&c.counters.connected); connected != 0 {
+		stats.Uptime = c.now().Sub(time.Unix(0, connected))
+	}
+	if last := atomic.LoadInt64(&c.counters.lastMessage); last != 0 {
+		stats.LastMessage = time.Unix(0, last)
+	}
+	return stats
+}
+
+// Records the state of the connection.  Entering StateConnected starts the
+// uptime, and leaving it resets it.
+func (c *Connection) setState(state State) {
+	connected := int64(0)
+	if state == StateConnected {
+		connected = c.now().UnixNano()
+	}
+	atomic.StoreInt64(&c.counters.connected, connected)
+	atomic.StoreInt32(&c.counters.state, int32(state))
+}
+
+// Publishes the connection's Stats as an expvar under the given name.
+// Like expvar.Publish, this panics if the name is already in use.
+func (c *Connection) publish(name string) {
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return c.Stats()
+	}))
+}
+
+// Calls counted with the number of bytes read from the wrapped reader.
+type countingReader struct {
+	reader  io.Reader
+	counted func(n int)
+}
+
+func (r *countingReader) Read(p []byte) (n int, err error) {
+	n, err = r.reader.Read(p)
+	if n > 0 {
+		r.counted(n)
+	}
+	return n, err
+}
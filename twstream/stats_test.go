@@ -0,0 +1,129 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var expvarNames int32
+
+// Returns an expvar name not yet used by the test binary, since names
+// cannot be published twice, for example when tests run with -count.
+func uniqueExpvarName(prefix string) string {
+	return fmt.Sprintf("%v_%d", prefix, atomic.AddInt32(&expvarNames, 1))
+}
+
+func TestStats(t *testing.T) {
+	name := uniqueExpvarName("twstream_test_stats")
+	stop := errors.New("stop")
+	conf := &Configuration{
+		Backoff: &Backoff{
+			NetworkStep:      time.Millisecond,
+			NetworkMax:       time.Millisecond,
+			HTTPInitial:      time.Millisecond,
+			HTTPMax:          time.Millisecond,
+			RateLimitInitial: time.Millisecond,
+			RateLimitMax:     time.Millisecond,
+		},
+		TweetHandler: TweetHandlerFunc(func(tweet *Tweet) error {
+			if tweet.IDStr == "2" {
+				return stop
+			}
+			return nil
+		}),
+		ExpvarName: name,
+	}
+	responses := []string{
+		"HTTP/1.1 503 Service Unavailable\r\n\r\n",
+		"HTTP/1.1 200 OK\r\n\r\n{\"id_str\": \"1\"}\r\n\r\nnot json\r\n",
+		"HTTP/1.1 200 OK\r\n\r\n{\"id_str\": \"2\"}\r\n",
+	}
+	dialer := &SequenceDialer{Responses: responses}
+	conf.Dialer = dialer
+	conn := newStubConnection(conf, "")
+	now := time.Unix(1378316740, 0)
+	conf.Clock = clockFunc(func() time.Time {
+		return now
+	})
+	if err := conn.Run(); err != stop {
+		t.Fatalf("Expected handler error, got %v", err)
+	}
+	bytes := 0
+	for _, response := range responses {
+		bytes += len(response)
+	}
+	expected := Stats{
+		Messages:     3,
+		Bytes:        uint64(bytes),
+		DecodeErrors: 1,
+		Reconnects:   2,
+		State:        StateDisconnected,
+		LastMessage:  now,
+	}
+	if stats := conn.Stats(); stats != expected {
+		t.Errorf("Expected %+v, got %+v", expected, stats)
+	}
+	published := expvar.Get(name)
+	if published == nil {
+		t.Fatalf("Stats were not published")
+	}
+	if !strings.Contains(published.String(), "\"Messages\":3") ||
+		!strings.Contains(published.String(), "\"State\":\"disconnected\"") {
+		t.Errorf("Unexpected published stats: %v", published.String())
+	}
+}
+
+func TestStatsWhileConnected(t *testing.T) {
+	var (
+		conn  *Connection
+		stats Stats
+	)
+	start := time.Unix(1378316740, 0)
+	now := start
+	conf := &Configuration{
+		Handler: HandlerFunc(func(msg []byte) error {
+			now = now.Add(time.Minute)
+			stats = conn.Stats()
+			return nil
+		}),
+	}
+	conn = newStubConnection(conf, "HTTP/1.1 200 OK\r\n\r\n{\"a\": 1}\r\n")
+	conf.Clock = clockFunc(func() time.Time {
+		return now
+	})
+	if state := conn.Stats().State; state != StateDisconnected {
+		t.Errorf("Expected disconnected before reading, got %v", state)
+	}
+	conn.Read()
+	if stats.State != StateConnected {
+		t.Errorf("Expected connected while reading, got %v", stats.State)
+	}
+	if stats.Uptime != time.Minute {
+		t.Errorf("Expected uptime of 1m, got %v", stats.Uptime)
+	}
+	if !stats.LastMessage.Equal(start) {
+		t.Errorf("Expected last message at %v, got %v", start, stats.LastMessage)
+	}
+	if stats := conn.Stats(); stats.State != StateDisconnected || stats.Uptime != 0 {
+		t.Errorf("Expected disconnected with no uptime, got %+v", stats)
+	}
+}
@@ -0,0 +1,231 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/kurrik/golibs/twurlrc"
+	"sync"
+	"time"
+)
+
+// A message received by one of a Supervisor's streams.
+type StreamMessage struct {
+	Stream string
+	Data   []byte
+}
+
+// An error encountered by one of a Supervisor's streams.
+type StreamError struct {
+	Stream string
+	Err    error
+}
+
+func (e *StreamError) Error() string {
+	return fmt.Sprintf("%v: %v", e.Stream, e.Err)
+}
+
+func (e *StreamError) Unwrap() error {
+	return e.Err
+}
+
+// Returned by Add and AddPartitions once the Supervisor has been stopped.
+var ErrSupervisorStopped = errors.New("Supervisor stopped")
+
+// Runs several named Connections, restarting any which stop with an error,
+// and aggregates their messages and errors onto shared channels.  Both
+// channels are closed once Stop returns.
+type Supervisor struct {
+	Messages chan *StreamMessage
+
+	// Receives every error which causes a stream to reconnect or stop.
+	// Errors are dropped if the channel is full.
+	Errors chan *StreamError
+
+	// The delay before restarting a stream whose Run method returned an
+	// error.
+	RestartDelay time.Duration
+
+	// Times the RestartDelay.  Defaults to SystemClock.
+	Clock Clock
+
+	lock    sync.Mutex
+	streams map[string]*Connection
+	ctx     context.Context
+	cancel  context.CancelFunc
+	stopped bool
+	wait    sync.WaitGroup
+}
+
+// Returns a Supervisor whose channels have the given buffer size.
+func NewSupervisor(size int) *Supervisor {
+	return &Supervisor{
+		Messages:     make(chan *StreamMessage, size),
+		Errors:       make(chan *StreamError, size),
+		RestartDelay: 5 * time.Second,
+		streams:      map[string]*Connection{},
+	}
+}
+
+// Adds a stream under the given name, starting it immediately if the
+// Supervisor is running.  The connection's Sink is replaced by one which
+// sends to Messages, though any handlers it has still run.  The connection
+// must not be run elsewhere.  Returns ErrSupervisorStopped, leaving the
+// connection unchanged, once Stop has been called.
+func (s *Supervisor) Add(name string, conn *Connection) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.stopped {
+		return ErrSupervisorStopped
+	}
+	conf := *conn.conf
+	conf.Sink = SinkFunc(func(msg []byte) error {
+		return s.forward(name, msg)
+	})
+	conn.conf = &conf
+	conn.onError = func(err error) {
+		s.report(name, err)
+	}
+	s.streams[name] = conn
+	if s.ctx != nil {
+		s.start(name, conn)
+	}
+	return nil
+}
+
+// Adds one stream for each of the given hose partitions, named
+// "name/partition".  Each stream is created from a copy of conf with
+// Partitions set to its partition alone, so that a slow or disconnected
+// partition does not hold up the others.  If conf has an ExpvarName, each
+// stream's stats are published as "ExpvarName/partition".  Returns
+// ErrSupervisorStopped once Stop has been called.
+func (s *Supervisor) AddPartitions(name string, cred *twurlrc.Credentials, conf *Configuration, partitions []int) error {
+	for _, partition := range partitions {
+		copied := *conf
+		copied.Partitions = []int{partition}
+		if conf.ExpvarName != "" {
+			copied.ExpvarName = fmt.Sprintf("%v/%v", conf.ExpvarName, partition)
+		}
+		if err := s.Add(fmt.Sprintf("%v/%v", name, partition), NewConnection(&copied, cred)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the partitions, numbered from 1 to count, which should be consumed
+// by process index (counting from 0) of processes sharing a hose.
+// Partitions are assigned round robin.
+func SplitPartitions(count int, processes int, index int) []int {
+	var partitions []int
+	for partition := index + 1; partition <= count; partition += processes {
+		partitions = append(partitions, partition)
+	}
+	return partitions
+}
+
+// Returns the names of the supervised streams.
+func (s *Supervisor) Streams() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	names := make([]string, 0, len(s.streams))
+	for name := range s.streams {
+		names = append(names, name)
+	}
+	return names
+}
+
+// Starts all streams added so far.  Streams run until ctx is cancelled or
+// Stop is called.  Has no effect once the Supervisor has been started or
+// stopped.
+func (s *Supervisor) Start(ctx context.Context) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.ctx != nil || s.stopped {
+		return
+	}
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	for name, conn := range s.streams {
+		s.start(name, conn)
+	}
+}
+
+// Stops all streams, waits for them to exit, and closes the channels.
+// Calls after the first have no effect.
+func (s *Supervisor) Stop() {
+	s.lock.Lock()
+	if s.stopped {
+		s.lock.Unlock()
+		return
+	}
+	s.stopped = true
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.lock.Unlock()
+	s.wait.Wait()
+	close(s.Messages)
+	close(s.Errors)
+}
+
+// Runs a stream until the Supervisor stops, restarting it whenever it
+// returns an error.  Streams which return nil, because they were closed or
+// their TTL expired, are not restarted.  Must be called with the lock held.
+func (s *Supervisor) start(name string, conn *Connection) {
+	ctx := s.ctx
+	s.wait.Add(1)
+	go func() {
+		defer s.wait.Done()
+		for {
+			err := conn.RunContext(ctx)
+			if ctx.Err() != nil {
+				return
+			}
+			if err == nil {
+				return
+			}
+			s.report(name, err)
+			wake, timer := after(s.Clock, s.RestartDelay)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-wake:
+			}
+		}
+	}()
+}
+
+func (s *Supervisor) forward(name string, msg []byte) error {
+	message := &StreamMessage{
+		Stream: name,
+		Data:   append([]byte(nil), msg...),
+	}
+	select {
+	case s.Messages <- message:
+		return nil
+	case <-s.ctx.Done():
+		return s.ctx.Err()
+	}
+}
+
+func (s *Supervisor) report(name string, err error) {
+	select {
+	case s.Errors <- &StreamError{Stream: name, Err: err}:
+	default:
+	}
+}
@@ -0,0 +1,202 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestSupervisor(t *testing.T) {
+	fast := &Backoff{
+		NetworkStep: time.Millisecond,
+		NetworkMax:  time.Millisecond,
+		HTTPInitial: time.Millisecond,
+		HTTPMax:     time.Millisecond,
+	}
+	sample := newStubConnection(&Configuration{
+		Backoff: fast,
+		Dialer: &SequenceDialer{Responses: []string{
+			"HTTP/1.1 200 OK\r\n\r\n{\"sample\": 1}\r\n",
+		}},
+	}, "")
+	filter := newStubConnection(&Configuration{
+		Backoff: fast,
+		Dialer: &SequenceDialer{Responses: []string{
+			"HTTP/1.1 401 Unauthorized\r\n\r\n",
+			"HTTP/1.1 200 OK\r\n\r\n{\"filter\": 1}\r\n",
+		}},
+	}, "")
+
+	supervisor := NewSupervisor(10)
+	supervisor.Add("sample", sample)
+	supervisor.Start(context.Background())
+	supervisor.Add("filter", filter)
+
+	received := map[string]string{}
+	for len(received) < 2 {
+		select {
+		case msg := <-supervisor.Messages:
+			received[msg.Stream] = string(msg.Data)
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for messages, got %v", received)
+		}
+	}
+	if received["sample"] != "{\"sample\": 1}" || received["filter"] != "{\"filter\": 1}" {
+		t.Errorf("Unexpected messages %v", received)
+	}
+	supervisor.Stop()
+
+	sawUnauthorized := false
+	for err := range supervisor.Errors {
+		if err.Stream == "filter" && errors.Is(err, ErrUnauthorized) {
+			sawUnauthorized = true
+		}
+	}
+	if !sawUnauthorized {
+		t.Errorf("Expected the filter stream's 401 to be reported")
+	}
+	names := supervisor.Streams()
+	sort.Strings(names)
+	if len(names) != 2 || names[0] != "filter" || names[1] != "sample" {
+		t.Errorf("Unexpected streams %v", names)
+	}
+}
+
+func TestSupervisorClosedStream(t *testing.T) {
+	dialer := &SequenceDialer{Responses: []string{
+		"HTTP/1.1 200 OK\r\n\r\n{\"a\": 1}\r\n",
+		"HTTP/1.1 200 OK\r\n\r\n{\"b\": 2}\r\n",
+	}}
+	conf := &Configuration{Dialer: dialer}
+	conn := newStubConnection(conf, "")
+	conf.Handler = HandlerFunc(func(msg []byte) error {
+		return conn.Close()
+	})
+	supervisor := NewSupervisor(10)
+	supervisor.RestartDelay = time.Millisecond
+	supervisor.Add("sample", conn)
+	supervisor.Start(context.Background())
+	exited := make(chan bool)
+	go func() {
+		supervisor.wait.Wait()
+		exited <- true
+	}()
+	select {
+	case <-exited:
+	case <-time.After(time.Second):
+		t.Fatal("Expected the closed stream not to be restarted")
+	}
+	if dialer.Dials != 1 {
+		t.Errorf("Expected 1 dial, got %v", dialer.Dials)
+	}
+	supervisor.Stop()
+}
+
+func TestSupervisorStopTwice(t *testing.T) {
+	supervisor := NewSupervisor(10)
+	supervisor.Start(context.Background())
+	supervisor.Stop()
+	supervisor.Stop()
+}
+
+func TestSupervisorAddAfterStop(t *testing.T) {
+	supervisor := NewSupervisor(10)
+	supervisor.Start(context.Background())
+	supervisor.Stop()
+	conf := &Configuration{Dialer: &SequenceDialer{Responses: []string{
+		"HTTP/1.1 200 OK\r\n\r\n{\"a\": 1}\r\n",
+	}}}
+	conn := newStubConnection(conf, "")
+	if err := supervisor.Add("sample", conn); err != ErrSupervisorStopped {
+		t.Errorf("Expected ErrSupervisorStopped, got %v", err)
+	}
+	if conn.conf != conf {
+		t.Errorf("Expected the connection to be left unchanged")
+	}
+	if err := supervisor.AddPartitions("hose", conn.cred, conf, []int{1}); err != ErrSupervisorStopped {
+		t.Errorf("Expected ErrSupervisorStopped, got %v", err)
+	}
+	if names := supervisor.Streams(); len(names) != 0 {
+		t.Errorf("Unexpected streams %v", names)
+	}
+}
+
+func TestAddPartitions(t *testing.T) {
+	supervisor := NewSupervisor(10)
+	conf := &Configuration{}
+	conn := newStubConnection(conf, "")
+	supervisor.AddPartitions("hose", conn.cred, conf, []int{2, 4})
+	names := supervisor.Streams()
+	sort.Strings(names)
+	if len(names) != 2 || names[0] != "hose/2" || names[1] != "hose/4" {
+		t.Fatalf("Unexpected streams %v", names)
+	}
+	for name, expected := range map[string]string{"hose/2": "2", "hose/4": "4"} {
+		params, err := supervisor.streams[name].params()
+		if err != nil {
+			t.Fatalf("params returned error: %v", err)
+		}
+		if actual := params.Get("partitions"); actual != expected {
+			t.Errorf("%v: expected partitions %q, got %q", name, expected, actual)
+		}
+	}
+	if conf.Partitions != nil {
+		t.Errorf("Configuration was modified: %v", conf.Partitions)
+	}
+	conf.Partitions = []int{1, 0}
+	if _, err := conn.params(); err == nil {
+		t.Errorf("Expected error for invalid partition")
+	}
+}
+
+func TestAddPartitionsExpvar(t *testing.T) {
+	supervisor := NewSupervisor(10)
+	name := uniqueExpvarName("twstream_test_partitions")
+	conf := &Configuration{ExpvarName: name}
+	conn := newStubConnection(conf, "")
+	supervisor.AddPartitions("hose", conn.cred, conf, []int{1, 2})
+	for _, partition := range []string{"1", "2"} {
+		if expvar.Get(name+"/"+partition) == nil {
+			t.Errorf("Stats for partition %v were not published", partition)
+		}
+	}
+}
+
+func TestSplitPartitions(t *testing.T) {
+	cases := []struct {
+		count     int
+		processes int
+		index     int
+		expected  string
+	}{
+		{4, 1, 0, "[1 2 3 4]"},
+		{5, 2, 0, "[1 3 5]"},
+		{5, 2, 1, "[2 4]"},
+		{2, 3, 2, "[]"},
+	}
+	for _, c := range cases {
+		actual := fmt.Sprint(SplitPartitions(c.count, c.processes, c.index))
+		if actual != c.expected {
+			t.Errorf("SplitPartitions(%v, %v, %v): expected %v, got %v",
+				c.count, c.processes, c.index, c.expected, actual)
+		}
+	}
+}
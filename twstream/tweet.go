@@ -0,0 +1,194 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
+// The layout used by Twitter for created_at timestamps.
+const CreatedAtLayout = "Mon Jan 02 15:04:05 -0700 2006"
+
+type Tweet struct {
+	ID                   int64        `json:"id"`
+	IDStr                string       `json:"id_str"`
+	Text                 string       `json:"text"`
+	CreatedAt            string       `json:"created_at"`
+	Source               string       `json:"source"`
+	Truncated            bool         `json:"truncated"`
+	InReplyToStatusID    int64        `json:"in_reply_to_status_id"`
+	InReplyToStatusIDStr string       `json:"in_reply_to_status_id_str"`
+	InReplyToUserID      int64        `json:"in_reply_to_user_id"`
+	InReplyToUserIDStr   string       `json:"in_reply_to_user_id_str"`
+	InReplyToScreenName  string       `json:"in_reply_to_screen_name"`
+	User                 *User        `json:"user"`
+	Entities             *Entities    `json:"entities"`
+	Coordinates          *Coordinates `json:"coordinates"`
+	RetweetedStatus      *Tweet       `json:"retweeted_status"`
+	RetweetCount         int          `json:"retweet_count"`
+	FavoriteCount        int          `json:"favorite_count"`
+	Lang                 string       `json:"lang"`
+	TimestampMs          string       `json:"timestamp_ms"`
+
+	// The complete text of the Tweet, which DecodeTweet always sets.  It is
+	// read from full_text when Tweets are requested in extended mode, from
+	// extended_tweet when a Tweet longer than 140 characters is sent in
+	// compatibility mode, and otherwise copied from Text.
+	FullText         string         `json:"full_text"`
+	DisplayTextRange []int          `json:"display_text_range"`
+	ExtendedTweet    *ExtendedTweet `json:"extended_tweet"`
+	ExtendedEntities *Entities      `json:"extended_entities"`
+
+	// The rules which matched the Tweet, on PowerTrack streams.
+	MatchingRules []MatchingRule `json:"matching_rules"`
+
+	// The undecoded JSON payload this Tweet was parsed from.
+	Raw json.RawMessage `json:"-"`
+}
+
+// The complete text and entities of a Tweet longer than 140 characters,
+// sent alongside its truncated Text in compatibility mode.
+type ExtendedTweet struct {
+	FullText         string    `json:"full_text"`
+	DisplayTextRange []int     `json:"display_text_range"`
+	Entities         *Entities `json:"entities"`
+	ExtendedEntities *Entities `json:"extended_entities"`
+}
+
+// Fills FullText, and replaces the truncated entities with those for the
+// full text, for both extended and compatibility mode payloads.
+func (t *Tweet) normalize() {
+	if ext := t.ExtendedTweet; ext != nil {
+		t.FullText = ext.FullText
+		t.DisplayTextRange = ext.DisplayTextRange
+		if ext.Entities != nil {
+			t.Entities = ext.Entities
+		}
+		if ext.ExtendedEntities != nil {
+			t.ExtendedEntities = ext.ExtendedEntities
+		}
+	}
+	if t.FullText == "" {
+		t.FullText = t.Text
+	}
+	if t.RetweetedStatus != nil {
+		t.RetweetedStatus.normalize()
+	}
+}
+
+// Returns the parsed created_at time of the Tweet.
+func (t *Tweet) CreatedTime() (time.Time, error) {
+	return time.Parse(CreatedAtLayout, t.CreatedAt)
+}
+
+// Returns the time at which Twitter processed the Tweet, from its
+// timestamp_ms field.
+func (t *Tweet) Timestamp() (time.Time, error) {
+	ms, err := strconv.ParseInt(t.TimestampMs, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(0, ms*int64(time.Millisecond)), nil
+}
+
+type User struct {
+	ID              int64  `json:"id"`
+	IDStr           string `json:"id_str"`
+	Name            string `json:"name"`
+	ScreenName      string `json:"screen_name"`
+	Location        string `json:"location"`
+	Description     string `json:"description"`
+	URL             string `json:"url"`
+	Protected       bool   `json:"protected"`
+	Verified        bool   `json:"verified"`
+	FollowersCount  int    `json:"followers_count"`
+	FriendsCount    int    `json:"friends_count"`
+	StatusesCount   int    `json:"statuses_count"`
+	CreatedAt       string `json:"created_at"`
+	Lang            string `json:"lang"`
+	ProfileImageURL string `json:"profile_image_url_https"`
+}
+
+type Entities struct {
+	Hashtags     []Hashtag     `json:"hashtags"`
+	URLs         []URLEntity   `json:"urls"`
+	UserMentions []UserMention `json:"user_mentions"`
+	Media        []Media       `json:"media"`
+}
+
+type Hashtag struct {
+	Text    string `json:"text"`
+	Indices []int  `json:"indices"`
+}
+
+type URLEntity struct {
+	URL         string `json:"url"`
+	ExpandedURL string `json:"expanded_url"`
+	DisplayURL  string `json:"display_url"`
+	Indices     []int  `json:"indices"`
+}
+
+type UserMention struct {
+	ID         int64  `json:"id"`
+	IDStr      string `json:"id_str"`
+	Name       string `json:"name"`
+	ScreenName string `json:"screen_name"`
+	Indices    []int  `json:"indices"`
+}
+
+type Media struct {
+	ID            int64  `json:"id"`
+	IDStr         string `json:"id_str"`
+	Type          string `json:"type"`
+	URL           string `json:"url"`
+	MediaURLHttps string `json:"media_url_https"`
+	ExpandedURL   string `json:"expanded_url"`
+	DisplayURL    string `json:"display_url"`
+	Indices       []int  `json:"indices"`
+}
+
+// A GeoJSON point.  Note that Coordinates are ordered longitude, latitude.
+type Coordinates struct {
+	Type        string    `json:"type"`
+	Coordinates []float64 `json:"coordinates"`
+}
+
+// Parses a single stream payload into a Tweet.  The payload is copied into
+// the Raw field of the result.  Payloads which are not Tweets, such as
+// delete notices, decode without error but have an empty IDStr.
+func DecodeTweet(msg []byte) (*Tweet, error) {
+	tweet := &Tweet{}
+	if err := json.Unmarshal(msg, tweet); err != nil {
+		return nil, err
+	}
+	tweet.normalize()
+	tweet.Raw = append(json.RawMessage(nil), msg...)
+	return tweet, nil
+}
+
+// Receives decoded Tweets read from a stream.  Returning a non-nil error
+// stops the stream and causes Read to return that error.
+type TweetHandler interface {
+	HandleTweet(tweet *Tweet) error
+}
+
+// Adapts an ordinary function to the TweetHandler interface.
+type TweetHandlerFunc func(tweet *Tweet) error
+
+func (f TweetHandlerFunc) HandleTweet(tweet *Tweet) error {
+	return f(tweet)
+}
@@ -0,0 +1,124 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+const TWEET_JSON = `{"created_at":"Wed Aug 29 17:12:58 +0000 2012",` +
+	`"id":240859602684612608,"id_str":"240859602684612608",` +
+	`"text":"Introducing #TwitterCertified","lang":"en",` +
+	`"user":{"id":6253282,"id_str":"6253282","screen_name":"twitterapi"},` +
+	`"entities":{"hashtags":[{"text":"TwitterCertified","indices":[12,29]}],` +
+	`"urls":[],"user_mentions":[]}}`
+
+func TestDecodeTweet(t *testing.T) {
+	tweet, err := DecodeTweet([]byte(TWEET_JSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tweet.ID != 240859602684612608 || tweet.IDStr != "240859602684612608" {
+		t.Errorf("Unexpected ID %v / %v", tweet.ID, tweet.IDStr)
+	}
+	if tweet.User == nil || tweet.User.ScreenName != "twitterapi" {
+		t.Errorf("Unexpected user %+v", tweet.User)
+	}
+	if len(tweet.Entities.Hashtags) != 1 || tweet.Entities.Hashtags[0].Text != "TwitterCertified" {
+		t.Errorf("Unexpected entities %+v", tweet.Entities)
+	}
+	if string(tweet.Raw) != TWEET_JSON {
+		t.Errorf("Raw payload was not preserved")
+	}
+	created, err := tweet.CreatedTime()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.Year() != 2012 || created.Month() != 8 || created.Day() != 29 {
+		t.Errorf("Unexpected created time %v", created)
+	}
+}
+
+func TestDecodeExtendedTweet(t *testing.T) {
+	full := strings.Repeat("long ", 40) + "#golang"
+	cases := map[string]string{
+		"compatibility": `{"id_str":"1","text":"long long…","truncated":true,` +
+			`"entities":{"hashtags":[]},` +
+			`"extended_tweet":{"full_text":"` + full + `","display_text_range":[0,207],` +
+			`"entities":{"hashtags":[{"text":"golang","indices":[200,207]}]}}}`,
+		"extended": `{"id_str":"1","full_text":"` + full + `","display_text_range":[0,207],` +
+			`"entities":{"hashtags":[{"text":"golang","indices":[200,207]}]}}`,
+		"retweet": `{"id_str":"2","text":"RT long…","retweeted_status":` +
+			`{"id_str":"1","text":"long…","extended_tweet":{"full_text":"` + full + `",` +
+			`"entities":{"hashtags":[{"text":"golang","indices":[200,207]}]}}}}`,
+	}
+	for name, msg := range cases {
+		tweet, err := DecodeTweet([]byte(msg))
+		if err != nil {
+			t.Fatalf("%v: %v", name, err)
+		}
+		if tweet.RetweetedStatus != nil {
+			tweet = tweet.RetweetedStatus
+		}
+		if tweet.FullText != full {
+			t.Errorf("%v: expected full text, got %q", name, tweet.FullText)
+		}
+		if tweet.Entities == nil || len(tweet.Entities.Hashtags) != 1 {
+			t.Errorf("%v: expected entities of the full text, got %+v", name, tweet.Entities)
+		}
+	}
+	tweet, err := DecodeTweet([]byte(TWEET_JSON))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tweet.FullText != tweet.Text {
+		t.Errorf("Expected FullText to default to Text, got %q", tweet.FullText)
+	}
+}
+
+func TestExtendedTweetParams(t *testing.T) {
+	conf := &Configuration{
+		ExtendedTweets:     true,
+		StringifyFriendIDs: true,
+	}
+	conn := newStubConnection(conf, "HTTP/1.1 200 OK\r\n\r\n")
+	conn.Read()
+	query := sentRequest(t, conn).URL.Query()
+	if query.Get("tweet_mode") != "extended" || query.Get("stringify_friend_ids") != "true" {
+		t.Errorf("Unexpected query %v", query)
+	}
+}
+
+func TestTweetHandler(t *testing.T) {
+	var tweets []*Tweet
+	conf := &Configuration{
+		TweetHandler: TweetHandlerFunc(func(tweet *Tweet) error {
+			tweets = append(tweets, tweet)
+			return nil
+		}),
+	}
+	response := "HTTP/1.1 200 OK\r\n\r\n" +
+		TWEET_JSON + "\r\n" +
+		"{\"delete\":{\"status\":{\"id\":1,\"id_str\":\"1\"}}}\r\n"
+	conn := newStubConnection(conf, response)
+	if err := conn.Read(); err != io.EOF {
+		t.Fatalf("Expected EOF, got %v", err)
+	}
+	if len(tweets) != 1 || tweets[0].IDStr != "240859602684612608" {
+		t.Errorf("Unexpected tweets %+v", tweets)
+	}
+}
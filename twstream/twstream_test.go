@@ -15,15 +15,30 @@
 package twstream
 
 import (
-	"testing"
-	"io"
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
 	"github.com/kurrik/golibs/twurlrc"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"os"
 	"strings"
+	"testing"
+	"time"
 )
 
 type MockDialer struct {
-	t *testing.T
+	t    *testing.T
 	Conn *MockConnection
 }
 
@@ -108,7 +123,7 @@ func (c *MockConnection) EndTest() {
 }
 
 var (
-	CRLF = string([]byte{13, 10})
+	CRLF           = string([]byte{13, 10})
 	CONNECT_STRING = strings.Join([]string{
 		"GET /1/statuses/filter.json HTTP/1.1",
 		"Host: stream.twitter.com",
@@ -124,34 +139,1018 @@ var (
 		"Connection: close",
 		CRLF,
 	}, CRLF)
+	RESPONSE_STRING  = "HTTP/1.1 200 OK" + CRLF + CRLF
 	PAYLOAD_STRING_1 = "{\"foo\": \"bar\"}" + CRLF
 )
 
 func TestParse(t *testing.T) {
 	dialer := NewMockDialer(t)
 	dialer.Conn.Expect(WRITE, CONNECT_STRING)
-	dialer.Conn.Expect(READ, PAYLOAD_STRING_1)
+	dialer.Conn.Expect(READ, RESPONSE_STRING+PAYLOAD_STRING_1)
 	dialer.Conn.Expect(EOF, "")
 	dialer.Conn.Expect(CLOSE, "")
 	defer dialer.Conn.EndTest()
 
 	requestUrl, _ := url.Parse("https://stream.twitter.com/1/statuses/filter.json")
 	conf := &Configuration{
-		Method: "GET",
-		URL: requestUrl,
+		Method:  "GET",
+		URL:     requestUrl,
 		Chunked: false,
-		GZip: false,
+		GZip:    false,
+		Dialer:  dialer,
 	}
 	cred := &twurlrc.Credentials{
-		Token: "token",
-		Username: "username",
-		ConsumerKey: "consumerkey",
+		Token:          "token",
+		Username:       "username",
+		ConsumerKey:    "consumerkey",
 		ConsumerSecret: "consumersecret",
-		Secret: "secret",
+		Secret:         "secret",
 	}
 	conn := NewConnection(conf, cred)
 	conn.fixedTime = "12345"
 	conn.fixedNonce = "54321"
-	conn.dialer = dialer
 	conn.Read()
 }
+
+// A connection which records writes and reads from a fixed response.
+type StubConnection struct {
+	io.Reader
+	Sent bytes.Buffer
+}
+
+func (c *StubConnection) Write(p []byte) (n int, err error) {
+	return c.Sent.Write(p)
+}
+
+func (c *StubConnection) Close() error {
+	return nil
+}
+
+type StubDialer struct {
+	Response string
+	Conn     *StubConnection
+}
+
+func (d *StubDialer) Dial(addr string) (io.ReadWriteCloser, error) {
+	d.Conn = &StubConnection{Reader: strings.NewReader(d.Response)}
+	return d.Conn, nil
+}
+
+func newStubConnection(conf *Configuration, response string) *Connection {
+	if conf.URL == nil {
+		conf.URL, _ = url.Parse("https://stream.twitter.com/1/statuses/sample.json")
+	}
+	if conf.Method == "" {
+		conf.Method = "GET"
+	}
+	cred := &twurlrc.Credentials{
+		Token:          "token",
+		ConsumerKey:    "consumerkey",
+		ConsumerSecret: "consumersecret",
+		Secret:         "secret",
+	}
+	if conf.Dialer == nil {
+		conf.Dialer = &StubDialer{Response: response}
+	}
+	return NewConnection(conf, cred)
+}
+
+func TestHandler(t *testing.T) {
+	var messages []string
+	conf := &Configuration{
+		Handler: HandlerFunc(func(msg []byte) error {
+			messages = append(messages, string(msg))
+			return nil
+		}),
+	}
+	response := "HTTP/1.1 200 OK\r\n\r\n" +
+		"{\"a\": 1}\r\n" +
+		"\r\n" +
+		"{\"b\": 2}\r\n"
+	conn := newStubConnection(conf, response)
+	if err := conn.Read(); err != io.EOF {
+		t.Fatalf("Expected EOF, got %v", err)
+	}
+	if len(messages) != 2 || messages[0] != "{\"a\": 1}" || messages[1] != "{\"b\": 2}" {
+		t.Errorf("Unexpected messages %q", messages)
+	}
+}
+
+func TestHandlerStopsStream(t *testing.T) {
+	stop := errors.New("stop")
+	count := 0
+	conf := &Configuration{
+		Chunked: true,
+		Handler: HandlerFunc(func(msg []byte) error {
+			count++
+			return stop
+		}),
+	}
+	response := "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n" +
+		"5\r\n{\"a\":\r\n" +
+		"5\r\n 1}\r\n\r\n" +
+		"a\r\n{\"b\": 2}\r\n\r\n"
+	conn := newStubConnection(conf, response)
+	if err := conn.Read(); err != stop {
+		t.Fatalf("Expected handler error, got %v", err)
+	}
+	if count != 1 {
+		t.Errorf("Expected 1 message, got %v", count)
+	}
+}
+
+// Parses the request sent over a StubConnection.
+func sentRequest(t *testing.T, conn *Connection) *http.Request {
+	stub := conn.conf.Dialer.(*StubDialer).Conn
+	req, err := http.ReadRequest(bufio.NewReader(&stub.Sent))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return req
+}
+
+func TestPostParams(t *testing.T) {
+	conf := &Configuration{
+		Method: "POST",
+		Params: url.Values{"track": {"twitter,golang"}},
+	}
+	conf.URL, _ = url.Parse("https://stream.twitter.com/1/statuses/filter.json?delimited=length")
+	conn := newStubConnection(conf, "HTTP/1.1 200 OK\r\n\r\n")
+	conn.Read()
+	req := sentRequest(t, conn)
+	if req.Header.Get("Content-Type") != "application/x-www-form-urlencoded" {
+		t.Errorf("Unexpected Content-Type %q", req.Header.Get("Content-Type"))
+	}
+	if err := req.ParseForm(); err != nil {
+		t.Fatal(err)
+	}
+	if req.PostForm.Get("track") != "twitter,golang" {
+		t.Errorf("Unexpected body params %v", req.PostForm)
+	}
+	if req.URL.Query().Get("delimited") != "length" {
+		t.Errorf("Unexpected query %v", req.URL.RawQuery)
+	}
+}
+
+func TestGetParams(t *testing.T) {
+	conf := &Configuration{
+		Params: url.Values{"stall_warnings": {"true"}},
+	}
+	conn := newStubConnection(conf, "HTTP/1.1 200 OK\r\n\r\n")
+	conn.Read()
+	req := sentRequest(t, conn)
+	if req.URL.Query().Get("stall_warnings") != "true" {
+		t.Errorf("Unexpected query %v", req.URL.RawQuery)
+	}
+	if req.ContentLength != 0 {
+		t.Errorf("Expected no body, got length %v", req.ContentLength)
+	}
+}
+
+// Dials one end of an in-memory pipe.  The server function is run against
+// the other end in a new goroutine.
+type PipeDialer struct {
+	Server func(conn net.Conn)
+}
+
+func (d *PipeDialer) Dial(addr string) (io.ReadWriteCloser, error) {
+	client, server := net.Pipe()
+	go func() {
+		defer server.Close()
+		d.Server(server)
+	}()
+	return client, nil
+}
+
+// Reads a request from conn and writes the given response.
+func respond(conn net.Conn, response string) error {
+	if _, err := http.ReadRequest(bufio.NewReader(conn)); err != nil {
+		return err
+	}
+	_, err := io.WriteString(conn, response)
+	return err
+}
+
+func TestReadIdleTimeout(t *testing.T) {
+	conf := &Configuration{
+		ReadIdleTimeout: 50 * time.Millisecond,
+		Handler: HandlerFunc(func(msg []byte) error {
+			return nil
+		}),
+	}
+	conf.Dialer = &PipeDialer{Server: func(server net.Conn) {
+		if err := respond(server, "HTTP/1.1 200 OK\r\n\r\n"); err != nil {
+			return
+		}
+		// Keepalives hold the connection open past the timeout.
+		for i := 0; i < 4; i++ {
+			time.Sleep(20 * time.Millisecond)
+			if _, err := io.WriteString(server, "\r\n"); err != nil {
+				return
+			}
+		}
+		io.Copy(io.Discard, server)
+	}}
+	conn := newStubConnection(conf, "")
+	start := time.Now()
+	if err := conn.Read(); err != ErrIdleTimeout {
+		t.Fatalf("Expected ErrIdleTimeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("Keepalives did not extend the connection, closed after %v", elapsed)
+	}
+}
+
+func TestTTL(t *testing.T) {
+	handler := &CollectingHandler{}
+	closed := make(chan bool)
+	conf := &Configuration{
+		TTL:     50 * time.Millisecond,
+		Handler: handler,
+	}
+	conf.Dialer = &PipeDialer{Server: func(server net.Conn) {
+		if err := respond(server, "HTTP/1.1 200 OK\r\n\r\n{\"a\": 1}\r\n"); err != nil {
+			return
+		}
+		// The stream is silent, so the TTL must be enforced by a timer.
+		io.Copy(io.Discard, server)
+		close(closed)
+	}}
+	conn := newStubConnection(conf, "")
+	start := time.Now()
+	if err := conn.Run(); err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("TTL was not enforced, returned after %v", elapsed)
+	}
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Errorf("Connection was not closed")
+	}
+	if len(handler.Messages) != 1 {
+		t.Errorf("Expected 1 message, got %q", handler.Messages)
+	}
+}
+
+// Collects the messages passed to a Handler.
+type CollectingHandler struct {
+	Messages []string
+}
+
+func (h *CollectingHandler) HandleMessage(msg []byte) error {
+	h.Messages = append(h.Messages, string(msg))
+	return nil
+}
+
+func TestDelimitedLength(t *testing.T) {
+	handler := &CollectingHandler{}
+	conf := &Configuration{
+		Delimited: true,
+		Handler:   handler,
+	}
+	first := "{\"text\": \"multi\nline\"}"
+	second := "{\"b\": 2}"
+	response := "HTTP/1.1 200 OK\r\n\r\n" +
+		fmt.Sprintf("%d\r\n%s\r\n", len(first)+2, first) +
+		"\r\n" +
+		fmt.Sprintf("%d\r\n%s\r\n", len(second)+2, second)
+	conn := newStubConnection(conf, response)
+	if err := conn.Read(); err != io.EOF {
+		t.Fatalf("Expected EOF, got %v", err)
+	}
+	if req := sentRequest(t, conn); req.URL.Query().Get("delimited") != "length" {
+		t.Errorf("Expected delimited parameter, got %q", req.URL.RawQuery)
+	}
+	if len(handler.Messages) != 2 || handler.Messages[0] != first || handler.Messages[1] != second {
+		t.Errorf("Unexpected messages %q", handler.Messages)
+	}
+}
+
+func TestDelimitedLengthBuffering(t *testing.T) {
+	handler := &CollectingHandler{}
+	conf := &Configuration{
+		Delimited: true,
+		Handler:   handler,
+	}
+	// Messages which fit in the read buffer are delivered in place, and
+	// longer ones are copied.
+	short := "{\"a\": 1}"
+	long := "{\"text\": \"" + strings.Repeat("x", 2*readBufferSize) + "\"}"
+	response := "HTTP/1.1 200 OK\r\n\r\n" +
+		fmt.Sprintf("%d\r\n%s\r\n", len(short)+2, short) +
+		fmt.Sprintf("%d\r\n%s\r\n", len(long)+2, long) +
+		fmt.Sprintf("%d\r\n%s\r\n", len(short)+2, short) +
+		fmt.Sprintf("%d\r\n%s", len(short)+2, short[:4])
+	conn := newStubConnection(conf, response)
+	if err := conn.Read(); err != io.ErrUnexpectedEOF {
+		t.Fatalf("Expected ErrUnexpectedEOF for truncated message, got %v", err)
+	}
+	if len(handler.Messages) != 3 || handler.Messages[0] != short ||
+		handler.Messages[1] != long || handler.Messages[2] != short {
+		t.Errorf("Expected 3 complete messages, got %v", len(handler.Messages))
+	}
+}
+
+func TestChunkedDelimitedLength(t *testing.T) {
+	handler := &CollectingHandler{}
+	conf := &Configuration{
+		Chunked: true,
+		Handler: handler,
+	}
+	body := "16\r\n{\"a\": \"12345\"}\r\n10\r\n{\"b\": 2}\r\n"
+	response := "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n" +
+		fmt.Sprintf("%x\r\n%s\r\n", 7, body[:7]) +
+		fmt.Sprintf("%x\r\n%s\r\n", len(body)-7, body[7:]) +
+		"0\r\n\r\n"
+	conn := newStubConnection(conf, response)
+	if err := conn.Read(); err != io.EOF {
+		t.Fatalf("Expected EOF, got %v", err)
+	}
+	expected := []string{"{\"a\": \"12345\"}", "{\"b\": 2}"}
+	if fmt.Sprint(handler.Messages) != fmt.Sprint(expected) {
+		t.Errorf("Expected %q, got %q", expected, handler.Messages)
+	}
+}
+
+func TestLongMessage(t *testing.T) {
+	handler := &CollectingHandler{}
+	conf := &Configuration{Handler: handler}
+	long := "{\"text\": \"" + strings.Repeat("x", 100000) + "\"}"
+	response := "HTTP/1.1 200 OK\r\n\r\n" + long + "\r\n{\"b\": 2}\r\n"
+	conn := newStubConnection(conf, response)
+	if err := conn.Read(); err != io.EOF {
+		t.Fatalf("Expected EOF, got %v", err)
+	}
+	if len(handler.Messages) != 2 || handler.Messages[0] != long || handler.Messages[1] != "{\"b\": 2}" {
+		t.Errorf("Expected 2 complete messages, got %v", len(handler.Messages))
+	}
+}
+
+func TestMessageTooLarge(t *testing.T) {
+	long := "{\"text\": \"" + strings.Repeat("x", 10000) + "\"}"
+	responses := []string{
+		"HTTP/1.1 200 OK\r\n\r\n" + long + "\r\n",
+		"HTTP/1.1 200 OK\r\n\r\n" + fmt.Sprintf("%d\r\n%s\r\n", len(long)+2, long),
+	}
+	for _, response := range responses {
+		handler := &CollectingHandler{}
+		conf := &Configuration{
+			MaxMessageSize: 5000,
+			Handler:        handler,
+		}
+		conn := newStubConnection(conf, response)
+		err := conn.Read()
+		var tooLarge *MessageTooLargeError
+		if !errors.As(err, &tooLarge) || tooLarge.Limit != 5000 {
+			t.Errorf("Expected MessageTooLargeError, got %v", err)
+		}
+		if len(handler.Messages) != 0 {
+			t.Errorf("Expected no messages, got %v", len(handler.Messages))
+		}
+	}
+}
+
+func TestReadContextCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	conf := &Configuration{
+		Handler: HandlerFunc(func(msg []byte) error {
+			cancel()
+			return nil
+		}),
+	}
+	conf.Dialer = &PipeDialer{Server: func(server net.Conn) {
+		if err := respond(server, "HTTP/1.1 200 OK\r\n\r\n{\"a\": 1}\r\n"); err != nil {
+			return
+		}
+		io.Copy(io.Discard, server)
+	}}
+	conn := newStubConnection(conf, "")
+	if err := conn.ReadContext(ctx); err != context.Canceled {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+}
+
+func TestNetDialerTLSConfig(t *testing.T) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+	addr := server.Listener.Addr().String()
+
+	if conn, err := (&NetDialer{}).Dial(addr); err == nil {
+		conn.Close()
+		t.Fatal("Expected untrusted certificate to be rejected")
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+	dialer := &NetDialer{TLSConfig: &tls.Config{
+		RootCAs:    roots,
+		ServerName: "example.com",
+	}}
+	conn, err := dialer.Dial(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
+
+func TestAddress(t *testing.T) {
+	cases := map[string]string{
+		"https://stream.twitter.com/1/statuses/sample.json": "stream.twitter.com:443",
+		"http://localhost/stream":                           "localhost:80",
+		"https://localhost:8443/stream":                     "localhost:8443",
+	}
+	for rawurl, expected := range cases {
+		conn := &Connection{conf: &Configuration{}}
+		conn.conf.URL, _ = url.Parse(rawurl)
+		if addr := conn.address(); addr != expected {
+			t.Errorf("Expected %v for %v, got %v", expected, rawurl, addr)
+		}
+	}
+}
+
+// Serves a single CONNECT request on listener, requiring the given
+// Proxy-Authorization value, and tunnels it to the requested address.
+func serveConnectProxy(t *testing.T, listener net.Listener, auth string) {
+	conn, err := listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	req, err := http.ReadRequest(bufio.NewReader(conn))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if req.Method != "CONNECT" {
+		t.Errorf("Expected CONNECT, got %v", req.Method)
+	}
+	if req.Header.Get("Proxy-Authorization") != auth {
+		io.WriteString(conn, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+		return
+	}
+	upstream, err := net.Dial("tcp", req.Host)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer upstream.Close()
+	io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+	go io.Copy(upstream, conn)
+	io.Copy(conn, upstream)
+}
+
+func TestNetDialerProxy(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "tunneled")
+	}))
+	defer server.Close()
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	// "user:secret" in base64.
+	go serveConnectProxy(t, listener, "Basic dXNlcjpzZWNyZXQ=")
+
+	dialer := &NetDialer{
+		Proxy: "http://user:secret@" + listener.Addr().String(),
+		TLSConfig: &tls.Config{
+			RootCAs:    roots,
+			ServerName: "example.com",
+		},
+	}
+	conn, err := dialer.Dial(server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	req, _ := http.NewRequest("GET", "https://example.com/", nil)
+	req.Write(conn)
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "tunneled" {
+		t.Errorf("Unexpected body %q", body)
+	}
+}
+
+func TestNetDialerProxyRejected(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go serveConnectProxy(t, listener, "Basic dXNlcjpzZWNyZXQ=")
+
+	dialer := &NetDialer{Proxy: listener.Addr().String()}
+	conn, err := dialer.Dial("stream.twitter.com:443")
+	if err == nil {
+		conn.Close()
+		t.Fatal("Expected proxy to reject unauthenticated CONNECT")
+	}
+	if !strings.Contains(err.Error(), "407") {
+		t.Errorf("Expected 407 error, got %v", err)
+	}
+}
+
+func TestDeflateEncoding(t *testing.T) {
+	payload := "{\"a\": 1}\r\n{\"b\": 2}\r\n"
+	var zlibbed, raw bytes.Buffer
+	z := zlib.NewWriter(&zlibbed)
+	io.WriteString(z, payload)
+	z.Close()
+	f, _ := flate.NewWriter(&raw, flate.DefaultCompression)
+	io.WriteString(f, payload)
+	f.Close()
+
+	for _, body := range []string{zlibbed.String(), raw.String()} {
+		handler := &CollectingHandler{}
+		conf := &Configuration{
+			GZip:    true,
+			Handler: handler,
+		}
+		response := "HTTP/1.1 200 OK\r\nContent-Encoding: deflate\r\n\r\n" + body
+		conn := newStubConnection(conf, response)
+		if err := conn.Read(); err != io.EOF && err != io.ErrUnexpectedEOF {
+			t.Fatalf("Expected EOF, got %v", err)
+		}
+		expected := []string{"{\"a\": 1}", "{\"b\": 2}"}
+		if fmt.Sprint(handler.Messages) != fmt.Sprint(expected) {
+			t.Errorf("Expected %q, got %q", expected, handler.Messages)
+		}
+	}
+}
+
+func TestGZipEncodingNotUsed(t *testing.T) {
+	handler := &CollectingHandler{}
+	conf := &Configuration{
+		GZip:    true,
+		Handler: handler,
+	}
+	conn := newStubConnection(conf, "HTTP/1.1 200 OK\r\n\r\n{\"a\": 1}\r\n")
+	if err := conn.Read(); err != io.EOF {
+		t.Fatalf("Expected EOF, got %v", err)
+	}
+	if len(handler.Messages) != 1 || handler.Messages[0] != "{\"a\": 1}" {
+		t.Errorf("Unexpected messages %q", handler.Messages)
+	}
+	if !conf.GZip {
+		t.Errorf("Configuration should not be modified by the response")
+	}
+}
+
+func TestContentEncodingNegotiation(t *testing.T) {
+	payload := "{\"a\": 1}\r\n"
+	var gzipped bytes.Buffer
+	z := gzip.NewWriter(&gzipped)
+	io.WriteString(z, payload)
+	z.Close()
+	cases := []struct {
+		gzip     bool
+		encoding string
+		body     string
+		ok       bool
+	}{
+		{false, "gzip", gzipped.String(), true},
+		{true, "x-gzip", gzipped.String(), true},
+		{true, "identity", payload, true},
+		{false, "", payload, true},
+		{true, "br", payload, false},
+		{true, "gzip, deflate", payload, false},
+	}
+	for _, c := range cases {
+		handler := &CollectingHandler{}
+		conf := &Configuration{
+			GZip:    c.gzip,
+			Handler: handler,
+		}
+		header := ""
+		if c.encoding != "" {
+			header = "Content-Encoding: " + c.encoding + "\r\n"
+		}
+		conn := newStubConnection(conf, "HTTP/1.1 200 OK\r\n"+header+"\r\n"+c.body)
+		err := conn.Read()
+		if !c.ok {
+			if err == io.EOF || err == nil {
+				t.Errorf("Encoding %q: expected error, got %v", c.encoding, err)
+			}
+			continue
+		}
+		if err != io.EOF {
+			t.Errorf("Encoding %q: expected EOF, got %v", c.encoding, err)
+		}
+		if len(handler.Messages) != 1 || handler.Messages[0] != "{\"a\": 1}" {
+			t.Errorf("Encoding %q: unexpected messages %q", c.encoding, handler.Messages)
+		}
+	}
+}
+
+// Encodes body using transfer-encoding: chunked, with chunks of the given
+// size.
+func chunk(body string, size int) string {
+	var out bytes.Buffer
+	for len(body) > 0 {
+		n := size
+		if n > len(body) {
+			n = len(body)
+		}
+		fmt.Fprintf(&out, "%x\r\n%s\r\n", n, body[:n])
+		body = body[n:]
+	}
+	out.WriteString("0\r\n\r\n")
+	return out.String()
+}
+
+func TestChunkedGZip(t *testing.T) {
+	var expected []string
+	var payload bytes.Buffer
+	for i := 0; i < 20; i++ {
+		msg := fmt.Sprintf("{\"id\": %d, \"text\": \"%s\"}", i, strings.Repeat("x", 100*i))
+		expected = append(expected, msg)
+		payload.WriteString(msg + "\r\n")
+		if i%5 == 0 {
+			payload.WriteString("\r\n")
+		}
+	}
+	var compressed bytes.Buffer
+	z := gzip.NewWriter(&compressed)
+	z.Write(payload.Bytes())
+	z.Close()
+
+	for _, size := range []int{1, 7, 512, 4096} {
+		handler := &CollectingHandler{}
+		conf := &Configuration{
+			Chunked: true,
+			GZip:    true,
+			Handler: handler,
+		}
+		response := "HTTP/1.1 200 OK\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"Content-Encoding: gzip\r\n\r\n" +
+			chunk(compressed.String(), size)
+		conn := newStubConnection(conf, response)
+		if err := conn.Read(); err != io.EOF {
+			t.Fatalf("Chunk size %v: expected EOF, got %v", size, err)
+		}
+		if fmt.Sprint(handler.Messages) != fmt.Sprint(expected) {
+			t.Errorf("Chunk size %v: got %v messages, expected %v", size, len(handler.Messages), len(expected))
+		}
+	}
+}
+
+func TestChunkedGZipMembers(t *testing.T) {
+	// Each write is compressed as a separate gzip member, and the second
+	// message is split across members.
+	writes := []string{"{\"a\": 1}\r\n{\"b\"", ": 2}\r\n", "\r\n", "{\"c\": 3}\r\n"}
+	var compressed bytes.Buffer
+	for _, write := range writes {
+		z := gzip.NewWriter(&compressed)
+		io.WriteString(z, write)
+		z.Close()
+	}
+	expected := []string{"{\"a\": 1}", "{\"b\": 2}", "{\"c\": 3}"}
+	for _, size := range []int{1, 13, 4096} {
+		handler := &CollectingHandler{}
+		conf := &Configuration{
+			Chunked: true,
+			GZip:    true,
+			Handler: handler,
+		}
+		response := "HTTP/1.1 200 OK\r\n" +
+			"Transfer-Encoding: chunked\r\n" +
+			"Content-Encoding: gzip\r\n\r\n" +
+			chunk(compressed.String(), size)
+		conn := newStubConnection(conf, response)
+		if err := conn.Read(); err != io.EOF {
+			t.Fatalf("Chunk size %v: expected EOF, got %v", size, err)
+		}
+		if fmt.Sprint(handler.Messages) != fmt.Sprint(expected) {
+			t.Errorf("Chunk size %v: expected %q, got %q", size, expected, handler.Messages)
+		}
+	}
+}
+
+func TestChunkedSplitLines(t *testing.T) {
+	payload := "{\"a\": 1}\r\n\r\n{\"b\": 2}\r\n{\"c\": 3}\r\n"
+	expected := []string{"{\"a\": 1}", "{\"b\": 2}", "{\"c\": 3}"}
+	for size := 1; size <= len(payload); size++ {
+		handler := &CollectingHandler{}
+		conf := &Configuration{
+			Chunked: true,
+			Handler: handler,
+		}
+		response := "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n" +
+			chunk(payload, size)
+		conn := newStubConnection(conf, response)
+		if err := conn.Read(); err != io.EOF {
+			t.Fatalf("Chunk size %v: expected EOF, got %v", size, err)
+		}
+		if fmt.Sprint(handler.Messages) != fmt.Sprint(expected) {
+			t.Errorf("Chunk size %v: expected %q, got %q", size, expected, handler.Messages)
+		}
+	}
+}
+
+func TestChunkExtensionsAndTrailers(t *testing.T) {
+	handler := &CollectingHandler{}
+	conf := &Configuration{
+		Chunked: true,
+		Handler: handler,
+	}
+	response := "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n" +
+		"5;name=value\r\n{\"a\":\r\n" +
+		"5\r\n 1}\r\n\r\n" +
+		"0\r\n" +
+		"X-Stream-End: shutdown\r\n\r\n"
+	conn := newStubConnection(conf, response)
+	if err := conn.Read(); err != io.EOF {
+		t.Fatalf("Expected EOF, got %v", err)
+	}
+	if len(handler.Messages) != 1 || handler.Messages[0] != "{\"a\": 1}" {
+		t.Errorf("Unexpected messages %q", handler.Messages)
+	}
+	if conn.Trailer().Get("X-Stream-End") != "shutdown" {
+		t.Errorf("Unexpected trailer %v", conn.Trailer())
+	}
+}
+
+func TestChunkedMalformedSize(t *testing.T) {
+	conf := &Configuration{
+		Chunked: true,
+		Handler: &CollectingHandler{},
+	}
+	response := "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n" +
+		"zz\r\n{\"a\": 1}\r\n"
+	conn := newStubConnection(conf, response)
+	if err := conn.Read(); err == nil || err == io.EOF {
+		t.Fatalf("Expected malformed chunk error, got %v", err)
+	}
+}
+
+func TestConfigurationDialer(t *testing.T) {
+	var dialed []string
+	handler := &CollectingHandler{}
+	conf := &Configuration{
+		Handler: handler,
+		Dialer: DialerFunc(func(addr string) (io.ReadWriteCloser, error) {
+			dialed = append(dialed, addr)
+			return &StubConnection{
+				Reader: strings.NewReader("HTTP/1.1 200 OK\r\n\r\n{\"a\": 1}\r\n"),
+			}, nil
+		}),
+	}
+	conn := newStubConnection(conf, "")
+	if err := conn.Read(); err != io.EOF {
+		t.Fatalf("Expected EOF, got %v", err)
+	}
+	if len(dialed) != 1 || dialed[0] != "stream.twitter.com:443" {
+		t.Errorf("Unexpected dials %v", dialed)
+	}
+	if len(handler.Messages) != 1 {
+		t.Errorf("Expected 1 message, got %v", handler.Messages)
+	}
+}
+
+func TestNetDialerHandshakeTimeout(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		// Accept connections but never complete a handshake.
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	dialer := &NetDialer{
+		Timeout:             time.Second,
+		TLSHandshakeTimeout: 50 * time.Millisecond,
+	}
+	start := time.Now()
+	if conn, err := dialer.Dial(listener.Addr().String()); err == nil {
+		conn.Close()
+		t.Fatal("Expected handshake to time out")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Handshake timeout was not enforced, returned after %v", elapsed)
+	}
+}
+
+func TestReadTimeout(t *testing.T) {
+	conf := &Configuration{
+		ReadTimeout: 50 * time.Millisecond,
+		Handler:     &CollectingHandler{},
+	}
+	conf.Dialer = &PipeDialer{Server: func(server net.Conn) {
+		if err := respond(server, "HTTP/1.1 200 OK\r\n\r\n"); err != nil {
+			return
+		}
+		io.Copy(io.Discard, server)
+	}}
+	conn := newStubConnection(conf, "")
+	if err := conn.Read(); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("Expected os.ErrDeadlineExceeded, got %v", err)
+	}
+}
+
+func TestHeaders(t *testing.T) {
+	conf := &Configuration{
+		Headers: http.Header{
+			"X-Trace-Id":      {"abc"},
+			"X-Forwarded-For": {"10.0.0.1", "10.0.0.2"},
+			"User-Agent":      {"twstream-test"},
+		},
+	}
+	conn := newStubConnection(conf, "HTTP/1.1 200 OK\r\n\r\n")
+	conn.Read()
+	req := sentRequest(t, conn)
+	if req.Header.Get("X-Trace-Id") != "abc" {
+		t.Errorf("Expected X-Trace-Id header, got %v", req.Header)
+	}
+	if forwarded := req.Header["X-Forwarded-For"]; len(forwarded) != 2 {
+		t.Errorf("Expected 2 X-Forwarded-For values, got %q", forwarded)
+	}
+	if agent := req.UserAgent(); agent != "twstream-test" {
+		t.Errorf("Expected User-Agent to be replaced, got %q", agent)
+	}
+	if req.Header.Get("Authorization") == "" {
+		t.Errorf("Request was not signed")
+	}
+}
+
+// Serves a response whose messages all arrive in a single read, followed by
+// an incomplete message, then waits for the client to close.
+func bufferedServer(server net.Conn) {
+	response := "HTTP/1.1 200 OK\r\n\r\n" +
+		"{\"a\": 1}\r\n{\"b\": 2}\r\n{\"c\": 3}\r\n{\"d\""
+	if err := respond(server, response); err != nil {
+		return
+	}
+	io.Copy(io.Discard, server)
+}
+
+func TestCloseDrain(t *testing.T) {
+	var conn *Connection
+	handler := &CollectingHandler{}
+	conf := &Configuration{
+		DrainTimeout: time.Second,
+		Dialer:       &PipeDialer{Server: bufferedServer},
+		Handler: HandlerFunc(func(msg []byte) error {
+			if len(handler.Messages) == 0 {
+				conn.Close()
+			}
+			return handler.HandleMessage(msg)
+		}),
+	}
+	conn = newStubConnection(conf, "")
+	if err := conn.Run(); err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+	expected := []string{`{"a": 1}`, `{"b": 2}`, `{"c": 3}`}
+	if strings.Join(handler.Messages, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected %q, got %q", expected, handler.Messages)
+	}
+	if err := conn.Run(); err != nil {
+		t.Errorf("Expected nil after Close, got %v", err)
+	}
+}
+
+func TestCloseWithoutDrain(t *testing.T) {
+	var conn *Connection
+	handler := &CollectingHandler{}
+	conf := &Configuration{
+		Dialer: &PipeDialer{Server: bufferedServer},
+		Handler: HandlerFunc(func(msg []byte) error {
+			conn.Close()
+			return handler.HandleMessage(msg)
+		}),
+	}
+	conn = newStubConnection(conf, "")
+	if err := conn.Run(); err != nil {
+		t.Fatalf("Expected nil, got %v", err)
+	}
+	if len(handler.Messages) != 1 {
+		t.Errorf("Expected 1 message, got %q", handler.Messages)
+	}
+}
+
+func TestCloseWhileWaiting(t *testing.T) {
+	conf := &Configuration{
+		Backoff:         &ExponentialBackoff{Initial: time.Hour, Max: time.Hour},
+		LifecycleEvents: true,
+		Dialer: &PipeDialer{Server: func(server net.Conn) {
+			respond(server, "HTTP/1.1 503 Unavailable\r\n\r\n")
+		}},
+		Handler: &CollectingHandler{},
+	}
+	conn := newStubConnection(conf, "")
+	conf.EventHandler = EventHandlerFunc(func(event Event) {
+		if _, ok := event.(*Reconnecting); ok {
+			go conn.Close()
+		}
+	})
+	done := make(chan error)
+	go func() { done <- conn.Run() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expected nil, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Close did not stop Run")
+	}
+}
+
+func TestResponse(t *testing.T) {
+	conf := &Configuration{Handler: &CollectingHandler{}}
+	response := "HTTP/1.1 200 OK\r\nX-Connection-Hash: abc123\r\n" +
+		"Content-Encoding: identity\r\n\r\n{\"a\": 1}\r\n"
+	conn := newStubConnection(conf, response)
+	if conn.Response() != nil {
+		t.Fatalf("Expected no response before connecting")
+	}
+	if err := conn.Read(); err != io.EOF {
+		t.Fatalf("Expected EOF, got %v", err)
+	}
+	r := conn.Response()
+	if r == nil {
+		t.Fatalf("Expected a response")
+	}
+	if r.StatusCode != 200 || r.Status != "200 OK" {
+		t.Errorf("Unexpected status %v %q", r.StatusCode, r.Status)
+	}
+	if r.Header.Get("X-Connection-Hash") != "abc123" {
+		t.Errorf("Unexpected headers %v", r.Header)
+	}
+}
+
+func TestResponseErrorStatus(t *testing.T) {
+	conf := &Configuration{Handler: &CollectingHandler{}}
+	response := "HTTP/1.1 429 Too Many Requests\r\nX-Rate-Limit-Remaining: 0\r\n\r\n"
+	conn := newStubConnection(conf, response)
+	if err := conn.Read(); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("Expected ErrRateLimited, got %v", err)
+	}
+	r := conn.Response()
+	if r == nil || r.StatusCode != 429 {
+		t.Fatalf("Unexpected response %+v", r)
+	}
+	if r.Header.Get("X-Rate-Limit-Remaining") != "0" {
+		t.Errorf("Unexpected headers %v", r.Header)
+	}
+}
+
+func TestNetDialerResolver(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "resolved")
+	}))
+	defer server.Close()
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+
+	// Nothing listens on 127.0.0.2, so the dialer must fail over.
+	dialer := &NetDialer{
+		Resolver:  StaticResolver{"example.com": {"127.0.0.2", "127.0.0.1"}},
+		TLSConfig: &tls.Config{RootCAs: roots},
+	}
+	conn, err := dialer.Dial(net.JoinHostPort("example.com", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	req, _ := http.NewRequest("GET", "https://example.com/", nil)
+	req.Write(conn)
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "resolved" {
+		t.Errorf("Unexpected body %q", body)
+	}
+}
+
+func TestNetDialerResolverNotFound(t *testing.T) {
+	dialer := &NetDialer{Resolver: StaticResolver{}}
+	_, err := dialer.Dial("stream.twitter.com:443")
+	var dnsErr *net.DNSError
+	if !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+		t.Fatalf("Expected host not found, got %v", err)
+	}
+	if Classify(err) != ClassNetwork {
+		t.Errorf("Expected a network error, got %v", Classify(err))
+	}
+}
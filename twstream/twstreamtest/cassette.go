@@ -0,0 +1,204 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstreamtest
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/kurrik/golibs/twstream"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Returned by Cassette.Dial once every recorded connection has been played.
+var ErrCassetteEnded = errors.New("No more recorded connections")
+
+// Data received over a recorded connection.  Conn numbers the connections
+// in the order they were dialed, from 0, and Offset is the time since the
+// connection was dialed.  Sent data is not recorded, since requests carry
+// credentials, and frames with Sent set are ignored by Cassette.
+type Frame struct {
+	Conn   int           `json:"conn"`
+	Sent   bool          `json:"sent,omitempty"`
+	Offset time.Duration `json:"offset"`
+	Data   []byte        `json:"data"`
+}
+
+// A Dialer which records everything read from the connections opened by
+// another Dialer to w, as a Frame per line of JSON.  Use it with a live
+// stream to capture traffic for a Cassette.  Errors writing to w are
+// returned by Read.
+type RecordingDialer struct {
+	Dialer  twstream.Dialer
+	lock    sync.Mutex
+	encoder *json.Encoder
+	conns   int
+}
+
+// Returns a RecordingDialer which records the connections opened by dialer
+// to w.  If dialer is nil, a twstream.NetDialer is used.
+func NewRecordingDialer(dialer twstream.Dialer, w io.Writer) *RecordingDialer {
+	if dialer == nil {
+		dialer = &twstream.NetDialer{}
+	}
+	return &RecordingDialer{Dialer: dialer, encoder: json.NewEncoder(w)}
+}
+
+func (d *RecordingDialer) Dial(addr string) (io.ReadWriteCloser, error) {
+	conn, err := d.Dialer.Dial(addr)
+	if err != nil {
+		return nil, err
+	}
+	d.lock.Lock()
+	id := d.conns
+	d.conns++
+	d.lock.Unlock()
+	return &recordingConn{ReadWriteCloser: conn, dialer: d, id: id, start: time.Now()}, nil
+}
+
+func (d *RecordingDialer) record(frame *Frame) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.encoder.Encode(frame)
+}
+
+type recordingConn struct {
+	io.ReadWriteCloser
+	dialer *RecordingDialer
+	id     int
+	start  time.Time
+}
+
+func (c *recordingConn) Read(p []byte) (n int, err error) {
+	n, err = c.ReadWriteCloser.Read(p)
+	if n > 0 {
+		frame := &Frame{Conn: c.id, Offset: time.Since(c.start), Data: p[:n]}
+		if rerr := c.dialer.record(frame); rerr != nil && err == nil {
+			err = rerr
+		}
+	}
+	return n, err
+}
+
+// Replays recorded connections.  Each call to Dial returns the next
+// recorded connection, which ignores writes and returns the received data
+// with the same read boundaries as when it was recorded, then io.EOF.  Unless
+// RealTime is set, data is returned without the recorded delays, so
+// replays are deterministic.
+type Cassette struct {
+	Frames   []Frame
+	RealTime bool
+	lock     sync.Mutex
+	conns    int
+}
+
+// Reads a cassette written by a RecordingDialer.
+func LoadCassette(r io.Reader) (*Cassette, error) {
+	cassette := &Cassette{}
+	decoder := json.NewDecoder(r)
+	for {
+		frame := Frame{}
+		err := decoder.Decode(&frame)
+		if err == io.EOF {
+			return cassette, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		cassette.Frames = append(cassette.Frames, frame)
+	}
+}
+
+// Reads a cassette from the named file.
+func OpenCassette(path string) (*Cassette, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return LoadCassette(file)
+}
+
+// Returns the next recorded connection, or ErrCassetteEnded.
+func (c *Cassette) Dial(addr string) (io.ReadWriteCloser, error) {
+	c.lock.Lock()
+	id := c.conns
+	c.conns++
+	c.lock.Unlock()
+	conn := &playbackConn{
+		realTime: c.RealTime,
+		start:    time.Now(),
+		closed:   make(chan struct{}),
+	}
+	found := false
+	for _, frame := range c.Frames {
+		if frame.Conn != id {
+			continue
+		}
+		found = true
+		if !frame.Sent {
+			conn.frames = append(conn.frames, frame)
+		}
+	}
+	if !found {
+		return nil, ErrCassetteEnded
+	}
+	return conn, nil
+}
+
+type playbackConn struct {
+	frames    []Frame
+	pending   []byte
+	realTime  bool
+	start     time.Time
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (c *playbackConn) Read(p []byte) (int, error) {
+	if len(c.pending) == 0 {
+		if len(c.frames) == 0 {
+			return 0, io.EOF
+		}
+		frame := c.frames[0]
+		c.frames = c.frames[1:]
+		if c.realTime {
+			timer := time.NewTimer(frame.Offset - time.Since(c.start))
+			select {
+			case <-timer.C:
+			case <-c.closed:
+				timer.Stop()
+				return 0, io.ErrClosedPipe
+			}
+		}
+		c.pending = frame.Data
+	}
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+func (c *playbackConn) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (c *playbackConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+	})
+	return nil
+}
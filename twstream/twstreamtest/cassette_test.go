@@ -0,0 +1,91 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstreamtest
+
+import (
+	"bytes"
+	"github.com/kurrik/golibs/twstream"
+	"github.com/kurrik/golibs/twurlrc"
+	"io"
+	"testing"
+)
+
+func readAll(t *testing.T, dialer twstream.Dialer) []string {
+	var messages []string
+	conf := &twstream.Configuration{
+		Dialer: dialer,
+		Handler: twstream.HandlerFunc(func(msg []byte) error {
+			messages = append(messages, string(msg))
+			return nil
+		}),
+	}
+	conn := twstream.NewSampleStream(&twurlrc.Credentials{}, conf)
+	if err := conn.Read(); err != io.EOF {
+		t.Fatalf("Expected EOF, got %v", err)
+	}
+	return messages
+}
+
+func TestCassette(t *testing.T) {
+	server := NewServer(Response{
+		GZip:     true,
+		Messages: []string{`{"id_str": "1"}`, "", `{"id_str": "2"}`},
+	})
+	defer server.Close()
+	var tape bytes.Buffer
+	recorded := readAll(t, NewRecordingDialer(server, &tape))
+	if len(recorded) != 2 {
+		t.Fatalf("Expected 2 recorded messages, got %q", recorded)
+	}
+	cassette, err := LoadCassette(&tape)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, frame := range cassette.Frames {
+		if frame.Sent || bytes.Contains(frame.Data, []byte("Authorization")) {
+			t.Errorf("Expected requests not to be recorded, got %q", frame.Data)
+		}
+	}
+	if len(cassette.Frames) == 0 {
+		t.Errorf("Expected received frames")
+	}
+	replayed := readAll(t, cassette)
+	if len(replayed) != len(recorded) || replayed[0] != recorded[0] || replayed[1] != recorded[1] {
+		t.Errorf("Expected %q, got %q", recorded, replayed)
+	}
+	if _, err := cassette.Dial("stream.twitter.com:443"); err != ErrCassetteEnded {
+		t.Errorf("Expected ErrCassetteEnded, got %v", err)
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, io.ErrShortWrite
+}
+
+func TestRecordingDialerWriteError(t *testing.T) {
+	server := NewServer(Response{Messages: []string{`{"id_str": "1"}`}})
+	defer server.Close()
+	conn, err := NewRecordingDialer(server, failingWriter{}).Dial("stream.twitter.com:443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "GET /1/statuses/sample.json HTTP/1.1\r\nHost: stream.twitter.com\r\n\r\n")
+	if _, err := conn.Read(make([]byte, 512)); err != io.ErrShortWrite {
+		t.Errorf("Expected the recording error, got %v", err)
+	}
+}
@@ -0,0 +1,184 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package twstreamtest provides a scripted streaming server, so that
+// applications using twstream can be tested without connecting to Twitter.
+package twstreamtest
+
+import (
+	"compress/gzip"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+)
+
+// The scripted reply to a single connection.
+type Response struct {
+	// The response status.  Defaults to 200.
+	StatusCode int
+	// Headers added to the response.
+	Header http.Header
+	// Sent in order, each followed by \r\n and flushed separately, so
+	// chunked responses send one message per chunk.  An empty string sends
+	// a blank keepalive line.
+	Messages []string
+	// Compresses the body with gzip, setting Content-Encoding.
+	GZip bool
+	// Waits this long before sending each message.
+	Interval time.Duration
+	// Keeps the connection open after the last message until the client
+	// disconnects or the server is closed, as a live stream would.
+	Hold bool
+}
+
+// A streaming server which replies to successive connections with
+// successive Responses.  Connections after the last scripted Response are
+// answered with 503 Service Unavailable.
+//
+// Responses are chunked, unless the request asks for the connection to be
+// closed, as twstream does when Configuration.Chunked is false, in which
+// case the body is sent unframed.
+type Server struct {
+	*httptest.Server
+	lock      sync.Mutex
+	responses []Response
+	requests  []*http.Request
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// Starts a Server replying with the given responses.  The server does not
+// use TLS, so set a Configuration's Dialer to the Server to reach it through
+// any URL, such as twstream.SampleURL, or set its HTTPClient and point its
+// URL at Server.URL.
+func NewServer(responses ...Response) *Server {
+	s := &Server{
+		responses: responses,
+		closed:    make(chan struct{}),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// Connects to the server whatever addr is, so the Server can be used as a
+// twstream.Dialer.
+func (s *Server) Dial(addr string) (io.ReadWriteCloser, error) {
+	return net.Dial("tcp", s.Listener.Addr().String())
+}
+
+// Returns the requests received so far.  Form values, including those sent
+// in a POST body, have been parsed into each request's Form.
+func (s *Server) Requests() []*http.Request {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]*http.Request(nil), s.requests...)
+}
+
+// Closes held connections and shuts the server down.
+func (s *Server) Close() {
+	s.closeOnce.Do(func() {
+		close(s.closed)
+	})
+	s.Server.Close()
+}
+
+// Records the request and returns the response scripted for it.
+func (s *Server) next(r *http.Request) (Response, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	index := len(s.requests)
+	s.requests = append(s.requests, r)
+	if index >= len(s.responses) {
+		return Response{}, false
+	}
+	return s.responses[index], true
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	response, ok := s.next(r)
+	if !ok {
+		http.Error(w, "No more scripted responses", http.StatusServiceUnavailable)
+		return
+	}
+	for key, values := range response.Header {
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+	if r.Close {
+		// Disables chunking; the connection is closed after the body.
+		w.Header().Set("Transfer-Encoding", "identity")
+	}
+	var body io.Writer = w
+	flush := func() {
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+	if response.GZip {
+		w.Header().Set("Content-Encoding", "gzip")
+		z := gzip.NewWriter(w)
+		defer z.Close()
+		body = z
+		flush = func() {
+			z.Flush()
+			if f, ok := w.(http.Flusher); ok {
+				f.Flush()
+			}
+		}
+	}
+	status := response.StatusCode
+	if status == 0 {
+		status = http.StatusOK
+	}
+	w.WriteHeader(status)
+	flush()
+	for _, msg := range response.Messages {
+		if !s.wait(r, response.Interval) {
+			return
+		}
+		if _, err := io.WriteString(body, msg+"\r\n"); err != nil {
+			return
+		}
+		flush()
+	}
+	if response.Hold {
+		s.wait(r, -1)
+	}
+}
+
+// Waits for delay, or indefinitely if delay is negative, reporting false if
+// the client disconnected or the server was closed first.
+func (s *Server) wait(r *http.Request, delay time.Duration) bool {
+	if delay == 0 {
+		return true
+	}
+	var timeout <-chan time.Time
+	if delay > 0 {
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case <-timeout:
+		return true
+	case <-r.Context().Done():
+	case <-s.closed:
+	}
+	return false
+}
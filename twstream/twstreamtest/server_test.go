@@ -0,0 +1,109 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstreamtest
+
+import (
+	"errors"
+	"github.com/kurrik/golibs/twstream"
+	"github.com/kurrik/golibs/twurlrc"
+	"io"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestServer(t *testing.T) {
+	stop := errors.New("stop")
+	server := NewServer(
+		Response{StatusCode: 503},
+		Response{
+			GZip:     true,
+			Messages: []string{`{"id_str": "1"}`, "", `{"id_str": "2"}`},
+			Hold:     true,
+		},
+	)
+	defer server.Close()
+	var messages []string
+	conf := &twstream.Configuration{
+		Backoff: &twstream.Backoff{HTTPInitial: time.Millisecond, HTTPMax: time.Millisecond},
+		Params:  url.Values{"track": {"golang"}},
+		Dialer:  server,
+		Handler: twstream.HandlerFunc(func(msg []byte) error {
+			messages = append(messages, string(msg))
+			if len(messages) == 2 {
+				return stop
+			}
+			return nil
+		}),
+	}
+	conn := twstream.NewSampleStream(&twurlrc.Credentials{}, conf)
+	if err := conn.Run(); err != stop {
+		t.Fatalf("Expected handler error, got %v", err)
+	}
+	if len(messages) != 2 || messages[0] != `{"id_str": "1"}` || messages[1] != `{"id_str": "2"}` {
+		t.Errorf("Unexpected messages %q", messages)
+	}
+	requests := server.Requests()
+	if len(requests) != 2 {
+		t.Fatalf("Expected 2 requests, got %v", len(requests))
+	}
+	if requests[1].URL.Path != "/1.1/statuses/sample.json" || requests[1].Form.Get("track") != "golang" {
+		t.Errorf("Unexpected request %v %v", requests[1].URL, requests[1].Form)
+	}
+}
+
+func TestServerUnchunked(t *testing.T) {
+	server := NewServer(Response{Messages: []string{`{"a": 1}`, `{"b": 2}`}})
+	defer server.Close()
+	var messages []string
+	conf := &twstream.Configuration{
+		Method: "GET",
+		Dialer: server,
+		Handler: twstream.HandlerFunc(func(msg []byte) error {
+			messages = append(messages, string(msg))
+			return nil
+		}),
+	}
+	conf.URL, _ = url.Parse(twstream.SampleURL)
+	conn := twstream.NewConnection(conf, &twurlrc.Credentials{})
+	if err := conn.Read(); err != io.EOF {
+		t.Fatalf("Expected EOF, got %v", err)
+	}
+	if len(messages) != 2 {
+		t.Errorf("Expected 2 messages, got %q", messages)
+	}
+}
+
+func TestServerHTTPClient(t *testing.T) {
+	server := NewServer(Response{Messages: []string{`{"a": 1}`}})
+	defer server.Close()
+	var messages []string
+	conf := &twstream.Configuration{
+		Method:     "GET",
+		HTTPClient: server.Client(),
+		Handler: twstream.HandlerFunc(func(msg []byte) error {
+			messages = append(messages, string(msg))
+			return nil
+		}),
+	}
+	conf.URL, _ = url.Parse(server.URL)
+	conn := twstream.NewConnection(conf, &twurlrc.Credentials{})
+	if err := conn.Read(); err != io.EOF {
+		t.Fatalf("Expected EOF, got %v", err)
+	}
+	if len(messages) != 1 || messages[0] != `{"a": 1}` {
+		t.Errorf("Unexpected messages %q", messages)
+	}
+}
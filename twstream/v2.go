@@ -0,0 +1,187 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Twitter API v2 stream endpoints.
+const (
+	V2SampleURL = "https://api.twitter.com/2/tweets/sample/stream"
+	V2FilterURL = "https://api.twitter.com/2/tweets/search/stream"
+	V2RulesURL  = "https://api.twitter.com/2/tweets/search/stream/rules"
+)
+
+// Returns a Connection to the v2 sampled stream, a random sample of about
+// 1% of public Tweets.  Requests are authorized with bearerToken.  Payloads
+// are passed to the V2Handler if one is set, and to any other handlers and
+// sink as for v1 streams.  Conf is copied as by NewSampleStream, and Chunked
+// and GZip are always set.
+func NewV2SampleStream(bearerToken string, conf *Configuration) *Connection {
+	conn := newEndpointStream(V2SampleURL, "GET", nil, conf)
+	conn.conf.BearerToken = bearerToken
+	return conn
+}
+
+// Returns a Connection to the v2 filtered stream, which delivers Tweets
+// matching the rules managed with a RulesClient.  Requests are authorized
+// with bearerToken.  Conf is copied as by NewSampleStream, and Chunked and
+// GZip are always set.
+func NewV2FilterStream(bearerToken string, conf *Configuration) *Connection {
+	conn := newEndpointStream(V2FilterURL, "GET", nil, conf)
+	conn.conf.BearerToken = bearerToken
+	return conn
+}
+
+// Selects the expansions and fields included in v2 stream payloads.  Each
+// list is sent as a comma separated parameter, such as tweet.fields.
+type FieldParams struct {
+	Expansions  []string
+	TweetFields []string
+	UserFields  []string
+	MediaFields []string
+	PlaceFields []string
+	PollFields  []string
+}
+
+// Returns the query parameters for the selected fields.
+func (p *FieldParams) Values() url.Values {
+	values := url.Values{}
+	lists := map[string][]string{
+		"expansions":   p.Expansions,
+		"tweet.fields": p.TweetFields,
+		"user.fields":  p.UserFields,
+		"media.fields": p.MediaFields,
+		"place.fields": p.PlaceFields,
+		"poll.fields":  p.PollFields,
+	}
+	for key, list := range lists {
+		if len(list) > 0 {
+			values.Set(key, strings.Join(list, ","))
+		}
+	}
+	return values
+}
+
+// A Tweet as returned by the v2 API.  Which fields are populated depends on
+// the tweet.fields requested.
+type V2Tweet struct {
+	ID               string              `json:"id"`
+	Text             string              `json:"text"`
+	AuthorID         string              `json:"author_id"`
+	CreatedAt        string              `json:"created_at"`
+	ConversationID   string              `json:"conversation_id"`
+	InReplyToUserID  string              `json:"in_reply_to_user_id"`
+	Lang             string              `json:"lang"`
+	ReferencedTweets []V2ReferencedTweet `json:"referenced_tweets"`
+}
+
+type V2ReferencedTweet struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+type V2User struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Username string `json:"username"`
+}
+
+type V2Media struct {
+	MediaKey string `json:"media_key"`
+	Type     string `json:"type"`
+	URL      string `json:"url"`
+}
+
+// Objects referenced by a payload's expansions.
+type V2Includes struct {
+	Tweets []V2Tweet `json:"tweets"`
+	Users  []V2User  `json:"users"`
+	Media  []V2Media `json:"media"`
+}
+
+// Identifies a filtered stream rule which matched a Tweet.
+type V2MatchingRule struct {
+	ID  string `json:"id"`
+	Tag string `json:"tag"`
+}
+
+// An error reported by the v2 API, either in a REST response or as a stream
+// payload, for example before an operational disconnect.
+type V2Error struct {
+	Title          string `json:"title"`
+	Detail         string `json:"detail"`
+	Type           string `json:"type"`
+	Value          string `json:"value"`
+	DisconnectType string `json:"disconnect_type"`
+}
+
+func (e *V2Error) Error() string {
+	if e.Detail != "" {
+		return fmt.Sprintf("%v: %v", e.Title, e.Detail)
+	}
+	return e.Title
+}
+
+// A list of errors reported by the v2 API.
+type V2Errors []V2Error
+
+func (e V2Errors) Error() string {
+	messages := make([]string, len(e))
+	for i := range e {
+		messages[i] = e[i].Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// A payload from a v2 stream.  Data is nil for payloads which only report
+// Errors.
+type V2Message struct {
+	Data          *V2Tweet         `json:"data"`
+	Includes      *V2Includes      `json:"includes"`
+	MatchingRules []V2MatchingRule `json:"matching_rules"`
+	Errors        V2Errors         `json:"errors"`
+
+	// The undecoded JSON payload this message was parsed from.
+	Raw json.RawMessage `json:"-"`
+}
+
+// Parses a single v2 stream payload.  The payload is copied into the Raw
+// field of the result.
+func DecodeV2Message(msg []byte) (*V2Message, error) {
+	message := &V2Message{}
+	if err := json.Unmarshal(msg, message); err != nil {
+		return nil, err
+	}
+	message.Raw = append(json.RawMessage(nil), msg...)
+	return message, nil
+}
+
+// Receives decoded payloads from a v2 stream.  Returning a non-nil error
+// stops the stream and causes Read to return that error.
+type V2Handler interface {
+	HandleV2Message(msg *V2Message) error
+}
+
+// Adapts an ordinary function to the V2Handler interface.
+type V2HandlerFunc func(msg *V2Message) error
+
+func (f V2HandlerFunc) HandleV2Message(msg *V2Message) error {
+	return f(msg)
+}
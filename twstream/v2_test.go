@@ -0,0 +1,125 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"errors"
+	"io"
+	"testing"
+)
+
+const V2_TWEET_JSON = `{"data":{"id":"1067094924124872705","text":"Just getting started",` +
+	`"author_id":"2244994945"},"includes":{"users":[{"id":"2244994945",` +
+	`"name":"Twitter Dev","username":"TwitterDev"}]},` +
+	`"matching_rules":[{"id":"1166916266197536768","tag":"started"}]}`
+
+const V2_ERROR_JSON = `{"errors":[{"title":"operational-disconnect",` +
+	`"disconnect_type":"UpstreamOperationalDisconnect",` +
+	`"detail":"This stream has been disconnected upstream for operational reasons.",` +
+	`"type":"https://api.twitter.com/2/problems/operational-disconnect"}]}`
+
+func TestV2FilterStream(t *testing.T) {
+	var messages []*V2Message
+	conf := &Configuration{
+		Fields: &FieldParams{
+			Expansions:  []string{"author_id"},
+			TweetFields: []string{"created_at", "lang"},
+		},
+		V2Handler: V2HandlerFunc(func(msg *V2Message) error {
+			messages = append(messages, msg)
+			return nil
+		}),
+	}
+	conn := NewV2FilterStream("bearer", conf)
+	conn.conf.Dialer = &StubDialer{Response: "HTTP/1.1 200 OK\r\n" +
+		"Transfer-Encoding: chunked\r\n\r\n" +
+		chunk(V2_TWEET_JSON+"\r\n"+V2_ERROR_JSON+"\r\n", 64)}
+	if err := conn.Read(); err != io.EOF {
+		t.Fatalf("Expected EOF, got %v", err)
+	}
+	req := sentRequest(t, conn)
+	if auth := req.Header.Get("Authorization"); auth != "Bearer bearer" {
+		t.Errorf("Expected bearer authorization, got %q", auth)
+	}
+	if req.URL.Path != "/2/tweets/search/stream" {
+		t.Errorf("Unexpected path %v", req.URL.Path)
+	}
+	query := req.URL.Query()
+	if query.Get("expansions") != "author_id" || query.Get("tweet.fields") != "created_at,lang" {
+		t.Errorf("Unexpected query %v", req.URL.RawQuery)
+	}
+	if len(messages) != 2 {
+		t.Fatalf("Expected 2 messages, got %v", len(messages))
+	}
+	tweet := messages[0]
+	if tweet.Data.ID != "1067094924124872705" || tweet.Data.AuthorID != "2244994945" {
+		t.Errorf("Unexpected tweet %+v", tweet.Data)
+	}
+	if len(tweet.Includes.Users) != 1 || tweet.Includes.Users[0].Username != "TwitterDev" {
+		t.Errorf("Unexpected includes %+v", tweet.Includes)
+	}
+	if len(tweet.MatchingRules) != 1 || tweet.MatchingRules[0].Tag != "started" {
+		t.Errorf("Unexpected matching rules %+v", tweet.MatchingRules)
+	}
+	if string(tweet.Raw) != V2_TWEET_JSON {
+		t.Errorf("Unexpected raw payload %s", tweet.Raw)
+	}
+	errs := messages[1]
+	if errs.Data != nil || len(errs.Errors) != 1 || errs.Errors[0].DisconnectType != "UpstreamOperationalDisconnect" {
+		t.Errorf("Unexpected error message %+v", errs)
+	}
+}
+
+func TestV2StreamWithoutToken(t *testing.T) {
+	conn := NewV2SampleStream("", &Configuration{Handler: &CollectingHandler{}})
+	conn.conf.Dialer = &StubDialer{Response: "HTTP/1.1 200 OK\r\n\r\n"}
+	err := conn.Run()
+	if !errors.Is(err, ErrNoCredentials) || Classify(err) != ClassConfig {
+		t.Errorf("Expected ErrNoCredentials, got %v", err)
+	}
+}
+
+func TestV2SampleStream(t *testing.T) {
+	var tweets []*V2Tweet
+	sink := NewChanSink(10)
+	conf := &Configuration{
+		Fields: &FieldParams{TweetFields: []string{"created_at"}},
+		Sink:   sink,
+		V2Handler: V2HandlerFunc(func(msg *V2Message) error {
+			tweets = append(tweets, msg.Data)
+			return nil
+		}),
+	}
+	conn := NewV2SampleStream("bearer", conf)
+	conn.conf.Dialer = &StubDialer{Response: "HTTP/1.1 200 OK\r\n" +
+		"Transfer-Encoding: chunked\r\n\r\n" +
+		chunk(`{"data":{"id":"1","text":"hi","created_at":"2022-01-01T00:00:00.000Z"}}`+"\r\n", 16)}
+	if err := conn.Read(); err != io.EOF {
+		t.Fatalf("Expected EOF, got %v", err)
+	}
+	req := sentRequest(t, conn)
+	if req.URL.Path != "/2/tweets/sample/stream" || req.URL.Query().Get("tweet.fields") != "created_at" {
+		t.Errorf("Unexpected request %v", req.URL)
+	}
+	if req.Header.Get("Authorization") != "Bearer bearer" {
+		t.Errorf("Expected bearer authorization, got %q", req.Header.Get("Authorization"))
+	}
+	if len(tweets) != 1 || tweets[0].ID != "1" || tweets[0].CreatedAt != "2022-01-01T00:00:00.000Z" {
+		t.Errorf("Unexpected tweets %+v", tweets)
+	}
+	if len(sink.C) != 1 {
+		t.Errorf("Expected payload to be written to the sink, got %v", len(sink.C))
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Restarts connections which have gone silent without failing, such as a
+// half-open TCP connection whose peer has vanished, or a stream which still
+// sends keepalives but has stopped delivering messages.  The watchdog
+// checks the stream's cadence from a separate goroutine, so unlike
+// ReadIdleTimeout it does not depend on reads returning.
+//
+// A stalled connection is closed and Read returns ErrStalled, after which
+// Run reconnects.  If LifecycleEvents is set, a *Stalled event is sent to
+// the EventHandler first.
+type Watchdog struct {
+	// Restarts the connection if no data, including keepalives, is
+	// received for this long.  Twitter sends keepalives every 30 seconds,
+	// so 90 seconds is a reasonable choice.  Zero disables the check.
+	KeepaliveTimeout time.Duration
+
+	// Restarts the connection if no messages, not counting keepalives, are
+	// received for this long.  Only suitable for streams busy enough that
+	// silence indicates a fault.  Zero disables the check.
+	MessageTimeout time.Duration
+
+	// How often the cadence is checked.  Defaults to a quarter of the
+	// shortest timeout.
+	Interval time.Duration
+}
+
+// Sent to the EventHandler, if LifecycleEvents is set, when the Watchdog
+// restarts a connection.  Messages is true if messages stopped while data
+// was still being received, and Silence is how long nothing had arrived.
+type Stalled struct {
+	Silence  time.Duration
+	Messages bool
+}
+
+func (w *Watchdog) interval() time.Duration {
+	if w.Interval > 0 {
+		return w.Interval
+	}
+	shortest := w.KeepaliveTimeout
+	if shortest <= 0 || (w.MessageTimeout > 0 && w.MessageTimeout < shortest) {
+		shortest = w.MessageTimeout
+	}
+	if interval := shortest / 4; interval > 0 {
+		return interval
+	}
+	return time.Millisecond
+}
+
+// Checks the connection opened at start, returning a *Stalled if it has been
+// silent for too long.
+func (w *Watchdog) check(c *Connection, start time.Time) *Stalled {
+	now := c.now()
+	if w.KeepaliveTimeout > 0 {
+		last := latest(start, atomic.LoadInt64(&c.counters.lastRead))
+		if silence := now.Sub(last); silence >= w.KeepaliveTimeout {
+			return &Stalled{Silence: silence}
+		}
+	}
+	if w.MessageTimeout > 0 {
+		last := latest(start, atomic.LoadInt64(&c.counters.lastMessage))
+		if silence := now.Sub(last); silence >= w.MessageTimeout {
+			return &Stalled{Silence: silence, Messages: true}
+		}
+	}
+	return nil
+}
+
+// Returns the later of t and the time given in nanoseconds since the epoch.
+func latest(t time.Time, nanos int64) time.Time {
+	if other := time.Unix(0, nanos); nanos != 0 && other.After(t) {
+		return other
+	}
+	return t
+}
+
+// Starts watching the current connection, returning a function which stops
+// the watchdog.
+func (c *Connection) watch(w *Watchdog) func() {
+	start := c.now()
+	done := make(chan struct{})
+	go func() {
+		for {
+			tick, timer := c.after(w.interval())
+			select {
+			case <-done:
+				timer.Stop()
+				return
+			case <-tick:
+			}
+			if stalled := w.check(c, start); stalled != nil {
+				c.event(stalled)
+				c.abort(ErrStalled)
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+	}
+}
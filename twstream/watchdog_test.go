@@ -0,0 +1,109 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// Serves a response, then writes line every 10ms count times before
+// waiting for the client to close.
+func cadenceServer(line string, count int) *PipeDialer {
+	return &PipeDialer{Server: func(server net.Conn) {
+		if err := respond(server, "HTTP/1.1 200 OK\r\n\r\n"); err != nil {
+			return
+		}
+		for i := 0; i < count; i++ {
+			time.Sleep(10 * time.Millisecond)
+			if _, err := io.WriteString(server, line); err != nil {
+				return
+			}
+		}
+		io.Copy(io.Discard, server)
+	}}
+}
+
+func TestWatchdogKeepaliveTimeout(t *testing.T) {
+	var stalled *Stalled
+	conf := &Configuration{
+		Watchdog:        &Watchdog{KeepaliveTimeout: 50 * time.Millisecond},
+		Dialer:          cadenceServer("\r\n", 0),
+		Handler:         &CollectingHandler{},
+		LifecycleEvents: true,
+		EventHandler: EventHandlerFunc(func(event Event) {
+			if s, ok := event.(*Stalled); ok {
+				stalled = s
+			}
+		}),
+	}
+	conn := newStubConnection(conf, "")
+	if err := conn.Read(); err != ErrStalled {
+		t.Fatalf("Expected ErrStalled, got %v", err)
+	}
+	if stalled == nil || stalled.Messages || stalled.Silence < 50*time.Millisecond {
+		t.Errorf("Unexpected event %+v", stalled)
+	}
+}
+
+func TestWatchdogMessageTimeout(t *testing.T) {
+	var stalled *Stalled
+	conf := &Configuration{
+		Watchdog:        &Watchdog{KeepaliveTimeout: 50 * time.Millisecond, MessageTimeout: 80 * time.Millisecond},
+		Dialer:          cadenceServer("\r\n", 100),
+		Handler:         &CollectingHandler{},
+		LifecycleEvents: true,
+		EventHandler: EventHandlerFunc(func(event Event) {
+			if s, ok := event.(*Stalled); ok {
+				stalled = s
+			}
+		}),
+	}
+	conn := newStubConnection(conf, "")
+	if err := conn.Read(); err != ErrStalled {
+		t.Fatalf("Expected ErrStalled, got %v", err)
+	}
+	if stalled == nil || !stalled.Messages {
+		t.Errorf("Expected a message stall, got %+v", stalled)
+	}
+}
+
+func TestWatchdogHealthy(t *testing.T) {
+	handler := &CollectingHandler{}
+	conf := &Configuration{
+		Watchdog: &Watchdog{KeepaliveTimeout: 50 * time.Millisecond, MessageTimeout: 50 * time.Millisecond},
+		Dialer: &PipeDialer{Server: func(server net.Conn) {
+			if err := respond(server, "HTTP/1.1 200 OK\r\n\r\n"); err != nil {
+				return
+			}
+			for i := 0; i < 10; i++ {
+				time.Sleep(10 * time.Millisecond)
+				if _, err := io.WriteString(server, "{\"a\": 1}\r\n"); err != nil {
+					return
+				}
+			}
+		}},
+		Handler: handler,
+	}
+	conn := newStubConnection(conf, "")
+	if err := conn.Read(); err != io.EOF {
+		t.Fatalf("Expected EOF, got %v", err)
+	}
+	if len(handler.Messages) != 10 {
+		t.Errorf("Expected 10 messages, got %v", len(handler.Messages))
+	}
+}
@@ -0,0 +1,162 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// The header carrying the HMAC-SHA256 signature of a webhook request body,
+// as "sha256=" followed by the hex encoded signature.
+const WebhookSignatureHeader = "X-Twstream-Signature"
+
+// A QueueSink which POSTs messages to an HTTP endpoint, such as a
+// serverless function.  If BatchSize is greater than 1, messages are sent
+// in batches as a JSON array, otherwise each message is sent alone as the
+// request body.  A message is acknowledged by a 2xx response.
+//
+// Failed requests are retried up to Retries times, waiting RetryDelay
+// before the first retry and doubling the wait for each further one.
+// Responses with status 4xx, other than 429, are not retried.  If Secret is
+// set, each request is signed with it in the WebhookSignatureHeader.
+// Waits are timed by Clock, which defaults to SystemClock.
+type WebhookSink struct {
+	URL        string
+	Client     *http.Client
+	Secret     []byte
+	BatchSize  int
+	Retries    int
+	RetryDelay time.Duration
+	Clock      Clock
+	lock       sync.Mutex
+	batch      bytes.Buffer
+	count      int
+}
+
+// Returns a WebhookSink which posts each message to url, signed with
+// secret if it is not nil, retrying failed requests 3 times.
+func NewWebhookSink(url string, secret []byte) *WebhookSink {
+	return &WebhookSink{
+		URL:        url,
+		Secret:     secret,
+		Retries:    3,
+		RetryDelay: time.Second,
+	}
+}
+
+func (s *WebhookSink) Write(msg []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.BatchSize <= 1 {
+		return s.post(msg)
+	}
+	if s.count == 0 {
+		s.batch.WriteByte('[')
+	} else {
+		s.batch.WriteByte(',')
+	}
+	s.batch.Write(msg)
+	s.count++
+	if s.count < s.BatchSize {
+		return nil
+	}
+	return s.flush()
+}
+
+// Posts any buffered messages.
+func (s *WebhookSink) Flush() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.flush()
+}
+
+// Flushes the sink, which holds no other resources.
+func (s *WebhookSink) Close() error {
+	return s.Flush()
+}
+
+// Posts the buffered batch.  A failed batch is kept and sent again by the
+// next flush.
+func (s *WebhookSink) flush() error {
+	if s.count == 0 {
+		return nil
+	}
+	s.batch.WriteByte(']')
+	if err := s.post(s.batch.Bytes()); err != nil {
+		s.batch.Truncate(s.batch.Len() - 1)
+		return err
+	}
+	s.batch.Reset()
+	s.count = 0
+	return nil
+}
+
+// Posts body, retrying failures.
+func (s *WebhookSink) post(body []byte) error {
+	delay := s.RetryDelay
+	for attempt := 0; ; attempt++ {
+		retry, err := s.send(body)
+		if err == nil || !retry || attempt >= s.Retries {
+			return err
+		}
+		wake, _ := after(s.Clock, delay)
+		<-wake
+		delay *= 2
+	}
+}
+
+// Sends a single request, reporting whether a failure may be retried.
+func (s *WebhookSink) send(body []byte) (bool, error) {
+	req, err := http.NewRequest("POST", s.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.Secret != nil {
+		req.Header.Set(WebhookSignatureHeader, "sha256="+SignWebhook(s.Secret, body))
+	}
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+	return retry, fmt.Errorf("Webhook responded with status %v", resp.Status)
+}
+
+// Returns the hex encoded HMAC-SHA256 signature of body with secret, as
+// sent in the WebhookSignatureHeader.  Receivers should compare signatures
+// with hmac.Equal.
+func SignWebhook(secret []byte, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
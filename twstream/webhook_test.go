@@ -0,0 +1,128 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Records webhook request bodies, failing the first Failures requests.
+type WebhookReceiver struct {
+	Failures int
+	Status   int
+	lock     sync.Mutex
+	Bodies   []string
+	Headers  []http.Header
+}
+
+func (r *WebhookReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	body, _ := io.ReadAll(req.Body)
+	if r.Failures > 0 {
+		r.Failures--
+		w.WriteHeader(r.Status)
+		return
+	}
+	r.Bodies = append(r.Bodies, string(body))
+	r.Headers = append(r.Headers, req.Header)
+}
+
+func TestWebhookSink(t *testing.T) {
+	receiver := &WebhookReceiver{Failures: 2, Status: http.StatusBadGateway}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+	secret := []byte("secret")
+	sink := NewWebhookSink(server.URL, secret)
+	sink.RetryDelay = time.Millisecond
+	conn := newStubConnection(&Configuration{Sink: sink}, "HTTP/1.1 200 OK\r\n\r\n{\"a\": 1}\r\n")
+	if err := conn.Read(); err != io.EOF {
+		t.Fatalf("Expected EOF, got %v", err)
+	}
+	if len(receiver.Bodies) != 1 || receiver.Bodies[0] != "{\"a\": 1}" {
+		t.Fatalf("Expected message after retries, got %q", receiver.Bodies)
+	}
+	expected := "sha256=" + SignWebhook(secret, []byte("{\"a\": 1}"))
+	if signature := receiver.Headers[0].Get(WebhookSignatureHeader); signature != expected {
+		t.Errorf("Expected signature %v, got %v", expected, signature)
+	}
+}
+
+func TestWebhookSinkRetryClock(t *testing.T) {
+	receiver := &WebhookReceiver{Failures: 2, Status: http.StatusBadGateway}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+	clock := NewFakeClock(time.Unix(1349740800, 0))
+	sink := NewWebhookSink(server.URL, nil)
+	sink.RetryDelay = time.Hour
+	sink.Clock = clock
+	written := make(chan error)
+	go func() {
+		written <- sink.Write([]byte("{\"a\": 1}"))
+	}()
+	// Retries wait an hour, then two, on the sink's Clock.
+	for _, delay := range []time.Duration{time.Hour, 2 * time.Hour} {
+		waitForTimers(t, clock, 1)
+		clock.Advance(delay)
+	}
+	select {
+	case err := <-written:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Write did not return after the clock advanced")
+	}
+	if len(receiver.Bodies) != 1 {
+		t.Errorf("Expected message after retries, got %q", receiver.Bodies)
+	}
+}
+
+func TestWebhookSinkBatches(t *testing.T) {
+	receiver := &WebhookReceiver{}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+	sink := &WebhookSink{URL: server.URL, BatchSize: 2}
+	response := "HTTP/1.1 200 OK\r\n\r\n{\"a\": 1}\r\n{\"b\": 2}\r\n{\"c\": 3}\r\n"
+	conn := newStubConnection(&Configuration{Sink: sink}, response)
+	if err := conn.Read(); err != io.EOF {
+		t.Fatalf("Expected EOF, got %v", err)
+	}
+	expected := []string{"[{\"a\": 1},{\"b\": 2}]", "[{\"c\": 3}]"}
+	if len(receiver.Bodies) != 2 || receiver.Bodies[0] != expected[0] || receiver.Bodies[1] != expected[1] {
+		t.Errorf("Expected %q, got %q", expected, receiver.Bodies)
+	}
+	if signature := receiver.Headers[0].Get(WebhookSignatureHeader); signature != "" {
+		t.Errorf("Expected unsigned requests, got %v", signature)
+	}
+}
+
+func TestWebhookSinkRejected(t *testing.T) {
+	receiver := &WebhookReceiver{Failures: 1, Status: http.StatusForbidden}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+	sink := NewWebhookSink(server.URL, nil)
+	if err := sink.Write([]byte("{\"a\": 1}")); err == nil {
+		t.Fatalf("Expected error for 403 response")
+	}
+	if receiver.Failures != 0 || len(receiver.Bodies) != 0 {
+		t.Errorf("Expected a single attempt, got %q", receiver.Bodies)
+	}
+}
@@ -0,0 +1,322 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The GUID appended to a WebSocket key to compute the handshake response.
+const webSocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WebSocket frame opcodes.
+const (
+	wsText  = 0x1
+	wsClose = 0x8
+	wsPing  = 0x9
+	wsPong  = 0xa
+)
+
+// WebSocket close status codes.
+const (
+	wsNormal    = 1000
+	wsGoingAway = 1001
+	wsPolicy    = 1008
+)
+
+// Re-serves the messages written to it to WebSocket clients, so that browser
+// dashboards can subscribe to a collector without a separate relay.  A
+// WebSocketBridge is a Sink, to set as a Configuration's Sink or to
+// subscribe to a Broker, and an http.Handler, conventionally mounted at
+// "/stream":
+//
+//	bridge := twstream.NewWebSocketBridge(1000)
+//	http.Handle("/stream", bridge)
+//	conf.Sink = bridge
+//
+// Each message is sent to every client as a text frame.  Every client has a
+// buffer of BufferSize messages, and a client which falls so far behind
+// that its buffer is full is evicted: its connection is closed with status
+// 1008 once the buffered messages have been sent.
+type WebSocketBridge struct {
+	BufferSize int
+
+	// Limits the time taken to send a frame to a client, which is evicted
+	// if it is exceeded.  Defaults to 10 seconds.
+	WriteTimeout time.Duration
+
+	lock    sync.Mutex
+	clients map[*wsClient]bool
+	closed  bool
+	evicted uint64
+}
+
+type wsClient struct {
+	conn   net.Conn
+	c      chan []byte
+	code   int
+	writes sync.Mutex
+}
+
+// Returns a WebSocketBridge buffering up to bufferSize messages per client.
+func NewWebSocketBridge(bufferSize int) *WebSocketBridge {
+	return &WebSocketBridge{BufferSize: bufferSize}
+}
+
+// Sends msg to every connected client, evicting clients whose buffer is
+// full.  Never blocks on slow clients.
+func (b *WebSocketBridge) Write(msg []byte) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	var shared []byte
+	for client := range b.clients {
+		if shared == nil {
+			shared = append([]byte(nil), msg...)
+		}
+		select {
+		case client.c <- shared:
+		default:
+			atomic.AddUint64(&b.evicted, 1)
+			b.remove(client, wsPolicy)
+		}
+	}
+	return nil
+}
+
+// Returns the number of connected clients.
+func (b *WebSocketBridge) Clients() int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return len(b.clients)
+}
+
+// Returns the number of clients evicted for falling behind.
+func (b *WebSocketBridge) Evicted() uint64 {
+	return atomic.LoadUint64(&b.evicted)
+}
+
+// Disconnects all clients with status 1001 and refuses new ones.
+func (b *WebSocketBridge) Close() error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.closed = true
+	for client := range b.clients {
+		b.remove(client, wsGoingAway)
+	}
+	return nil
+}
+
+// Removes client, which then sends its buffered messages and closes with
+// the given status.  Must be called with the lock held.
+func (b *WebSocketBridge) remove(client *wsClient, code int) {
+	if b.clients[client] {
+		delete(b.clients, client)
+		client.code = code
+		close(client.c)
+	}
+}
+
+// Performs the WebSocket handshake and streams messages to the client until
+// it disconnects or is evicted.
+func (b *WebSocketBridge) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if r.Method != "GET" || key == "" ||
+		!headerContains(r.Header, "Connection", "upgrade") ||
+		!headerContains(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "WebSocket upgrade required", http.StatusBadRequest)
+		return
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "Unsupported WebSocket version", http.StatusUpgradeRequired)
+		return
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "WebSocket not supported", http.StatusInternalServerError)
+		return
+	}
+	b.lock.Lock()
+	closed := b.closed
+	b.lock.Unlock()
+	if closed {
+		http.Error(w, "Stream closed", http.StatusServiceUnavailable)
+		return
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return
+	}
+	hash := sha1.Sum([]byte(key + webSocketGUID))
+	response := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(hash[:]) + "\r\n\r\n"
+	if _, err = io.WriteString(conn, response); err != nil {
+		conn.Close()
+		return
+	}
+	client := &wsClient{conn: conn, c: make(chan []byte, b.BufferSize), code: wsNormal}
+	b.lock.Lock()
+	if b.closed {
+		b.lock.Unlock()
+		conn.Close()
+		return
+	}
+	if b.clients == nil {
+		b.clients = map[*wsClient]bool{}
+	}
+	b.clients[client] = true
+	b.lock.Unlock()
+	go b.receive(client, rw.Reader)
+	b.send(client)
+}
+
+// Writes buffered messages to the client, then a close frame.
+func (b *WebSocketBridge) send(client *wsClient) {
+	defer client.conn.Close()
+	for msg := range client.c {
+		if err := b.writeFrame(client, wsText, msg); err != nil {
+			b.lock.Lock()
+			b.remove(client, wsPolicy)
+			b.lock.Unlock()
+			for range client.c {
+			}
+			return
+		}
+	}
+	b.lock.Lock()
+	code := client.code
+	b.lock.Unlock()
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	b.writeFrame(client, wsClose, payload)
+}
+
+// Reads frames sent by the client, answering pings, until it closes the
+// connection.
+func (b *WebSocketBridge) receive(client *wsClient, reader *bufio.Reader) {
+	defer func() {
+		b.lock.Lock()
+		b.remove(client, wsNormal)
+		b.lock.Unlock()
+	}()
+	for {
+		opcode, payload, err := readFrame(reader)
+		if err != nil {
+			return
+		}
+		switch opcode {
+		case wsClose:
+			return
+		case wsPing:
+			if b.writeFrame(client, wsPong, payload) != nil {
+				return
+			}
+		}
+	}
+}
+
+func (b *WebSocketBridge) writeFrame(client *wsClient, opcode byte, payload []byte) error {
+	client.writes.Lock()
+	defer client.writes.Unlock()
+	timeout := b.WriteTimeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	client.conn.SetWriteDeadline(time.Now().Add(timeout))
+	header := []byte{0x80 | opcode}
+	switch n := len(payload); {
+	case n < 126:
+		header = append(header, byte(n))
+	case n <= 0xffff:
+		header = binary.BigEndian.AppendUint16(append(header, 126), uint16(n))
+	default:
+		header = binary.BigEndian.AppendUint64(append(header, 127), uint64(n))
+	}
+	if _, err := client.conn.Write(header); err != nil {
+		return err
+	}
+	_, err := client.conn.Write(payload)
+	return err
+}
+
+// The largest frame accepted from a client.  Clients only need to send
+// control frames, whose payloads are at most 125 bytes.
+const maxClientFrame = 1 << 16
+
+// Reads a single masked frame sent by a client.
+func readFrame(reader *bufio.Reader) (byte, []byte, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		return 0, nil, err
+	}
+	opcode := header[0] & 0x0f
+	if header[1]&0x80 == 0 {
+		return 0, nil, errors.New("Unmasked client frame")
+	}
+	size := uint64(header[1] & 0x7f)
+	switch size {
+	case 126:
+		var extended [2]byte
+		if _, err := io.ReadFull(reader, extended[:]); err != nil {
+			return 0, nil, err
+		}
+		size = uint64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		if _, err := io.ReadFull(reader, extended[:]); err != nil {
+			return 0, nil, err
+		}
+		size = binary.BigEndian.Uint64(extended[:])
+	}
+	if size > maxClientFrame {
+		return 0, nil, errors.New("Client frame too large")
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(reader, mask[:]); err != nil {
+		return 0, nil, err
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return opcode, payload, nil
+}
+
+// Reports whether any comma separated token of the named header equals
+// token, ignoring case.
+func headerContains(header http.Header, name string, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, t := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
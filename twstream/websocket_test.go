@@ -0,0 +1,195 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A minimal WebSocket client for testing a WebSocketBridge.
+type wsTestClient struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func dialWebSocket(t *testing.T, server *httptest.Server) *wsTestClient {
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(conn, "GET /stream HTTP/1.1\r\nHost: example.com\r\n"+
+		"Upgrade: websocket\r\nConnection: Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n"+
+		"Sec-WebSocket-Version: 13\r\n\r\n")
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 101 {
+		t.Fatalf("Expected 101, got %v", resp.Status)
+	}
+	// The example key and accept value from RFC 6455.
+	if accept := resp.Header.Get("Sec-WebSocket-Accept"); accept != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("Unexpected Sec-WebSocket-Accept %q", accept)
+	}
+	return &wsTestClient{conn: conn, reader: reader}
+}
+
+// Reads an unmasked frame sent by the server.
+func (c *wsTestClient) read(t *testing.T) (byte, []byte) {
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var header [2]byte
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		t.Fatal(err)
+	}
+	size := uint64(header[1] & 0x7f)
+	switch size {
+	case 126:
+		var extended [2]byte
+		io.ReadFull(c.reader, extended[:])
+		size = uint64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		io.ReadFull(c.reader, extended[:])
+		size = binary.BigEndian.Uint64(extended[:])
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		t.Fatal(err)
+	}
+	return header[0] & 0x0f, payload
+}
+
+// Sends a masked frame.
+func (c *wsTestClient) write(opcode byte, payload []byte) {
+	mask := []byte{1, 2, 3, 4}
+	frame := []byte{0x80 | opcode, 0x80 | byte(len(payload))}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+	c.conn.Write(frame)
+}
+
+// Waits for the bridge to have the given number of clients.
+func waitForClients(t *testing.T, clients func() int, count int) {
+	for start := time.Now(); clients() != count; time.Sleep(time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("Expected %v clients, got %v", count, clients())
+		}
+	}
+}
+
+func TestWebSocketBridge(t *testing.T) {
+	bridge := NewWebSocketBridge(10)
+	server := httptest.NewServer(bridge)
+	defer server.Close()
+	client := dialWebSocket(t, server)
+	defer client.conn.Close()
+	waitForClients(t, bridge.Clients, 1)
+
+	conf := &Configuration{Sink: bridge}
+	conn := newStubConnection(conf, SINK_RESPONSE)
+	if err := conn.Read(); err != io.EOF {
+		t.Fatalf("Expected EOF, got %v", err)
+	}
+	for _, expected := range []string{"{\"a\": 1}", "{\"b\": 2}"} {
+		opcode, payload := client.read(t)
+		if opcode != wsText || string(payload) != expected {
+			t.Errorf("Expected text frame %q, got %v %q", expected, opcode, payload)
+		}
+	}
+	client.write(wsPing, []byte("hello"))
+	if opcode, payload := client.read(t); opcode != wsPong || string(payload) != "hello" {
+		t.Errorf("Expected pong, got %v %q", opcode, payload)
+	}
+	large := strings.Repeat("x", 70000)
+	bridge.Write([]byte(large))
+	if _, payload := client.read(t); string(payload) != large {
+		t.Errorf("Large message was not received intact")
+	}
+	bridge.Close()
+	opcode, payload := client.read(t)
+	if opcode != wsClose || binary.BigEndian.Uint16(payload) != wsGoingAway {
+		t.Errorf("Expected close 1001, got %v % x", opcode, payload)
+	}
+}
+
+func TestWebSocketBridgeClientClose(t *testing.T) {
+	bridge := NewWebSocketBridge(10)
+	server := httptest.NewServer(bridge)
+	defer server.Close()
+	client := dialWebSocket(t, server)
+	defer client.conn.Close()
+	waitForClients(t, bridge.Clients, 1)
+	client.write(wsClose, []byte{0x03, 0xe8})
+	if opcode, _ := client.read(t); opcode != wsClose {
+		t.Errorf("Expected close frame, got %v", opcode)
+	}
+	waitForClients(t, bridge.Clients, 0)
+}
+
+func TestWebSocketBridgeEviction(t *testing.T) {
+	bridge := NewWebSocketBridge(4)
+	server := httptest.NewServer(bridge)
+	defer server.Close()
+	client := dialWebSocket(t, server)
+	defer client.conn.Close()
+	waitForClients(t, bridge.Clients, 1)
+	// The client reads nothing until network buffers and its message buffer
+	// have filled.
+	msg := bytes.Repeat([]byte("x"), 1<<16)
+	for i := 0; bridge.Evicted() == 0; i++ {
+		if i > 10000 {
+			t.Fatalf("Slow client was not evicted")
+		}
+		bridge.Write(msg)
+	}
+	if bridge.Clients() != 0 {
+		t.Errorf("Expected evicted client to be removed")
+	}
+	for {
+		opcode, payload := client.read(t)
+		if opcode == wsClose {
+			if code := binary.BigEndian.Uint16(payload); code != wsPolicy {
+				t.Errorf("Expected close 1008, got %v", code)
+			}
+			break
+		}
+	}
+}
+
+func TestWebSocketBridgeRejectsPlainRequests(t *testing.T) {
+	server := httptest.NewServer(NewWebSocketBridge(10))
+	defer server.Close()
+	resp, err := http.Get(server.URL + "/stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected 400, got %v", resp.Status)
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"io"
+	"net/http"
+)
+
+// Observes the raw traffic of a connection, for example to debug a proxy or
+// to audit what was sent.  Callbacks are made from the goroutine reading the
+// stream, and the slices passed to them are only valid during the call.
+type WireTap interface {
+	// Called with the bytes of the request as they are written to the
+	// connection.  Not called for requests sent through HTTPClient.
+	Sent(p []byte)
+
+	// Called once the response headers have been read, whatever the status.
+	// Status is the status code followed by the reason, as in "200 OK".
+	HeadersReceived(status string, header http.Header)
+
+	// Called with the bytes of the response body as they are read, after
+	// chunked transfer encoding is removed but before decompression.
+	BodyReceived(p []byte)
+}
+
+// Passes bytes written to the wrapped writer to a WireTap.
+type tapWriter struct {
+	writer io.Writer
+	tap    WireTap
+}
+
+func (w *tapWriter) Write(p []byte) (n int, err error) {
+	n, err = w.writer.Write(p)
+	if n > 0 {
+		w.tap.Sent(p[:n])
+	}
+	return n, err
+}
+
+// Passes bytes read from the wrapped reader to a WireTap.
+type tapReader struct {
+	reader io.Reader
+	tap    WireTap
+}
+
+func (r *tapReader) Read(p []byte) (n int, err error) {
+	n, err = r.reader.Read(p)
+	if n > 0 {
+		r.tap.BodyReceived(p[:n])
+	}
+	return n, err
+}
@@ -0,0 +1,101 @@
+// Copyright 2012 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package twstream
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// Records the traffic passed to a WireTap.
+type RecordingTap struct {
+	Request bytes.Buffer
+	Status  string
+	Header  http.Header
+	Body    bytes.Buffer
+}
+
+func (t *RecordingTap) Sent(p []byte) {
+	t.Request.Write(p)
+}
+
+func (t *RecordingTap) HeadersReceived(status string, header http.Header) {
+	t.Status = status
+	t.Header = header
+}
+
+func (t *RecordingTap) BodyReceived(p []byte) {
+	t.Body.Write(p)
+}
+
+func TestWireTap(t *testing.T) {
+	tap := &RecordingTap{}
+	handler := &CollectingHandler{}
+	conf := &Configuration{
+		Chunked: true,
+		WireTap: tap,
+		Handler: handler,
+	}
+	response := "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n" +
+		"5\r\n{\"a\":\r\n" +
+		"5\r\n 1}\r\n\r\n" +
+		"0\r\n\r\n"
+	conn := newStubConnection(conf, response)
+	if err := conn.Read(); err != io.EOF {
+		t.Fatalf("Expected EOF, got %v", err)
+	}
+	if !strings.HasPrefix(tap.Request.String(), "GET /1/statuses/sample.json") {
+		t.Errorf("Unexpected request %q", tap.Request.String())
+	}
+	if tap.Status != "200 OK" {
+		t.Errorf("Expected status 200 OK, got %q", tap.Status)
+	}
+	if tap.Header.Get("Transfer-Encoding") != "chunked" {
+		t.Errorf("Unexpected headers %v", tap.Header)
+	}
+	if tap.Body.String() != "{\"a\": 1}\r\n" {
+		t.Errorf("Unexpected body %q", tap.Body.String())
+	}
+	if len(handler.Messages) != 1 {
+		t.Errorf("Tap consumed messages, got %q", handler.Messages)
+	}
+}
+
+func TestWireTapErrorStatus(t *testing.T) {
+	tap := &RecordingTap{}
+	conf := &Configuration{
+		WireTap: tap,
+		Handler: &CollectingHandler{},
+	}
+	response := "HTTP/1.1 420 Enhance Your Calm\r\nRetry-After: 60\r\n\r\n"
+	conn := newStubConnection(conf, response)
+	var status *StatusError
+	if err := conn.Read(); !errors.As(err, &status) {
+		t.Fatalf("Expected StatusError, got %v", err)
+	}
+	if tap.Status != "420 Enhance Your Calm" {
+		t.Errorf("Expected status 420 Enhance Your Calm, got %q", tap.Status)
+	}
+	if tap.Header.Get("Retry-After") != "60" {
+		t.Errorf("Unexpected headers %v", tap.Header)
+	}
+	if tap.Body.Len() != 0 {
+		t.Errorf("Unexpected body %q", tap.Body.String())
+	}
+}